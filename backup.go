@@ -0,0 +1,221 @@
+package lunar
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+)
+
+var (
+	backupMagic = []byte("LUNARBK1")
+)
+
+var (
+	// ErrInvalidBackup the backup stream is not valid
+	ErrInvalidBackup = errors.New("invalid backup")
+	// ErrInvalidPosition the position is beyond the end of the database
+	ErrInvalidPosition = errors.New("invalid position")
+)
+
+// Backup writes a consistent snapshot of the database to a writer, while
+// allowing writes to continue. If since is zero, all records are written,
+// otherwise only records written after since are written. The returned
+// position can be used as since to take an incremental backup.
+// When using a single data file, compaction will invalidate any
+// previously returned position. Compaction will be blocked
+// until the backup is complete
+func (db *DB) Backup(w io.Writer, since uint64) (uint64, error) {
+	db.snapshot.RLock()
+	defer db.snapshot.RUnlock()
+
+	// capture the end of every segment before
+	// writing, so the snapshot is consistent
+	s, ends, release := db.freeze()
+	defer release()
+
+	sid, soff := db.location(since)
+
+	if _, ok := ends[sid]; sid > s.active || ok && soff > ends[sid] {
+		return 0, ErrInvalidPosition
+	}
+
+	bw := bufio.NewWriter(w)
+
+	_, err := bw.Write(backupMagic)
+	if err != nil {
+		return 0, err
+	}
+
+	pos := since
+
+	for _, id := range s.ids() {
+		if id < sid {
+			continue
+		}
+
+		var from int64
+
+		if id == sid {
+			from = soff
+		}
+
+		var batch [][]byte
+
+		end, err := scanRange(s.tables[id], from, ends[id], func(h *header.Header, data []byte, offset int64) error {
+			key := data[header.HeaderSize:h.DataOffset()]
+
+			rec, err := db.inline(data)
+			if db.superseded(err, key, id, offset) || db.oversized(err, key) {
+				rec, err = nil, nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if h.Xmin() == 0 && len(batch) == 0 {
+				if rec == nil {
+					return nil
+				}
+
+				_, err = bw.Write(rec)
+				return err
+			}
+
+			if rec != nil {
+				batch = append(batch, append([]byte{}, rec...))
+			}
+
+			if h.Xmin() > 0 {
+				return nil
+			}
+
+			// the records of a batch are written once it is complete, so the
+			// xmin of each only counts the records that were not left out
+			for i := range batch {
+				setXmin(batch[i], uint64(len(batch)-i-1))
+
+				_, err = bw.Write(batch[i])
+				if err != nil {
+					return err
+				}
+			}
+
+			batch = batch[:0]
+
+			return nil
+		})
+
+		if err != nil {
+			return 0, err
+		}
+
+		pos = db.position(id, end)
+
+		// a record has not finished being written, so the backup
+		// will stop here and resume from this position next time
+		if end < ends[id] {
+			break
+		}
+	}
+
+	trailer := make([]byte, header.HeaderSize+8)
+	binary.LittleEndian.PutUint64(trailer[header.HeaderSize:], pos)
+
+	_, err = bw.Write(trailer)
+	if err != nil {
+		return 0, err
+	}
+
+	return pos, bw.Flush()
+}
+
+// Restore rebuilds a database from a backup. A full backup should
+// be restored to a new database, followed by any incremental backups
+// in the order they were taken. The same options used to open the
+// database should be provided
+func Restore(r io.Reader, path string, opts ...func(*DB) error) error {
+	db, err := Open(path, opts...)
+	if err != nil {
+		return err
+	}
+
+	err = db.restore(r)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	return db.Close()
+}
+
+func (db *DB) restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(backupMagic))
+
+	_, err := io.ReadFull(br, magic)
+	if err != nil || !bytes.Equal(magic, backupMagic) {
+		return ErrInvalidBackup
+	}
+
+	hd := make([]byte, header.HeaderSize)
+
+	var keys, records [][]byte
+
+	for {
+		_, err := io.ReadFull(br, hd)
+		if err != nil {
+			return ErrInvalidBackup
+		}
+
+		// the end of the backup is marked by an empty header
+		if empty(hd) {
+			_, err = io.ReadFull(br, make([]byte, 8))
+			if err != nil {
+				return ErrInvalidBackup
+			}
+
+			return nil
+		}
+
+		h := header.Deserialize(hd)
+
+		if !sane(h, 0, table.MaxStep) {
+			return ErrInvalidBackup
+		}
+
+		data := make([]byte, h.TotalSize())
+		copy(data, hd)
+
+		_, err = io.ReadFull(br, data[header.HeaderSize:])
+		if err != nil {
+			return ErrInvalidBackup
+		}
+
+		if !header.Valid(data) {
+			return ErrCorrupt
+		}
+
+		// records from a batch are written together once the batch is complete
+		keys = append(keys, data[header.HeaderSize:h.DataOffset()])
+		records = append(records, data)
+
+		if h.Xmin() > 0 {
+			continue
+		}
+
+		err = db.putAll(keys, records)
+		if err != nil {
+			return err
+		}
+
+		keys = keys[:0]
+		records = records[:0]
+	}
+}
@@ -0,0 +1,239 @@
+package lunar
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	defer os.Remove("test-restore.db")
+
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	var full, incremental bytes.Buffer
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	// write while the backup is taken
+	go func() {
+		for i := 100; i < 1000; i++ {
+			err := db.Sets(fmt.Sprintf("test-key-%d", i), []byte(fmt.Sprintf("test-%d", i)))
+			assert.Nil(t, err)
+		}
+		wg.Done()
+	}()
+
+	pos, err := db.Backup(&full, 0)
+	require.Nil(t, err)
+	assert.True(t, pos > 0)
+
+	wg.Wait()
+
+	err = db.Sets("test-key-0", []byte("test-1234"))
+	require.Nil(t, err)
+
+	next, err := db.Backup(&incremental, pos)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(db.active().Position()), next)
+
+	_, err = db.Backup(&bytes.Buffer{}, next+1)
+	assert.Equal(t, ErrInvalidPosition, err)
+
+	err = Restore(&full, "test-restore.db")
+	require.Nil(t, err)
+
+	err = Restore(&incremental, "test-restore.db")
+	require.Nil(t, err)
+
+	rdb, err := Open("test-restore.db")
+	require.Nil(t, err)
+
+	defer rdb.Close()
+
+	data, err := rdb.Gets("test-key-0")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-1234"), data)
+
+	for i := 1; i < 1000; i++ {
+		data, err := rdb.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("test-%d", i)), data)
+	}
+
+	err = Restore(bytes.NewReader([]byte("invalid")), "test-restore.db")
+	assert.Equal(t, ErrInvalidBackup, err)
+}
+
+func TestBackupSegments(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+	defer os.RemoveAll("test-restore")
+
+	db, err := Open("test-segments", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	var full, incremental bytes.Buffer
+
+	pos, err := db.Backup(&full, 0)
+	require.Nil(t, err)
+
+	for i := 20; i < 40; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	_, err = db.Backup(&incremental, pos)
+	require.Nil(t, err)
+
+	assert.True(t, incremental.Len() < full.Len()*2)
+
+	err = Restore(&full, "test-restore", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	err = Restore(&incremental, "test-restore", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	rdb, err := Open("test-restore", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	defer rdb.Close()
+
+	for i := 0; i < 40; i++ {
+		data, err := rdb.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("test-%d", i)), data)
+	}
+}
+
+// blockingWriter blocks writes until it is released
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	return len(p), nil
+}
+
+func TestBackupDoesNotBlockWrites(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key", []byte("test")))
+
+	w := &blockingWriter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	done := make(chan error)
+
+	go func() {
+		_, err := db.Backup(w, 0)
+		done <- err
+	}()
+
+	<-w.started
+
+	// writes that take the exclusive lock complete while the backup is streamed
+	var b Batch
+	b.Set([]byte("test-key-2"), []byte("test"))
+
+	require.Nil(t, db.Apply(&b))
+
+	_, err = db.CompareAndSwap([]byte("test-key-3"), []byte("test"), 0)
+	require.Nil(t, err)
+
+	value, err := db.Gets("test-key-2")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), value)
+
+	close(w.release)
+
+	require.Nil(t, <-done)
+}
+
+func TestBackupConcurrentGrowth(t *testing.T) {
+	defer os.Remove("test-restore.db")
+
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	done := grow(t, db)
+
+	for backups := 0; ; backups++ {
+		select {
+		case <-done:
+			if backups > 0 {
+				return
+			}
+		default:
+		}
+
+		var buf bytes.Buffer
+
+		_, err := db.Backup(&buf, 0)
+		require.Nil(t, err)
+
+		// every record in the backup has been completely written
+		os.Remove("test-restore.db")
+		require.Nil(t, Restore(&buf, "test-restore.db"))
+	}
+}
+
+// grow writes large values from several goroutines, so the data
+// file is remapped while it is read. the returned channel is
+// closed once all of the values have been written
+func grow(t *testing.T, db *DB) chan struct{} {
+	var wg sync.WaitGroup
+
+	done := make(chan struct{})
+	value := bytes.Repeat([]byte("v"), 1<<18)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for x := 0; x < 32; x++ {
+				assert.Nil(t, db.Sets(fmt.Sprintf("test-key-%d-%d", i, x), value))
+			}
+		}(i)
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	return done
+}
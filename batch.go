@@ -0,0 +1,177 @@
+package lunar
+
+import (
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+)
+
+// Batch a group of writes that are applied to the database atomically
+type Batch struct {
+	keys    [][]byte
+	records [][]byte
+}
+
+// Set sets a value by key when the batch is applied
+func (b *Batch) Set(key, value []byte) {
+	var h header.Header
+	b.add(key, record(&h, key, value))
+}
+
+// SetTTL sets a value by key when the batch is applied, which will expire after the given duration
+func (b *Batch) SetTTL(key, value []byte, ttl time.Duration) {
+	var h header.Header
+	h.SetExpires(time.Now().Add(ttl).UnixNano())
+	b.add(key, record(&h, key, value))
+}
+
+// Delete deletes a value by key when the batch is applied
+func (b *Batch) Delete(key []byte) {
+	var h header.Header
+	h.SetXmax(1)
+	b.add(key, record(&h, key, nil))
+}
+
+// Len returns the number of writes in the batch
+func (b *Batch) Len() int {
+	return len(b.records)
+}
+
+// Reset removes all writes from the batch
+func (b *Batch) Reset() {
+	b.keys = b.keys[:0]
+	b.records = b.records[:0]
+}
+
+func (b *Batch) add(key, data []byte) {
+	// reference the key stored in the record, so the callers key can be reused
+	b.keys = append(b.keys, data[header.HeaderSize:header.HeaderSize+len(key)])
+	b.records = append(b.records, data)
+}
+
+// Apply writes a batch to the database. Either all or none of the
+// batch's writes will be visible, including after a crash
+func (db *DB) Apply(b *Batch) error {
+	if len(b.records) < 1 {
+		return nil
+	}
+
+	for _, key := range b.keys {
+		err := checkKey(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	// records written by a batch store the number of
+	// records that follow them in the batch as their xmin
+	for i := range b.records {
+		setXmin(b.records[i], uint64(len(b.records)-i-1))
+	}
+
+	return db.putAll(b.keys, b.records)
+}
+
+// putAll writes serialized records contiguously to the
+// active segment and indexes them, blocking reads until
+// all of the records have been indexed
+func (db *DB) putAll(keys, records [][]byte) error {
+	start := time.Now()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	err := db.writable()
+	if err != nil {
+		return err
+	}
+
+	err = db.insertAll(keys, records)
+
+	db.metrics.Set(time.Since(start))
+
+	return err
+}
+
+// insertAll writes serialized records contiguously and indexes them.
+// db.mu must be held exclusively
+func (db *DB) insertAll(keys, records [][]byte) error {
+	var size int
+
+	for _, key := range keys {
+		if len(key) < 1 {
+			return ErrEmptyKey
+		}
+	}
+
+	// the callers records are not modified, so a batch can be applied again
+	records = append([][]byte{}, records...)
+
+	for i := range records {
+		var err error
+
+		records[i], err = db.separate(db.link(keys[i], records[i]))
+		if err != nil {
+			return err
+		}
+
+		size = size + len(records[i])
+	}
+
+	data := make([]byte, 0, size)
+
+	for i := range records {
+		data = append(data, records[i]...)
+	}
+
+	seg, off, err := db.write(data)
+	if err != nil {
+		return err
+	}
+
+	for i := range records {
+		h := header.Deserialize(records[i])
+
+		db.setEntry(keys[i], &entry{
+			segment:   seg,
+			size:      int64(len(records[i])),
+			offset:    off,
+			tombstone: h.Xmax() > 0,
+			expires:   h.Expires(),
+		})
+
+		db.reindex(keys[i], records[i])
+
+		off = off + int64(len(records[i]))
+	}
+
+	return nil
+}
+
+// detach returns a record that is no longer part of a batch,
+// so it can be written independently of the rest of the batch
+func detach(data []byte) []byte {
+	if header.Deserialize(data).Xmin() == 0 {
+		return data
+	}
+
+	record := make([]byte, len(data))
+	copy(record, data)
+	setXmin(record, 0)
+
+	return record
+}
+
+// setXmin updates the xmin of a serialized record
+func setXmin(data []byte, xmin uint64) {
+	h := header.Deserialize(data)
+
+	if h.Xmin() == xmin {
+		return
+	}
+
+	h.SetXmin(xmin)
+
+	copy(data, header.Serialize(h))
+	header.Seal(data)
+}
@@ -0,0 +1,79 @@
+package lunar
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	err = db.Sets("test-key-0", []byte("test"))
+	require.Nil(t, err)
+
+	var b Batch
+
+	for i := 1; i < 10; i++ {
+		b.Set([]byte(fmt.Sprintf("test-key-%d", i)), []byte(fmt.Sprintf("test-%d", i)))
+	}
+
+	b.Delete([]byte("test-key-0"))
+
+	assert.Equal(t, 10, b.Len())
+
+	err = db.Apply(&b)
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key-0")
+	assert.Equal(t, ErrNotFound, err)
+
+	for i := 1; i < 10; i++ {
+		data, err := db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("test-%d", i)), data)
+	}
+
+	pos := db.active().Position()
+
+	// a batch that is only partially written is discarded
+	b.Reset()
+	b.Set([]byte("test-key-10"), []byte("test-10"))
+	b.Set([]byte("test-key-11"), []byte("test-11"))
+
+	err = db.Apply(&b)
+	require.Nil(t, err)
+
+	e := db.lookup([]byte("test-key-11"))
+
+	require.Nil(t, db.Close())
+
+	fd, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	require.Nil(t, err)
+
+	_, err = fd.WriteAt(make([]byte, e.size), e.offset)
+	require.Nil(t, err)
+
+	require.Nil(t, fd.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	assert.Equal(t, pos, db.active().Position())
+
+	_, err = db.Gets("test-key-10")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = db.Gets("test-key-0")
+	assert.Equal(t, ErrNotFound, err)
+
+	data, err := db.Gets("test-key-9")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-9"), data)
+}
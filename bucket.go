@@ -0,0 +1,401 @@
+package lunar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+)
+
+const (
+	// bucketActive the bucket's keys can be read and written
+	bucketActive byte = iota
+	// bucketDropped the bucket and all of its keys have been removed
+	bucketDropped
+)
+
+var (
+	// ErrBucketNotFound the bucket does not exist
+	ErrBucketNotFound = errors.New("bucket not found")
+
+	// internalPrefix the prefix of keys used internally, which are not returned by Scan
+	internalPrefix = []byte("\x00lunar\x00")
+	// bucketPrefix the prefix of the keys stored in buckets, followed by the bucket's id
+	bucketPrefix = []byte("\x00lunar\x00b")
+	// bucketsPrefix the prefix of the keys that store the id and state of each bucket
+	bucketsPrefix = []byte("\x00lunar\x00n")
+)
+
+// Bucket a namespace of keys within a database. A bucket is
+// created when a key is first written to it
+type Bucket struct {
+	db   *DB
+	name string
+}
+
+// Bucket returns the bucket with the given name
+func (db *DB) Bucket(name string) *Bucket {
+	return &Bucket{db: db, name: name}
+}
+
+// Buckets returns the names of all buckets in name order
+func (db *DB) Buckets() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var names []string
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		if !bytes.HasPrefix(key, bucketsPrefix) {
+			return
+		}
+
+		_, ok := db.bucketID(key)
+		if ok {
+			names = append(names, string(key[len(bucketsPrefix):]))
+		}
+	})
+
+	return names
+}
+
+// DropBucket removes a bucket and all of its keys. Only a single record
+// is written to mark the bucket as dropped, and its keys are removed
+// from the data files by compaction
+func (db *DB) DropBucket(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	err := db.writable()
+	if err != nil {
+		return err
+	}
+
+	id, ok := db.bucketID(bucketsKey(name))
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	err = db.setBucket(name, id, bucketDropped)
+	if err != nil {
+		return err
+	}
+
+	prefix := bucketKey(id, nil)
+
+	var keys [][]byte
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		if v != nil && bytes.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	})
+
+	for _, key := range keys {
+		db.setEntry(key, nil)
+	}
+
+	return nil
+}
+
+// Name returns the name of the bucket
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Get get a value by key
+func (b *Bucket) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+
+	b.db.mu.RLock()
+	defer b.db.mu.RUnlock()
+
+	var value []byte
+
+	err := ErrNotFound
+
+	id, ok := b.db.bucketID(bucketsKey(b.name))
+	if ok {
+		value, _, err = b.db.get(bucketKey(id, key))
+	}
+
+	b.db.metrics.Get(time.Since(start))
+
+	return value, err
+}
+
+// Set set value by key
+func (b *Bucket) Set(key, value []byte) error {
+	var h header.Header
+
+	return b.put(key, &h, value)
+}
+
+// SetTTL set value by key, which will expire after the given duration
+func (b *Bucket) SetTTL(key, value []byte, ttl time.Duration) error {
+	var h header.Header
+	h.SetExpires(time.Now().Add(ttl).UnixNano())
+
+	return b.put(key, &h, value)
+}
+
+// Delete delete a value by key
+func (b *Bucket) Delete(key []byte) error {
+	b.db.mu.RLock()
+	id, ok := b.db.bucketID(bucketsKey(b.name))
+	b.db.mu.RUnlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	e := b.db.lookup(bucketKey(id, key))
+	if e == nil || !e.live() {
+		return ErrNotFound
+	}
+
+	var h header.Header
+
+	// deleted records are marked with an xmax
+	h.SetXmax(1)
+
+	return b.put(key, &h, nil)
+}
+
+// Expires returns the time a key expires, or the zero time if it does not expire
+func (b *Bucket) Expires(key []byte) (time.Time, error) {
+	b.db.mu.RLock()
+	id, ok := b.db.bucketID(bucketsKey(b.name))
+	b.db.mu.RUnlock()
+
+	if !ok {
+		return time.Time{}, ErrNotFound
+	}
+
+	return b.db.Expires(bucketKey(id, key))
+}
+
+// Gets get a value by string key
+func (b *Bucket) Gets(key string) ([]byte, error) {
+	return b.Get([]byte(key))
+}
+
+// Sets set a value by string key
+func (b *Bucket) Sets(key string, value []byte) error {
+	return b.Set([]byte(key), value)
+}
+
+// Deletes deletes a value by string key
+func (b *Bucket) Deletes(key string) error {
+	return b.Delete([]byte(key))
+}
+
+// Scan calls fn for every key in the bucket with the given prefix and its value,
+// in key order. If fn returns an error, iteration stops and the error is returned
+func (b *Bucket) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	b.db.mu.RLock()
+	id, ok := b.db.bucketID(bucketsKey(b.name))
+	b.db.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	bk := bucketKey(id, nil)
+
+	return b.db.scan(bucketKey(id, prefix), func(key, value []byte) error {
+		return fn(key[len(bk):], value)
+	})
+}
+
+// Apply writes a batch to the bucket, creating the bucket if it does not
+// exist. Either all or none of the batch's writes will be visible
+func (b *Bucket) Apply(batch *Batch) error {
+	if len(batch.records) < 1 {
+		return nil
+	}
+
+	db := b.db
+	start := time.Now()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	err := db.writable()
+	if err != nil {
+		return err
+	}
+
+	id, err := db.createBucket(b.name)
+	if err != nil {
+		return err
+	}
+
+	keys := make([][]byte, len(batch.records))
+	records := make([][]byte, len(batch.records))
+
+	for i, data := range batch.records {
+		h := header.Deserialize(data)
+
+		keys[i] = bucketKey(id, batch.keys[i])
+		records[i] = record(h, keys[i], data[h.DataOffset():h.TotalSize()])
+
+		setXmin(records[i], uint64(len(records)-i-1))
+	}
+
+	err = db.insertAll(keys, records)
+
+	db.metrics.Set(time.Since(start))
+
+	return err
+}
+
+// put writes a record to the bucket, creating the bucket if it does not exist
+func (b *Bucket) put(key []byte, h *header.Header, value []byte) error {
+	start := time.Now()
+
+	err := b.write(key, h, value)
+
+	b.db.metrics.Set(time.Since(start))
+
+	return err
+}
+
+// write writes a record to the bucket, creating the bucket if it does not exist
+func (b *Bucket) write(key []byte, h *header.Header, value []byte) error {
+	db := b.db
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	err := db.writable()
+	if err != nil {
+		return err
+	}
+
+	id, err := db.createBucket(b.name)
+	if err != nil {
+		return err
+	}
+
+	k := bucketKey(id, key)
+
+	data, err := db.separate(record(h, k, value))
+	if err != nil {
+		return err
+	}
+
+	_, _, err = db.insert(k, data)
+
+	return err
+}
+
+// createBucket returns the id of a bucket, creating it if it
+// does not exist. db.mu must be held
+func (db *DB) createBucket(name string) (uint64, error) {
+	id, ok := db.bucketID(bucketsKey(name))
+	if ok {
+		return id, nil
+	}
+
+	db.bucketMu.Lock()
+	defer db.bucketMu.Unlock()
+
+	id, ok = db.bucketID(bucketsKey(name))
+	if ok {
+		return id, nil
+	}
+
+	// ids of dropped buckets are not reused, so their keys
+	// can be distinguished until they have been compacted
+	var last uint64
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		if !bytes.HasPrefix(key, bucketsPrefix) {
+			return
+		}
+
+		value, _, err := db.get(key)
+		if err == nil && len(value) == 9 && binary.BigEndian.Uint64(value) > last {
+			last = binary.BigEndian.Uint64(value)
+		}
+	})
+
+	return last + 1, db.setBucket(name, last+1, bucketActive)
+}
+
+// setBucket writes the id and state of a bucket. db.mu must be held
+func (db *DB) setBucket(name string, id uint64, state byte) error {
+	value := make([]byte, 9)
+	binary.BigEndian.PutUint64(value, id)
+	value[8] = state
+
+	var h header.Header
+
+	key := bucketsKey(name)
+
+	_, _, err := db.insert(key, record(&h, key, value))
+
+	return err
+}
+
+// bucketID returns the id of an active bucket from its key. db.mu must be held
+func (db *DB) bucketID(key []byte) (uint64, bool) {
+	value, _, err := db.get(key)
+	if err != nil || len(value) != 9 || value[8] != bucketActive {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(value), true
+}
+
+// purgeBuckets removes the keys of dropped buckets from the index,
+// so they will be removed from the data files by compaction
+func (db *DB) purgeBuckets() {
+	active := make(map[uint64]bool)
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		if !bytes.HasPrefix(key, bucketsPrefix) {
+			return
+		}
+
+		id, ok := db.bucketID(key)
+		if ok {
+			active[id] = true
+		}
+	})
+
+	var keys [][]byte
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		if v == nil || !bytes.HasPrefix(key, bucketPrefix) || len(key) < len(bucketPrefix)+8 {
+			return
+		}
+
+		if !active[binary.BigEndian.Uint64(key[len(bucketPrefix):])] {
+			keys = append(keys, key)
+		}
+	})
+
+	for _, key := range keys {
+		db.setEntry(key, nil)
+	}
+}
+
+// bucketKey returns the key a bucket's key is stored at
+func bucketKey(id uint64, key []byte) []byte {
+	k := make([]byte, len(bucketPrefix)+8+len(key))
+
+	copy(k, bucketPrefix)
+	binary.BigEndian.PutUint64(k[len(bucketPrefix):], id)
+	copy(k[len(bucketPrefix)+8:], key)
+
+	return k
+}
+
+// bucketsKey returns the key a bucket's id and state are stored at
+func bucketsKey(name string) []byte {
+	return append(append([]byte{}, bucketsPrefix...), name...)
+}
@@ -0,0 +1,151 @@
+package lunar
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuckets(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	users := db.Bucket("users")
+	orders := db.Bucket("orders")
+
+	_, err = users.Gets("test-key")
+	assert.Equal(t, ErrNotFound, err)
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, users.Sets(fmt.Sprintf("test-key-%d", i), []byte("user")))
+		require.Nil(t, orders.Sets(fmt.Sprintf("test-key-%d", i), []byte("order")))
+	}
+
+	require.Nil(t, db.Sets("test-key-0", []byte("root")))
+
+	// keys are scoped to their bucket
+	value, err := users.Gets("test-key-0")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("user"), value)
+
+	value, err = orders.Gets("test-key-0")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("order"), value)
+
+	value, err = db.Gets("test-key-0")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("root"), value)
+
+	require.Nil(t, users.Deletes("test-key-1"))
+	assert.Equal(t, ErrNotFound, users.Deletes("test-key-1"))
+
+	var keys []string
+
+	err = users.Scan([]byte("test-key-"), func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+
+	require.Nil(t, err)
+	assert.Len(t, keys, 9)
+	assert.Equal(t, "test-key-0", keys[0])
+
+	// bucket keys are not included in scans of the database
+	var count int
+
+	err = db.Scan(nil, func(key, value []byte) error {
+		count++
+		return nil
+	})
+
+	require.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	assert.Equal(t, []string{"orders", "users"}, db.Buckets())
+
+	// dropping a bucket removes all of its keys
+	used := db.Stats().Used
+
+	require.Nil(t, db.DropBucket("users"))
+	assert.Equal(t, ErrBucketNotFound, db.DropBucket("users"))
+
+	// only a single record is written
+	assert.Less(t, db.Stats().Used-used, int64(100))
+
+	assert.Equal(t, []string{"orders"}, db.Buckets())
+
+	_, err = users.Gets("test-key-0")
+	assert.Equal(t, ErrNotFound, err)
+
+	// a new bucket with the same name does not contain the dropped keys
+	require.Nil(t, users.Sets("test-key-10", []byte("user")))
+
+	_, err = users.Gets("test-key-0")
+	assert.Equal(t, ErrNotFound, err)
+
+	// buckets persist after reopening
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	users = db.Bucket("users")
+
+	_, err = users.Gets("test-key-0")
+	assert.Equal(t, ErrNotFound, err)
+
+	value, err = users.Gets("test-key-10")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("user"), value)
+
+	value, err = db.Bucket("orders").Gets("test-key-9")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("order"), value)
+
+	// the dropped keys are removed by compaction
+	require.Nil(t, db.Compact())
+
+	assert.Equal(t, 1+1+10+2, db.Stats().Keys)
+}
+
+func TestBucketApply(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	users := db.Bucket("users")
+
+	require.Nil(t, users.Sets("test-key-0", []byte("old")))
+
+	var b Batch
+	b.Set([]byte("test-key-1"), []byte("user"))
+	b.Delete([]byte("test-key-0"))
+
+	require.Nil(t, users.Apply(&b))
+
+	value, err := users.Gets("test-key-1")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("user"), value)
+
+	_, err = users.Gets("test-key-0")
+	assert.Equal(t, ErrNotFound, err)
+
+	// the batch is not written to the database's keys
+	_, err = db.Gets("test-key-1")
+	assert.Equal(t, ErrNotFound, err)
+
+	// the batch is still applied after the database is reopened
+	db.Close()
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	value, err = db.Bucket("users").Gets("test-key-1")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("user"), value)
+}
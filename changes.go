@@ -0,0 +1,368 @@
+package lunar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+)
+
+const (
+	// watchBufferSize the number of events buffered by a watch channel
+	watchBufferSize = 64
+	// changesReadSize the maximum number of events read at a time
+	changesReadSize = 1024
+)
+
+// EventType the type of change made to a key
+type EventType int
+
+const (
+	// EventPut a key's value was set
+	EventPut EventType = iota
+	// EventDelete a key was deleted
+	EventDelete
+	// EventMerge a merge operand was written to a key
+	EventMerge
+)
+
+// Event a change made to a key
+type Event struct {
+	Type     EventType
+	Key      []byte
+	Value    []byte
+	Version  uint64 // version of the key's value, zero if the key was deleted
+	Position uint64 // position following the record, which changes can be resumed from
+	record   []byte // the serialized record, which the key and value are read from
+}
+
+// Changes reads every change made to the database in the
+// order it was written, waiting for new changes to be written
+type Changes struct {
+	db       *DB
+	segment  uint32
+	offset   int64
+	table    *table.Table // the table being read, used to detect when it is replaced by compaction
+	pending  []*Event
+	position uint64 // position following the last event returned
+}
+
+// Changes returns a reader of all changes made after a position. A position of
+// zero reads all changes that have not been compacted. The position should be
+// zero, or have been returned by Backup or an Event. Records moved by compaction
+// are read again as new changes. If compaction removes deleted or expired keys
+// that have not been read, the position is invalidated. When using a single data
+// file, compaction will invalidate the position of the reader and any previously
+// returned position
+func (db *DB) Changes(from uint64) (*Changes, error) {
+	s := db.current()
+
+	sid, soff := db.location(from)
+
+	if t := s.tables[sid]; sid > s.active || t != nil && soff > t.Position() {
+		return nil, ErrInvalidPosition
+	}
+
+	if s.tables[sid] == nil && from != 0 && from < atomic.LoadUint64(&db.dropped) {
+		return nil, ErrInvalidPosition
+	}
+
+	return &Changes{
+		db:       db,
+		segment:  sid,
+		offset:   soff,
+		position: from,
+	}, nil
+}
+
+// Next returns the next change, waiting until one
+// is written or the context is cancelled
+func (c *Changes) Next(ctx context.Context) (*Event, error) {
+	for len(c.pending) < 1 {
+		// start waiting before reading, so no writes are missed
+		changed := c.db.wait()
+
+		err := c.read()
+		if err != nil {
+			c.db.release()
+			return nil, err
+		}
+
+		if len(c.pending) > 0 {
+			c.db.release()
+			break
+		}
+
+		select {
+		case <-changed:
+			c.db.release()
+		case <-ctx.Done():
+			c.db.release()
+			return nil, ctx.Err()
+		}
+	}
+
+	e := c.pending[0]
+	c.pending[0] = nil
+	c.pending = c.pending[1:]
+	c.position = e.Position
+
+	return e, nil
+}
+
+// Position returns the position following the last change
+// returned by Next, which changes can be resumed from
+func (c *Changes) Position() uint64 {
+	return c.position
+}
+
+// read reads all complete records written after the reader's position
+func (c *Changes) read() error {
+	c.db.mu.RLock()
+	defer c.db.mu.RUnlock()
+
+	var batch []*Event
+
+	pos := c.offset
+
+	for len(c.pending) < changesReadSize {
+		s := c.db.current()
+
+		t := s.tables[c.segment]
+
+		if t == nil || c.table != nil && t != c.table {
+			// a single data file has been replaced by compaction
+			if !c.db.segmented() {
+				return ErrInvalidPosition
+			}
+
+			// the segment has been removed by compaction, after its live
+			// records were moved to a newer segment. deleted records that
+			// have not been read may have been removed with it
+			if c.lost() || !c.next(s) {
+				return ErrInvalidPosition
+			}
+
+			pos = 0
+			batch = nil
+
+			continue
+		}
+
+		c.table = t
+
+		if pos >= t.Position() {
+			// all records in a sealed segment have been read
+			if c.segment != s.active && len(batch) == 0 && c.next(s) {
+				pos = 0
+				continue
+			}
+
+			return nil
+		}
+
+		e, h, err := c.record(t, pos)
+		if err != nil {
+			return err
+		}
+
+		pos = pos + h.TotalSize()
+
+		// records from a batch are only read once the whole batch has been written
+		if e != nil {
+			batch = append(batch, e)
+		}
+
+		if h.Xmin() > 0 {
+			continue
+		}
+
+		// the xmin of each record only counts the records that were not left out
+		for i := range batch {
+			setXmin(batch[i].record, uint64(len(batch)-i-1))
+		}
+
+		c.pending = append(c.pending, batch...)
+		c.offset = pos
+		batch = nil
+	}
+
+	return nil
+}
+
+// lost returns true if compaction has removed deleted
+// records that follow the reader's position
+func (c *Changes) lost() bool {
+	if c.position == 0 && len(c.pending) == 0 {
+		// nothing has been read, so the removed records are not needed
+		return false
+	}
+
+	return c.db.position(c.segment, c.offset) < atomic.LoadUint64(&c.db.dropped)
+}
+
+// record reads the record at an offset, which is before the table's position.
+// an error is returned if the record or its value cannot be read,
+// and no event if the record has been superseded
+func (c *Changes) record(t *table.Table, offset int64) (*Event, *header.Header, error) {
+	h, data := written(t, offset)
+
+	if data == nil {
+		// wait for records that are being copied to the data file. the
+		// record is before the table's position, so it must then be complete
+		c.db.writes.Lock()
+		c.db.writes.Unlock()
+
+		h, data = written(t, offset)
+		if data == nil {
+			return nil, nil, fmt.Errorf("%w: invalid record at offset %d of segment %d", ErrCorrupt, offset, c.segment)
+		}
+	}
+
+	// copy the record out of the mapping, as the
+	// segment may be removed by compaction
+	rec := make([]byte, len(data))
+	copy(rec, data)
+
+	// values stored in value files are included in the event
+	key := data[header.HeaderSize:h.DataOffset()]
+
+	rec, err := c.db.inline(rec)
+	if c.db.superseded(err, key, c.segment, offset) || c.db.oversized(err, key) {
+		return nil, h, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dh := header.Deserialize(rec)
+
+	e := Event{
+		Key:      rec[header.HeaderSize:dh.DataOffset():dh.DataOffset()],
+		Value:    rec[dh.DataOffset():],
+		Position: c.db.position(c.segment, offset+h.TotalSize()),
+		record:   rec,
+	}
+
+	if h.Merge() {
+		e.Type = EventMerge
+	}
+
+	if h.Xmax() > 0 {
+		e.Type = EventDelete
+		e.Value = nil
+	} else {
+		e.Version = versionOf(c.db.position(c.segment, offset), h.Checksum())
+	}
+
+	return &e, h, nil
+}
+
+// written returns the record at an offset of a table,
+// or nil if it is not complete or its checksum is invalid
+func written(t *table.Table, offset int64) (*header.Header, []byte) {
+	if offset+header.HeaderSize > t.Size() {
+		return nil, nil
+	}
+
+	hd, err := t.Read(header.HeaderSize, offset)
+	if err != nil || empty(hd) {
+		return nil, nil
+	}
+
+	h := header.Deserialize(hd)
+
+	if !sane(h, offset, t.Size()) {
+		return nil, nil
+	}
+
+	data, err := t.Read(h.TotalSize(), offset)
+	if err != nil || !header.Valid(data) {
+		return nil, nil
+	}
+
+	return h, data
+}
+
+// next moves the reader to the start of the next segment
+func (c *Changes) next(s *segments) bool {
+	for _, id := range s.ids() {
+		if id > c.segment {
+			c.segment = id
+			c.offset = 0
+			c.table = nil
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watch sends every change made to keys with the given prefix to the returned
+// channel, starting from the current end of the database. The channel is closed
+// when the context is cancelled, or if the changes can no longer be read
+// because a single data file has been compacted
+func (db *DB) Watch(ctx context.Context, prefix []byte) <-chan Event {
+	ch := make(chan Event, watchBufferSize)
+
+	s := db.current()
+
+	c := &Changes{
+		db:      db,
+		segment: s.active,
+		offset:  s.tables[s.active].Position(),
+	}
+
+	c.position = db.position(c.segment, c.offset)
+
+	go func() {
+		defer close(ch)
+
+		for {
+			e, err := c.Next(ctx)
+			if err != nil {
+				return
+			}
+
+			if !bytes.HasPrefix(e.Key, prefix) || bytes.HasPrefix(e.Key, internalPrefix) {
+				continue
+			}
+
+			select {
+			case ch <- *e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// wait returns a channel that is closed when the next record is written.
+// release must be called once the caller has finished waiting
+func (db *DB) wait() <-chan struct{} {
+	atomic.AddInt32(&db.waiters, 1)
+	return *(*chan struct{})(atomic.LoadPointer(&db.changed))
+}
+
+// release stops waiting for records to be written
+func (db *DB) release() {
+	atomic.AddInt32(&db.waiters, -1)
+}
+
+// notify wakes any readers waiting for records to be written
+func (db *DB) notify() {
+	if atomic.LoadInt32(&db.waiters) < 1 {
+		return
+	}
+
+	changed := make(chan struct{})
+
+	close(*(*chan struct{})(atomic.SwapPointer(&db.changed, unsafe.Pointer(&changed))))
+}
@@ -0,0 +1,263 @@
+package lunar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChanges(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key-1", []byte("test-1")))
+	require.Nil(t, db.Sets("test-key-2", []byte("test-2")))
+	require.Nil(t, db.Deletes("test-key-1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := db.Changes(0)
+	require.Nil(t, err)
+
+	e, err := c.Next(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, EventPut, e.Type)
+	assert.Equal(t, []byte("test-key-1"), e.Key)
+	assert.Equal(t, []byte("test-1"), e.Value)
+
+	e, err = c.Next(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, EventPut, e.Type)
+	assert.Equal(t, []byte("test-key-2"), e.Key)
+
+	version, err := db.Version([]byte("test-key-2"))
+	require.Nil(t, err)
+	assert.Equal(t, version, e.Version)
+
+	e, err = c.Next(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, EventDelete, e.Type)
+	assert.Equal(t, []byte("test-key-1"), e.Key)
+	assert.Nil(t, e.Value)
+	assert.Equal(t, e.Position, c.Position())
+
+	// wait for a new change
+	go db.Sets("test-key-3", []byte("test-3"))
+
+	e, err = c.Next(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-key-3"), e.Key)
+
+	// resume from the last change
+	c, err = db.Changes(c.Position())
+	require.Nil(t, err)
+
+	short, stop := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer stop()
+
+	_, err = c.Next(short)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	_, err = db.Changes(c.Position() + 1)
+	assert.Equal(t, ErrInvalidPosition, err)
+}
+
+func TestChangesBatch(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := db.Changes(0)
+	require.Nil(t, err)
+
+	var b Batch
+
+	for i := 0; i < 10; i++ {
+		b.Set([]byte(fmt.Sprintf("test-key-%d", i)), []byte("test"))
+	}
+
+	require.Nil(t, db.Apply(&b))
+
+	for i := 0; i < 10; i++ {
+		e, err := c.Next(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("test-key-%d", i)), e.Key)
+	}
+}
+
+func TestChangesSegments(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	db, err := Open("test-segments", SegmentSize(1<<12))
+	require.Nil(t, err)
+
+	defer db.Close()
+
+	value := make([]byte, 1000)
+
+	for i := 0; i < 20; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("test-key-%d", i), value))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := db.Changes(0)
+	require.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		e, err := c.Next(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("test-key-%d", i)), e.Key)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("a:0", []byte("test")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := db.Watch(ctx, []byte("a:"))
+
+	for i := 1; i < 5; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("a:%d", i), []byte("test")))
+		require.Nil(t, db.Sets(fmt.Sprintf("b:%d", i), []byte("test")))
+	}
+
+	require.Nil(t, db.Deletes("a:1"))
+
+	for i := 1; i < 5; i++ {
+		select {
+		case e := <-ch:
+			assert.Equal(t, EventPut, e.Type)
+			assert.Equal(t, []byte(fmt.Sprintf("a:%d", i)), e.Key)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+	}
+
+	select {
+	case e := <-ch:
+		assert.Equal(t, EventDelete, e.Type)
+		assert.Equal(t, []byte("a:1"), e.Key)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	cancel()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestChangesCompactedDelete(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	db, err := Open("test-segments", SegmentSize(1<<12))
+	require.Nil(t, err)
+
+	defer db.Close()
+
+	value := make([]byte, 1000)
+
+	for i := 0; i < 3; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("test-key-%d", i), value))
+	}
+
+	require.Nil(t, db.Deletes("test-key-1"))
+
+	for i := 3; i < 10; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("test-key-%d", i), value))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := db.Changes(0)
+	require.Nil(t, err)
+
+	e, err := c.Next(ctx)
+	require.Nil(t, err)
+
+	c, err = db.Changes(e.Position)
+	require.Nil(t, err)
+
+	// the delete is removed with the first segment before it is read
+	require.Nil(t, db.CompactSegment(db.current().ids()[0]))
+
+	_, err = c.Next(ctx)
+	assert.Equal(t, ErrInvalidPosition, err)
+
+	_, err = db.Changes(e.Position)
+	assert.Equal(t, ErrInvalidPosition, err)
+
+	// a reader that has not read any changes does not need the delete
+	c, err = db.Changes(0)
+	require.Nil(t, err)
+
+	_, err = c.Next(ctx)
+	require.Nil(t, err)
+}
+
+func TestChangesCorrupt(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key", []byte("test")))
+	require.Nil(t, db.Sets("test-key-2", []byte("test")))
+
+	s := db.current()
+	require.Nil(t, s.tables[s.active].WriteAt([]byte("x"), header.HeaderSize))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := db.Changes(0)
+	require.Nil(t, err)
+
+	_, err = c.Next(ctx)
+	assert.True(t, errors.Is(err, ErrCorrupt))
+}
+
+func TestWatchInternal(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := db.Watch(ctx, nil)
+
+	require.Nil(t, db.Bucket("test").Sets("a", []byte("test")))
+	require.Nil(t, db.Sets("b", []byte("test")))
+
+	select {
+	case e := <-ch:
+		assert.Equal(t, []byte("b"), e.Key)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
@@ -0,0 +1,181 @@
+package lunar
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/purehyperbole/lunar/hint"
+	"github.com/purehyperbole/lunar/table"
+)
+
+const (
+	checkpointChunkSize = 1 << 20
+)
+
+// Checkpoint creates a point in time copy of the database in a new directory.
+// Sealed segments, their hint files and value files are hard linked into the
+// directory, falling back to a copy if they cannot be linked. The active segment
+// or single data file is copied up to the position it had reached when the
+// checkpoint started, while writes continue. The checkpoint can be opened
+// with the same options as the database, using the directory as the path
+// for a segmented database, or the data file within the directory otherwise.
+// Compaction will be blocked until the checkpoint is complete
+func (db *DB) Checkpoint(dir string) error {
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	db.snapshot.RLock()
+	defer db.snapshot.RUnlock()
+
+	// freeze the position of the active segment before flushing,
+	// so all records up to that position are on disk
+	s, ends, release := db.freeze()
+	defer release()
+	end := ends[s.active]
+
+	for _, id := range s.ids() {
+		err = s.tables[id].Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range s.ids() {
+		if id == s.active {
+			continue
+		}
+
+		err = link(db.dataPath(id), filepath.Join(dir, filepath.Base(db.dataPath(id))))
+		if err != nil {
+			return err
+		}
+
+		if exists(db.hintPath(id)) {
+			err = link(db.hintPath(id), filepath.Join(dir, filepath.Base(db.hintPath(id))))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = db.checkpointValues(dir)
+	if err != nil {
+		return err
+	}
+
+	return db.checkpointActive(dir, s.active, s.tables[s.active], end)
+}
+
+// checkpointActive copies the records of the active segment up to a position
+// returned by freeze, which every record before has finished being written
+func (db *DB) checkpointActive(dir string, id uint32, t *table.Table, end int64) error {
+	fd, err := os.OpenFile(filepath.Join(dir, filepath.Base(db.dataPath(id))), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0766)
+	if err != nil {
+		return err
+	}
+
+	for pos := int64(0); pos < end; pos = pos + checkpointChunkSize {
+		size := int64(checkpointChunkSize)
+
+		if pos+size > end {
+			size = end - pos
+		}
+
+		data, err := t.Read(size, pos)
+		if err != nil {
+			fd.Close()
+			return err
+		}
+
+		_, err = fd.Write(data)
+		if err != nil {
+			fd.Close()
+			return err
+		}
+	}
+
+	err = fd.Sync()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+
+	err = fd.Close()
+	if err != nil {
+		return err
+	}
+
+	// the hint file can be used if it does not cover
+	// any records written after the checkpoint
+	f, err := hint.Open(db.hintPath(id))
+	if err != nil || f.Position() > end {
+		return nil
+	}
+
+	return link(db.hintPath(id), filepath.Join(dir, filepath.Base(db.hintPath(id))))
+}
+
+// checkpointValues links every value file into the checkpoint
+func (db *DB) checkpointValues(dir string) error {
+	ids, err := valueIDs(db.valuesPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	vdir := filepath.Join(dir, filepath.Base(db.valuesPath()))
+
+	err = os.MkdirAll(vdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = link(db.valuePath(id), filepath.Join(vdir, filepath.Base(db.valuePath(id))))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// link hard links a file, copying it if a link cannot be created
+func link(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	sfd, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer sfd.Close()
+
+	dfd, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0766)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dfd, sfd)
+	if err != nil {
+		dfd.Close()
+		return err
+	}
+
+	err = dfd.Sync()
+	if err != nil {
+		dfd.Close()
+		return err
+	}
+
+	return dfd.Close()
+}
@@ -0,0 +1,124 @@
+package lunar
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	defer os.RemoveAll("test-checkpoint")
+
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, db.Compact())
+
+	err = db.Sets("test-key-10", []byte("test-10"))
+	require.Nil(t, err)
+
+	err = db.Checkpoint("test-checkpoint")
+	require.Nil(t, err)
+
+	// writes after the checkpoint
+	err = db.Sets("test-key-0", []byte("test-1234"))
+	require.Nil(t, err)
+
+	assert.NotNil(t, db.Checkpoint("test-checkpoint"))
+
+	cdb, err := Open(filepath.Join("test-checkpoint", "test.db"))
+	require.Nil(t, err)
+
+	defer cdb.Close()
+
+	for i := 0; i < 11; i++ {
+		data, err := cdb.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("test-%d", i)), data)
+	}
+}
+
+func TestCheckpointSegments(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+	defer os.RemoveAll("test-checkpoint")
+
+	db, err := Open("test-segments", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	err = db.Checkpoint("test-checkpoint")
+	require.Nil(t, err)
+
+	err = db.Sets("test-key-0", []byte("test-1234"))
+	require.Nil(t, err)
+
+	s := db.current()
+
+	// sealed segments are hard linked, the active segment is copied
+	for _, id := range s.ids() {
+		sstat, err := os.Stat(segmentPath("test-segments", id))
+		require.Nil(t, err)
+
+		cstat, err := os.Stat(segmentPath("test-checkpoint", id))
+		require.Nil(t, err)
+
+		assert.Equal(t, id != s.active, os.SameFile(sstat, cstat))
+	}
+
+	cdb, err := Open("test-checkpoint", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	defer cdb.Close()
+
+	for i := 0; i < 20; i++ {
+		data, err := cdb.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("test-%d", i)), data)
+	}
+}
+
+func TestCheckpointConcurrentGrowth(t *testing.T) {
+	defer os.RemoveAll("test-checkpoint")
+
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	done := grow(t, db)
+
+	for checkpoints := 0; ; checkpoints++ {
+		select {
+		case <-done:
+			if checkpoints > 0 {
+				return
+			}
+		default:
+		}
+
+		os.RemoveAll("test-checkpoint")
+		require.Nil(t, db.Checkpoint("test-checkpoint"))
+
+		// every record in the checkpoint has been completely written
+		report, err := Check(filepath.Join("test-checkpoint", "test.db"))
+		require.Nil(t, err)
+		assert.True(t, report.OK(), report.Problems)
+	}
+}
@@ -0,0 +1,322 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDir = "test-cluster"
+
+func testConfig(network *MemoryNetwork, id string, peers map[string]string) Config {
+	return Config{
+		ID:                id,
+		Dir:               filepath.Join(testDir, id),
+		Transport:         network.Transport("node-" + id),
+		Peers:             peers,
+		HeartbeatInterval: time.Millisecond * 10,
+		ElectionTimeout:   time.Millisecond * 100,
+	}
+}
+
+func testCluster(t *testing.T, size int, threshold uint64) (*MemoryNetwork, map[string]*Node) {
+	network := NewMemoryNetwork()
+	peers := make(map[string]string)
+
+	for i := 1; i <= size; i++ {
+		peers[fmt.Sprint(i)] = fmt.Sprintf("node-%d", i)
+	}
+
+	nodes := make(map[string]*Node)
+
+	for id := range peers {
+		config := testConfig(network, id, peers)
+		config.SnapshotThreshold = threshold
+
+		n, err := New(config)
+		require.Nil(t, err)
+
+		nodes[id] = n
+	}
+
+	return network, nodes
+}
+
+func closeCluster(nodes map[string]*Node) {
+	for _, n := range nodes {
+		n.Close()
+	}
+
+	os.RemoveAll(testDir)
+}
+
+// waitLeader waits until all of the nodes agree that one of them is the leader
+func waitLeader(t *testing.T, nodes map[string]*Node) *Node {
+	var leader *Node
+
+	eventually(t, func() bool {
+		leader = nil
+
+		for _, n := range nodes {
+			l := nodes[n.Leader()]
+			if l == nil || leader != nil && l != leader {
+				return false
+			}
+
+			leader = l
+		}
+
+		return leader.Status().State == Leader
+	})
+
+	return leader
+}
+
+// eventually waits up to five seconds for a condition to be true
+func eventually(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestClusterReplication(t *testing.T) {
+	_, nodes := testCluster(t, 3, 0)
+	defer closeCluster(nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	leader := waitLeader(t, nodes)
+
+	require.Nil(t, leader.Set(ctx, []byte("test-key"), []byte("test")))
+
+	for _, n := range nodes {
+		value, err := n.Get(ctx, []byte("test-key"))
+		require.Nil(t, err)
+		assert.Equal(t, []byte("test"), value)
+
+		if n != leader {
+			assert.Equal(t, ErrNotLeader, n.Set(ctx, []byte("test-key"), []byte("test-2")))
+		}
+	}
+
+	var b Batch
+
+	b.Set([]byte("test-key-1"), []byte("test-1"))
+	b.Set([]byte("test-key-2"), []byte("test-2"))
+	b.Delete([]byte("test-key"))
+
+	require.Nil(t, leader.Apply(ctx, &b))
+
+	for _, n := range nodes {
+		_, err := n.Get(ctx, []byte("test-key"))
+		assert.Equal(t, lunar.ErrNotFound, err)
+
+		value, err := n.Get(ctx, []byte("test-key-2"))
+		require.Nil(t, err)
+		assert.Equal(t, []byte("test-2"), value)
+	}
+
+	assert.Equal(t, lunar.ErrNotFound, leader.Delete(ctx, []byte("test-key")))
+}
+
+func TestClusterLeaderFailure(t *testing.T) {
+	network, nodes := testCluster(t, 3, 0)
+	defer closeCluster(nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	leader := waitLeader(t, nodes)
+
+	require.Nil(t, leader.Set(ctx, []byte("test-key"), []byte("test")))
+
+	// partition the leader from the rest of the cluster
+	network.Disconnect("node-" + leader.config.ID)
+
+	remaining := make(map[string]*Node)
+
+	for id, n := range nodes {
+		if n != leader {
+			remaining[id] = n
+		}
+	}
+
+	elected := waitLeader(t, remaining)
+	assert.NotEqual(t, leader, elected)
+
+	require.Nil(t, elected.Set(ctx, []byte("test-key"), []byte("test-2")))
+
+	// writes to the old leader cannot be committed
+	short, stop := context.WithTimeout(ctx, time.Millisecond*100)
+	defer stop()
+
+	assert.NotNil(t, leader.Set(short, []byte("test-key"), []byte("test-3")))
+
+	network.Connect("node-" + leader.config.ID)
+
+	eventually(t, func() bool {
+		value, err := leader.Get(ctx, []byte("test-key"))
+		return err == nil && string(value) == "test-2"
+	})
+}
+
+func TestClusterSnapshot(t *testing.T) {
+	network, nodes := testCluster(t, 3, 10)
+	defer closeCluster(nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	leader := waitLeader(t, nodes)
+
+	for i := 0; i < 50; i++ {
+		require.Nil(t, leader.Set(ctx, []byte(fmt.Sprintf("test-key-%d", i)), []byte(fmt.Sprint(i))))
+	}
+
+	assert.True(t, leader.log.snapshotMeta().Index > 0)
+
+	// a new node receives the snapshot from the leader
+	n, err := New(testConfig(network, "4", nil))
+	require.Nil(t, err)
+
+	nodes["4"] = n
+
+	require.Nil(t, leader.AddNode(ctx, "4", "node-4"))
+
+	// reads are forwarded to the leader once the new node has heard from it
+	eventually(t, func() bool {
+		return n.Leader() != ""
+	})
+
+	for i := 0; i < 50; i++ {
+		value, err := n.Get(ctx, []byte(fmt.Sprintf("test-key-%d", i)))
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprint(i)), value)
+	}
+
+	assert.Len(t, n.Status().Members, 4)
+
+	// restart the node from its snapshot and log
+	require.Nil(t, n.Close())
+
+	n, err = New(testConfig(network, "4", nil))
+	require.Nil(t, err)
+
+	nodes["4"] = n
+
+	require.Nil(t, leader.Set(ctx, []byte("test-key-50"), []byte("50")))
+
+	for i := 0; i <= 50; i++ {
+		value, err := n.Get(ctx, []byte(fmt.Sprintf("test-key-%d", i)))
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprint(i)), value)
+	}
+}
+
+func TestClusterMembership(t *testing.T) {
+	_, nodes := testCluster(t, 3, 0)
+	defer closeCluster(nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	leader := waitLeader(t, nodes)
+
+	assert.Equal(t, ErrUnknownMember, leader.RemoveNode(ctx, "5"))
+
+	// remove a follower
+	var removed *Node
+
+	for _, n := range nodes {
+		if n != leader {
+			removed = n
+			break
+		}
+	}
+
+	require.Nil(t, leader.RemoveNode(ctx, removed.config.ID))
+	assert.Len(t, leader.Status().Members, 2)
+
+	require.Nil(t, leader.Set(ctx, []byte("test-key"), []byte("test")))
+
+	// remove the leader, which steps down once its removal is committed
+	require.Nil(t, leader.RemoveNode(ctx, leader.config.ID))
+
+	remaining := make(map[string]*Node)
+
+	for id, n := range nodes {
+		if n != leader && n != removed {
+			remaining[id] = n
+		}
+	}
+
+	elected := waitLeader(t, remaining)
+
+	assert.Len(t, elected.Status().Members, 1)
+
+	require.Nil(t, elected.Set(ctx, []byte("test-key"), []byte("test-2")))
+
+	value, err := elected.Get(ctx, []byte("test-key"))
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-2"), value)
+}
+
+func TestTCPTransport(t *testing.T) {
+	defer os.RemoveAll(testDir)
+
+	peers := make(map[string]string)
+	transports := make(map[string]*TCPTransport)
+
+	for _, id := range []string{"1", "2", "3"} {
+		tr, err := NewTCPTransport("127.0.0.1:0")
+		require.Nil(t, err)
+
+		peers[id] = tr.Addr().String()
+		transports[id] = tr
+	}
+
+	nodes := make(map[string]*Node)
+
+	for id, tr := range transports {
+		n, err := New(Config{
+			ID:                id,
+			Dir:               filepath.Join(testDir, id),
+			Transport:         tr,
+			Peers:             peers,
+			HeartbeatInterval: time.Millisecond * 10,
+			ElectionTimeout:   time.Millisecond * 100,
+		})
+		require.Nil(t, err)
+
+		nodes[id] = n
+	}
+
+	defer closeCluster(nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	leader := waitLeader(t, nodes)
+
+	require.Nil(t, leader.Set(ctx, []byte("test-key"), []byte("test")))
+
+	for _, n := range nodes {
+		value, err := n.Get(ctx, []byte("test-key"))
+		require.Nil(t, err)
+		assert.Equal(t, []byte("test"), value)
+	}
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	errInvalidCommand = errors.New("invalid command")
+)
+
+// Batch a set of writes that are applied atomically
+type Batch struct {
+	ops []op
+}
+
+// op a write in a command
+type op struct {
+	delete bool
+	key    []byte
+	value  []byte
+}
+
+// change a change to the membership of the cluster
+type change struct {
+	Remove  bool   `json:"remove,omitempty"`
+	ID      string `json:"id"`
+	Address string `json:"address,omitempty"`
+}
+
+// Set adds a write that sets a key's value
+func (b *Batch) Set(key, value []byte) {
+	b.ops = append(b.ops, op{key: key, value: value})
+}
+
+// Delete adds a write that deletes a key
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, op{delete: true, key: key})
+}
+
+// Len returns the number of writes in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset removes all writes from the batch
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// encodeCommand serializes a list of writes, each
+// as its type followed by its length prefixed key and value
+func encodeCommand(ops []op) []byte {
+	var size int
+
+	for _, o := range ops {
+		size = size + 1 + binary.MaxVarintLen64*2 + len(o.key) + len(o.value)
+	}
+
+	data := make([]byte, 0, size)
+	n := make([]byte, binary.MaxVarintLen64)
+
+	for _, o := range ops {
+		if o.delete {
+			data = append(data, 1)
+		} else {
+			data = append(data, 0)
+		}
+
+		data = append(data, n[:binary.PutUvarint(n, uint64(len(o.key)))]...)
+		data = append(data, o.key...)
+		data = append(data, n[:binary.PutUvarint(n, uint64(len(o.value)))]...)
+		data = append(data, o.value...)
+	}
+
+	return data
+}
+
+// decodeCommand deserializes a list of writes
+func decodeCommand(data []byte) ([]op, error) {
+	var ops []op
+
+	for len(data) > 0 {
+		o := op{delete: data[0] == 1}
+		data = data[1:]
+
+		var fields [2][]byte
+
+		for i := range fields {
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return nil, errInvalidCommand
+			}
+
+			fields[i] = data[n : n+int(size)]
+			data = data[n+int(size):]
+		}
+
+		o.key = fields[0]
+		o.value = fields[1]
+
+		ops = append(ops, o)
+	}
+
+	return ops, nil
+}
+
+// applyChange applies a serialized membership change to a set of members
+func applyChange(members map[string]string, data []byte) {
+	var c change
+
+	if json.Unmarshal(data, &c) != nil {
+		return
+	}
+
+	if c.Remove {
+		delete(members, c.ID)
+		return
+	}
+
+	members[c.ID] = c.Address
+}
+
+// copyMembers returns a copy of a set of members
+func copyMembers(members map[string]string) map[string]string {
+	c := make(map[string]string, len(members))
+
+	for id, address := range members {
+		c[id] = address
+	}
+
+	return c
+}
@@ -0,0 +1,382 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/purehyperbole/lunar"
+)
+
+var (
+	logPrefix      = []byte("log/")
+	termKey        = []byte("state/term")
+	voteKey        = []byte("state/vote")
+	snapshotKey    = []byte("state/snapshot")
+	dataKey        = []byte("state/data")
+	errCompacted   = errors.New("log entry has been compacted")
+	errUnavailable = errors.New("log entry is unavailable")
+)
+
+// EntryType the type of a log entry
+type EntryType uint8
+
+const (
+	// EntryNoop an entry appended by a new leader
+	EntryNoop EntryType = iota
+	// EntryCommand an entry containing writes to the database
+	EntryCommand
+	// EntryConfig an entry containing a membership change
+	EntryConfig
+)
+
+// Entry an entry in the raft log
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// snapshotMeta describes the last entry included in a snapshot
+type snapshotMeta struct {
+	Index   uint64
+	Term    uint64
+	Members map[string]string
+}
+
+// raftLog the raft log and state of a node, stored in a lunar database.
+// entries that have been included in a snapshot are removed
+type raftLog struct {
+	mu       sync.RWMutex
+	db       *lunar.DB
+	entries  []Entry // entries following the snapshot
+	snapshot snapshotMeta
+	data     uint64 // index of the snapshot the data has been restored from
+}
+
+// openLog opens a raft log, loading all entries that follow the snapshot
+func openLog(path string) (*raftLog, error) {
+	db, err := lunar.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := raftLog{db: db}
+
+	meta, err := db.Get(snapshotKey)
+	if err != nil && err != lunar.ErrNotFound {
+		return nil, err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(meta, &l.snapshot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := db.Get(dataKey)
+	if err == nil && len(data) == 8 {
+		l.data = binary.BigEndian.Uint64(data)
+	}
+
+	var stale [][]byte
+
+	err = db.Scan(logPrefix, func(key, value []byte) error {
+		e := decodeEntry(key, value)
+
+		// remove entries left behind by an interrupted snapshot or truncation
+		if e.Index != l.snapshot.Index+uint64(len(l.entries))+1 {
+			stale = append(stale, key)
+			return nil
+		}
+
+		l.entries = append(l.entries, e)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var b lunar.Batch
+
+	for _, key := range stale {
+		b.Delete(key)
+	}
+
+	return &l, db.Apply(&b)
+}
+
+// close closes the database the log is stored in
+func (l *raftLog) close() error {
+	return l.db.Close()
+}
+
+// hardState returns the current term and the node voted for in that term
+func (l *raftLog) hardState() (uint64, string) {
+	var term uint64
+
+	data, err := l.db.Get(termKey)
+	if err == nil && len(data) == 8 {
+		term = binary.BigEndian.Uint64(data)
+	}
+
+	vote, _ := l.db.Get(voteKey)
+
+	return term, string(vote)
+}
+
+// setHardState stores the current term and the node voted for in that term
+func (l *raftLog) setHardState(term uint64, vote string) error {
+	var b lunar.Batch
+
+	b.Set(termKey, uint64Bytes(term))
+	b.Set(voteKey, []byte(vote))
+
+	err := l.db.Apply(&b)
+	if err != nil {
+		return err
+	}
+
+	// the term and vote must be on disk before they are acted on
+	return l.db.Sync()
+}
+
+// snapshotMeta returns the last entry included in the snapshot
+func (l *raftLog) snapshotMeta() snapshotMeta {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.snapshot
+}
+
+// setSnapshot stores the last entry included in a new snapshot and removes
+// the entries it includes. If discard is true, all entries are removed
+func (l *raftLog) setSnapshot(meta snapshotMeta, discard bool) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if meta.Index < l.snapshot.Index {
+		return nil
+	}
+
+	n := len(l.entries)
+
+	if !discard && meta.Index-l.snapshot.Index < uint64(n) {
+		n = int(meta.Index - l.snapshot.Index)
+	}
+
+	var b lunar.Batch
+
+	b.Set(snapshotKey, data)
+
+	for _, e := range l.entries[:n] {
+		b.Delete(entryKey(e.Index))
+	}
+
+	err = l.db.Apply(&b)
+	if err != nil {
+		return err
+	}
+
+	err = l.db.Sync()
+	if err != nil {
+		return err
+	}
+
+	l.entries = append([]Entry{}, l.entries[n:]...)
+	l.snapshot = meta
+
+	return nil
+}
+
+// dataIndex returns the index of the snapshot the data was last restored from
+func (l *raftLog) dataIndex() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.data
+}
+
+// setDataIndex stores the index of the snapshot the data was restored from
+func (l *raftLog) setDataIndex(index uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.db.Set(dataKey, uint64Bytes(index))
+	if err != nil {
+		return err
+	}
+
+	l.data = index
+
+	return nil
+}
+
+// lastIndex returns the index of the last entry
+func (l *raftLog) lastIndex() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.snapshot.Index + uint64(len(l.entries))
+}
+
+// lastTerm returns the term of the last entry
+func (l *raftLog) lastTerm() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.entries) < 1 {
+		return l.snapshot.Term
+	}
+
+	return l.entries[len(l.entries)-1].Term
+}
+
+// term returns the term of the entry at an index
+func (l *raftLog) term(index uint64) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	switch {
+	case index == l.snapshot.Index:
+		return l.snapshot.Term, nil
+	case index < l.snapshot.Index:
+		return 0, errCompacted
+	case index > l.snapshot.Index+uint64(len(l.entries)):
+		return 0, errUnavailable
+	}
+
+	return l.entries[index-l.snapshot.Index-1].Term, nil
+}
+
+// slice returns the entries from lo up to, but not including, hi
+func (l *raftLog) slice(lo, hi uint64) ([]Entry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if lo <= l.snapshot.Index {
+		return nil, errCompacted
+	}
+
+	if hi > l.snapshot.Index+uint64(len(l.entries))+1 {
+		return nil, errUnavailable
+	}
+
+	entries := make([]Entry, hi-lo)
+	copy(entries, l.entries[lo-l.snapshot.Index-1:hi-l.snapshot.Index-1])
+
+	return entries, nil
+}
+
+// append appends entries to the log, replacing any
+// existing entries from the index of the first entry
+func (l *raftLog) append(entries ...Entry) error {
+	if len(entries) < 1 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var b lunar.Batch
+
+	last := l.snapshot.Index + uint64(len(l.entries))
+	first := entries[0].Index
+
+	if first <= l.snapshot.Index || first > last+1 {
+		return errUnavailable
+	}
+
+	for i := first; i <= last; i++ {
+		b.Delete(entryKey(i))
+	}
+
+	for _, e := range entries {
+		b.Set(entryKey(e.Index), encodeEntry(e))
+	}
+
+	err := l.db.Apply(&b)
+	if err != nil {
+		return err
+	}
+
+	// entries must be on disk before they are acknowledged
+	err = l.db.Sync()
+	if err != nil {
+		return err
+	}
+
+	l.entries = append(l.entries[:first-l.snapshot.Index-1], entries...)
+
+	return nil
+}
+
+// members returns the latest membership of the cluster,
+// and the index of the entry it was configured by
+func (l *raftLog) members() (map[string]string, uint64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	members := copyMembers(l.snapshot.Members)
+	index := l.snapshot.Index
+
+	for _, e := range l.entries {
+		if e.Type == EntryConfig {
+			applyChange(members, e.Data)
+			index = e.Index
+		}
+	}
+
+	return members, index
+}
+
+// entryKey returns the key an entry is stored at,
+// which sorts entries in the order of their index
+func entryKey(index uint64) []byte {
+	return append(append([]byte{}, logPrefix...), uint64Bytes(index)...)
+}
+
+// encodeEntry serializes an entry's term, type and data
+func encodeEntry(e Entry) []byte {
+	data := make([]byte, 9+len(e.Data))
+
+	binary.BigEndian.PutUint64(data, e.Term)
+	data[8] = byte(e.Type)
+	copy(data[9:], e.Data)
+
+	return data
+}
+
+// decodeEntry deserializes an entry stored at a key
+func decodeEntry(key, value []byte) Entry {
+	e := Entry{
+		Index: binary.BigEndian.Uint64(bytes.TrimPrefix(key, logPrefix)),
+	}
+
+	if len(value) < 9 {
+		return e
+	}
+
+	e.Term = binary.BigEndian.Uint64(value)
+	e.Type = EntryType(value[8])
+	e.Data = value[9:]
+
+	return e
+}
+
+func uint64Bytes(v uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, v)
+	return data
+}
@@ -0,0 +1,716 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+)
+
+const (
+	// DefaultHeartbeatInterval the default interval a leader sends heartbeats at
+	DefaultHeartbeatInterval = time.Millisecond * 100
+	// DefaultElectionTimeout the default minimum time a follower waits for a heartbeat before starting an election
+	DefaultElectionTimeout = time.Second
+	// DefaultSnapshotThreshold the default number of entries applied before a snapshot is taken
+	DefaultSnapshotThreshold = 8192
+	// maxAppendEntries the maximum number of entries sent in a single message
+	maxAppendEntries = 256
+	// maxApplyEntries the maximum number of entries applied at a time
+	maxApplyEntries = 1024
+	// snapshotChunkSize the maximum size of a snapshot chunk sent in a single message
+	snapshotChunkSize = 1 << 20
+)
+
+var (
+	// ErrNotLeader the node is not the leader of the cluster
+	ErrNotLeader = errors.New("node is not the leader")
+	// ErrStopped the node has been closed
+	ErrStopped = errors.New("node stopped")
+	// ErrProposalDropped the proposal was replaced by an entry from another leader
+	ErrProposalDropped = errors.New("proposal dropped")
+	// ErrConfigChangePending a membership change has not been committed
+	ErrConfigChangePending = errors.New("membership change pending")
+	// ErrUnknownMember the node is not a member of the cluster
+	ErrUnknownMember = errors.New("unknown member")
+)
+
+// State the role of a node in the cluster
+type State int
+
+const (
+	// Follower the node replicates entries from the leader
+	Follower State = iota
+	// Candidate the node is requesting votes to become the leader
+	Candidate
+	// Leader the node accepts writes and replicates them to followers
+	Leader
+)
+
+// Config configuration of a node
+type Config struct {
+	// ID the unique id of the node
+	ID string
+	// Dir the directory the node's database and raft log are stored in
+	Dir string
+	// Transport sends messages to other nodes. It is closed when the node is closed
+	Transport Transport
+	// Peers the ids and addresses of the initial members of the cluster, including
+	// this node. Peers are only used when a cluster is first created, and should
+	// be empty if the node is joining an existing cluster
+	Peers map[string]string
+	// HeartbeatInterval the interval a leader sends heartbeats at
+	HeartbeatInterval time.Duration
+	// ElectionTimeout the minimum time a follower waits for a heartbeat before starting an election
+	ElectionTimeout time.Duration
+	// SnapshotThreshold the number of entries applied before a snapshot is taken
+	SnapshotThreshold uint64
+	// Options options used when opening the node's database
+	Options []func(*lunar.DB) error
+}
+
+// Status the status of a node
+type Status struct {
+	ID      string
+	State   State
+	Term    uint64
+	Leader  string
+	Commit  uint64
+	Applied uint64
+	Members map[string]string
+}
+
+// Node a member of a cluster that replicates writes to a lunar database through a raft log
+type Node struct {
+	config    Config
+	log       *raftLog
+	db        *lunar.DB
+	dbMu      sync.RWMutex // held exclusively when the database is restored from a snapshot
+	snapMu    sync.Mutex   // held when the snapshot is replaced
+	calls     chan func()
+	applyc    chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	commit    uint64 // index of the last committed entry
+	applied   uint64 // index of the last applied entry
+	appliedc  chan struct{}
+	appliedMu sync.Mutex
+	proposals map[uint64]*proposal
+	mu        sync.Mutex // held when accessing proposals
+
+	// state owned by the run goroutine
+	state       State
+	term        uint64
+	vote        string
+	leader      string
+	members     map[string]string
+	addresses   map[string]string // addresses of nodes that are not members
+	configIndex uint64
+	progress    map[string]*progress
+	votes       map[string]bool
+	deadline    time.Time
+	heard       time.Time // time a message was last received from the leader
+	readSeq     uint64
+	reads       []*readRequest
+	waiting     []*readRequest // reads waiting for an entry to be committed in the leader's term
+	readID      uint64
+	forwarded   map[uint64]*readRequest
+	recv        *os.File // snapshot being received from the leader
+	recvIndex   uint64
+	recvOffset  int64
+	rand        *rand.Rand
+
+	// state owned by the apply goroutine
+	appliedMembers map[string]string
+}
+
+// proposal an entry waiting to be applied
+type proposal struct {
+	term uint64
+	ch   chan error
+}
+
+// readRequest a read waiting for the leader to confirm its read index
+type readRequest struct {
+	index uint64
+	seq   uint64
+	done  func(index uint64, err error)
+}
+
+// New starts a node, loading its raft log and database from its directory
+func New(config Config) (*Node, error) {
+	if config.ID == "" || config.Dir == "" || config.Transport == nil {
+		return nil, errors.New("node id, directory and transport must be provided")
+	}
+
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	if config.ElectionTimeout == 0 {
+		config.ElectionTimeout = DefaultElectionTimeout
+	}
+
+	if config.SnapshotThreshold == 0 {
+		config.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+
+	err := os.MkdirAll(config.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := openLog(filepath.Join(config.Dir, "raft.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	// bootstrap a new cluster
+	if l.snapshotMeta().Members == nil && l.lastIndex() == 0 && len(config.Peers) > 0 {
+		err = l.setSnapshot(snapshotMeta{Members: copyMembers(config.Peers)}, false)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+	}
+
+	n := &Node{
+		config:    config,
+		log:       l,
+		calls:     make(chan func()),
+		applyc:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		appliedc:  make(chan struct{}),
+		proposals: make(map[uint64]*proposal),
+		addresses: make(map[string]string),
+		progress:  make(map[string]*progress),
+		forwarded: make(map[uint64]*readRequest),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	n.term, n.vote = l.hardState()
+
+	if l.dataIndex() < l.snapshotMeta().Index {
+		err = n.restore()
+	} else {
+		n.db, err = lunar.Open(n.dataPath(), config.Options...)
+	}
+
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+
+	meta := l.snapshotMeta()
+
+	n.commit = meta.Index
+	n.applied = meta.Index
+	n.appliedMembers = copyMembers(meta.Members)
+	n.members, n.configIndex = l.members()
+
+	n.becomeFollower(n.term, "")
+
+	n.wg.Add(2)
+	go n.run()
+	go n.apply()
+
+	return n, nil
+}
+
+// Set sets a key's value, returning once the write has been committed and applied
+func (n *Node) Set(ctx context.Context, key, value []byte) error {
+	return n.Apply(ctx, &Batch{ops: []op{{key: key, value: value}}})
+}
+
+// Delete deletes a key, returning once the write has been committed and applied
+func (n *Node) Delete(ctx context.Context, key []byte) error {
+	return n.Apply(ctx, &Batch{ops: []op{{delete: true, key: key}}})
+}
+
+// Apply writes a batch, returning once the batch has been committed and applied.
+// Writes must be made on the leader, otherwise ErrNotLeader is returned
+func (n *Node) Apply(ctx context.Context, b *Batch) error {
+	if len(b.ops) < 1 {
+		return nil
+	}
+
+	return n.propose(ctx, EntryCommand, encodeCommand(b.ops))
+}
+
+// Get get a value by key. The read is linearizable, reflecting all
+// writes that were committed before it was made. Reads on a follower
+// request the read index from the leader
+func (n *Node) Get(ctx context.Context, key []byte) ([]byte, error) {
+	ch := make(chan error, 1)
+
+	var index uint64
+
+	r := &readRequest{
+		done: func(i uint64, err error) {
+			index = i
+			ch <- err
+		},
+	}
+
+	err := n.do(ctx, func() {
+		n.readIndex(r)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case err = <-ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.done:
+		return nil, ErrStopped
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = n.wait(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	n.dbMu.RLock()
+	defer n.dbMu.RUnlock()
+
+	return n.db.Get(key)
+}
+
+// AddNode adds a node to the cluster, or updates its address. Membership
+// changes must be made on the leader, one at a time
+func (n *Node) AddNode(ctx context.Context, id, address string) error {
+	data, err := json.Marshal(change{ID: id, Address: address})
+	if err != nil {
+		return err
+	}
+
+	return n.propose(ctx, EntryConfig, data)
+}
+
+// RemoveNode removes a node from the cluster. Membership
+// changes must be made on the leader, one at a time
+func (n *Node) RemoveNode(ctx context.Context, id string) error {
+	data, err := json.Marshal(change{Remove: true, ID: id})
+	if err != nil {
+		return err
+	}
+
+	return n.propose(ctx, EntryConfig, data)
+}
+
+// Leader returns the id of the current leader, or an empty string if it is not known
+func (n *Node) Leader() string {
+	return n.Status().Leader
+}
+
+// Status returns the status of the node
+func (n *Node) Status() Status {
+	ch := make(chan Status, 1)
+
+	err := n.do(context.Background(), func() {
+		ch <- Status{
+			ID:      n.config.ID,
+			State:   n.state,
+			Term:    n.term,
+			Leader:  n.leader,
+			Commit:  atomic.LoadUint64(&n.commit),
+			Applied: atomic.LoadUint64(&n.applied),
+			Members: copyMembers(n.members),
+		}
+	})
+
+	if err != nil {
+		return Status{ID: n.config.ID}
+	}
+
+	return <-ch
+}
+
+// Close stops the node and closes its transport, database and raft log
+func (n *Node) Close() error {
+	err := ErrStopped
+
+	n.closeOnce.Do(func() {
+		close(n.done)
+		n.wg.Wait()
+
+		n.mu.Lock()
+		for index, p := range n.proposals {
+			p.ch <- ErrStopped
+			delete(n.proposals, index)
+		}
+		n.mu.Unlock()
+
+		errs := []error{
+			n.config.Transport.Close(),
+			n.db.Close(),
+			n.log.close(),
+		}
+
+		err = nil
+
+		for _, e := range errs {
+			if e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+
+	return err
+}
+
+// do calls a function from the goroutine that owns the raft state
+func (n *Node) do(ctx context.Context, fn func()) error {
+	select {
+	case n.calls <- fn:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.done:
+		return ErrStopped
+	}
+}
+
+// propose appends an entry to the leader's log, waiting for it to be applied
+func (n *Node) propose(ctx context.Context, t EntryType, data []byte) error {
+	p := &proposal{ch: make(chan error, 1)}
+
+	err := n.do(ctx, func() {
+		n.appendEntry(p, t, data)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err = <-p.ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.done:
+		return ErrStopped
+	}
+}
+
+// wait waits until an entry has been applied
+func (n *Node) wait(ctx context.Context, index uint64) error {
+	for {
+		n.appliedMu.Lock()
+		ch := n.appliedc
+		n.appliedMu.Unlock()
+
+		if atomic.LoadUint64(&n.applied) >= index {
+			return nil
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.done:
+			return ErrStopped
+		}
+	}
+}
+
+// apply applies committed entries to the database
+func (n *Node) apply() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.applyc:
+		case <-n.done:
+			return
+		}
+
+		// entries that fail to apply are retried when the next entry is committed
+		n.applyCommitted()
+	}
+}
+
+// applyCommitted applies all committed entries, restoring the
+// database first if a snapshot has been received from the leader
+func (n *Node) applyCommitted() error {
+	for {
+		if n.log.dataIndex() < n.log.snapshotMeta().Index {
+			err := n.restore()
+			if err != nil {
+				return err
+			}
+		}
+
+		applied := atomic.LoadUint64(&n.applied)
+		commit := atomic.LoadUint64(&n.commit)
+
+		if applied >= commit {
+			break
+		}
+
+		if commit-applied > maxApplyEntries {
+			commit = applied + maxApplyEntries
+		}
+
+		entries, err := n.log.slice(applied+1, commit+1)
+		if err == errCompacted && n.log.dataIndex() < n.log.snapshotMeta().Index {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			n.resolve(e, n.applyEntry(e))
+		}
+
+		n.setApplied(commit)
+	}
+
+	if atomic.LoadUint64(&n.applied)-n.log.snapshotMeta().Index >= n.config.SnapshotThreshold {
+		return n.snapshot()
+	}
+
+	return nil
+}
+
+// applyEntry applies an entry to the database
+func (n *Node) applyEntry(e Entry) error {
+	switch e.Type {
+	case EntryCommand:
+		ops, err := decodeCommand(e.Data)
+		if err != nil {
+			return err
+		}
+
+		return n.write(ops)
+	case EntryConfig:
+		applyChange(n.appliedMembers, e.Data)
+	}
+
+	return nil
+}
+
+// write applies writes to the database. A single
+// delete returns ErrNotFound if the key does not exist
+func (n *Node) write(ops []op) error {
+	n.dbMu.RLock()
+	defer n.dbMu.RUnlock()
+
+	if len(ops) == 1 && ops[0].delete {
+		return n.db.Delete(ops[0].key)
+	}
+
+	if len(ops) == 1 {
+		return n.db.Set(ops[0].key, ops[0].value)
+	}
+
+	var b lunar.Batch
+
+	for _, o := range ops {
+		if o.delete {
+			b.Delete(o.key)
+		} else {
+			b.Set(o.key, o.value)
+		}
+	}
+
+	return n.db.Apply(&b)
+}
+
+// resolve returns the result of applying an entry to the proposal that created it
+func (n *Node) resolve(e Entry, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	p := n.proposals[e.Index]
+	if p == nil {
+		return
+	}
+
+	delete(n.proposals, e.Index)
+
+	if p.term != e.Term {
+		err = ErrProposalDropped
+	}
+
+	p.ch <- err
+}
+
+// setApplied updates the index of the last applied entry and wakes any waiting reads
+func (n *Node) setApplied(index uint64) {
+	atomic.StoreUint64(&n.applied, index)
+
+	n.appliedMu.Lock()
+	close(n.appliedc)
+	n.appliedc = make(chan struct{})
+	n.appliedMu.Unlock()
+}
+
+// snapshot writes a backup of the database to the snapshot
+// file and removes the entries it includes from the log
+func (n *Node) snapshot() error {
+	applied := atomic.LoadUint64(&n.applied)
+
+	term, err := n.log.term(applied)
+	if err != nil {
+		return err
+	}
+
+	tmp := n.snapshotPath() + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	n.dbMu.RLock()
+	_, err = n.db.Backup(f, 0)
+	n.dbMu.RUnlock()
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	f.Close()
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	n.snapMu.Lock()
+	defer n.snapMu.Unlock()
+
+	// a newer snapshot has been received from the leader
+	if applied <= n.log.snapshotMeta().Index {
+		return os.Remove(tmp)
+	}
+
+	err = os.Rename(tmp, n.snapshotPath())
+	if err != nil {
+		return err
+	}
+
+	err = n.log.setSnapshot(snapshotMeta{
+		Index:   applied,
+		Term:    term,
+		Members: copyMembers(n.appliedMembers),
+	}, false)
+
+	if err != nil {
+		return err
+	}
+
+	err = n.log.setDataIndex(applied)
+	if err != nil {
+		return err
+	}
+
+	// reclaim the space used by the removed entries
+	return n.log.db.Compact()
+}
+
+// restore replaces the database with the contents of the snapshot
+func (n *Node) restore() error {
+	n.snapMu.Lock()
+	defer n.snapMu.Unlock()
+
+	meta := n.log.snapshotMeta()
+
+	f, err := os.Open(n.snapshotPath())
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	n.dbMu.Lock()
+	defer n.dbMu.Unlock()
+
+	if n.db != nil {
+		err = n.db.Close()
+		if err != nil {
+			return err
+		}
+
+		n.db = nil
+	}
+
+	for _, path := range []string{n.dataPath(), n.dataPath() + ".hint", n.dataPath() + ".values"} {
+		err = os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = lunar.Restore(f, n.dataPath(), n.config.Options...)
+	if err != nil {
+		return err
+	}
+
+	n.db, err = lunar.Open(n.dataPath(), n.config.Options...)
+	if err != nil {
+		return err
+	}
+
+	err = n.log.setDataIndex(meta.Index)
+	if err != nil {
+		return err
+	}
+
+	// proposals included in the snapshot can no longer be resolved
+	n.mu.Lock()
+	for index, p := range n.proposals {
+		if index <= meta.Index {
+			p.ch <- ErrProposalDropped
+			delete(n.proposals, index)
+		}
+	}
+	n.mu.Unlock()
+
+	n.appliedMembers = copyMembers(meta.Members)
+	n.setApplied(meta.Index)
+
+	return nil
+}
+
+// dataPath returns the path of the node's database
+func (n *Node) dataPath() string {
+	return filepath.Join(n.config.Dir, "data.db")
+}
+
+// snapshotPath returns the path of the node's latest snapshot
+func (n *Node) snapshotPath() string {
+	return filepath.Join(n.config.Dir, "snapshot")
+}
+
+// String returns the name of a state
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,924 @@
+package cluster
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// progress the replication state of a follower, tracked by the leader
+type progress struct {
+	next       uint64 // index of the next entry to send
+	match      uint64 // index of the last entry known to be replicated
+	last       uint64 // match at the previous heartbeat
+	ack        uint64 // last heartbeat sequence acknowledged
+	snap       *os.File
+	snapMeta   snapshotMeta
+	snapSize   int64
+	snapOffset int64
+}
+
+// run handles messages, calls and heartbeats until the node is closed
+func (n *Node) run() {
+	defer n.wg.Done()
+	defer n.stop()
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m := <-n.config.Transport.Receive():
+			if m.To == n.config.ID {
+				n.step(m)
+			}
+		case fn := <-n.calls:
+			fn()
+		case <-ticker.C:
+			n.tick()
+		case <-n.done:
+			return
+		}
+	}
+}
+
+// stop releases resources held by the raft state
+func (n *Node) stop() {
+	n.failReads(ErrStopped)
+
+	for _, pr := range n.progress {
+		pr.close()
+	}
+
+	if n.recv != nil {
+		n.recv.Close()
+	}
+}
+
+// tick sends heartbeats if the node is the leader,
+// otherwise starts an election if the leader has failed
+func (n *Node) tick() {
+	if n.state != Leader {
+		if time.Now().After(n.deadline) {
+			n.campaign()
+		}
+
+		return
+	}
+
+	for id, pr := range n.progress {
+		// resend entries that have not been acknowledged since the last heartbeat
+		if pr.snap == nil && pr.match == pr.last && pr.next > pr.match+1 {
+			pr.next = pr.match + 1
+		}
+
+		pr.last = pr.match
+
+		n.sendAppend(id)
+	}
+}
+
+// step handles a message from another node
+func (n *Node) step(m *Message) {
+	if m.Address != "" {
+		n.addresses[m.From] = m.Address
+	}
+
+	switch {
+	case m.Term > n.term:
+		// ignore votes while the leader is active, so nodes that
+		// have been removed or partitioned cannot disrupt the cluster
+		if m.Type == MsgVote && (n.state == Leader || n.leader != "" && time.Since(n.heard) < n.config.ElectionTimeout) {
+			return
+		}
+
+		var leader string
+
+		if m.Type == MsgApp || m.Type == MsgSnap {
+			leader = m.From
+		}
+
+		// the message is not handled if the new term could not be stored
+		if n.becomeFollower(m.Term, leader) != nil {
+			return
+		}
+	case m.Term < n.term:
+		// tell stale leaders and candidates about the current term
+		switch m.Type {
+		case MsgApp:
+			n.send(m.From, &Message{Type: MsgAppResp, Reject: true})
+		case MsgSnap:
+			n.send(m.From, &Message{Type: MsgSnapResp})
+		case MsgVote:
+			n.send(m.From, &Message{Type: MsgVoteResp, Reject: true})
+		}
+
+		return
+	}
+
+	switch m.Type {
+	case MsgVote:
+		n.handleVote(m)
+	case MsgVoteResp:
+		n.handleVoteResp(m)
+	case MsgApp:
+		n.handleAppend(m)
+	case MsgAppResp:
+		n.handleAppendResp(m)
+	case MsgSnap:
+		n.handleSnapshot(m)
+	case MsgSnapResp:
+		n.handleSnapshotResp(m)
+	case MsgReadIndex:
+		n.handleReadIndex(m)
+	case MsgReadIndexResp:
+		n.handleReadIndexResp(m)
+	}
+}
+
+// becomeFollower updates the node's term and leader. An error
+// is returned if a new term could not be stored
+func (n *Node) becomeFollower(term uint64, leader string) error {
+	if term != n.term {
+		err := n.persist(term, "")
+		if err != nil {
+			return err
+		}
+
+		n.term = term
+		n.vote = ""
+	}
+
+	if n.state == Leader {
+		n.failReads(ErrNotLeader)
+
+		for _, pr := range n.progress {
+			pr.close()
+		}
+
+		n.progress = make(map[string]*progress)
+	}
+
+	if leader != n.leader {
+		n.failForwarded()
+	}
+
+	n.state = Follower
+	n.leader = leader
+
+	if leader != "" {
+		n.heard = time.Now()
+	}
+
+	n.resetDeadline()
+
+	return nil
+}
+
+// becomeLeader starts replicating to all members, appending an entry
+// to commit any entries from previous terms
+func (n *Node) becomeLeader() {
+	n.state = Leader
+	n.leader = n.config.ID
+	n.progress = make(map[string]*progress)
+
+	n.configure()
+
+	err := n.log.append(Entry{
+		Index: n.log.lastIndex() + 1,
+		Term:  n.term,
+		Type:  EntryNoop,
+	})
+
+	if err != nil {
+		n.becomeFollower(n.term, "")
+		return
+	}
+
+	n.broadcast()
+	n.maybeCommit()
+}
+
+// campaign starts an election if the node is a member of the cluster
+func (n *Node) campaign() {
+	n.resetDeadline()
+
+	if _, ok := n.members[n.config.ID]; !ok {
+		return
+	}
+
+	// the election is retried after the next timeout if the vote could not be stored
+	err := n.persist(n.term+1, n.config.ID)
+	if err != nil {
+		return
+	}
+
+	n.failForwarded()
+
+	n.state = Candidate
+	n.term++
+	n.vote = n.config.ID
+	n.leader = ""
+	n.votes = map[string]bool{n.config.ID: true}
+
+	if n.quorum(func(id string) bool { return n.votes[id] }) {
+		n.becomeLeader()
+		return
+	}
+
+	for id := range n.members {
+		if id == n.config.ID {
+			continue
+		}
+
+		n.send(id, &Message{
+			Type:    MsgVote,
+			Index:   n.log.lastIndex(),
+			LogTerm: n.log.lastTerm(),
+		})
+	}
+}
+
+func (n *Node) handleVote(m *Message) {
+	lastIndex := n.log.lastIndex()
+	lastTerm := n.log.lastTerm()
+
+	// the candidate's log must be at least as up to date as this node's log
+	upToDate := m.LogTerm > lastTerm || m.LogTerm == lastTerm && m.Index >= lastIndex
+	grant := (n.vote == "" || n.vote == m.From) && n.leader == "" && upToDate
+
+	// the vote is refused if it could not be stored
+	if grant && n.persist(n.term, m.From) != nil {
+		grant = false
+	}
+
+	if grant {
+		n.vote = m.From
+		n.resetDeadline()
+	}
+
+	n.send(m.From, &Message{Type: MsgVoteResp, Reject: !grant})
+}
+
+func (n *Node) handleVoteResp(m *Message) {
+	if n.state != Candidate {
+		return
+	}
+
+	n.votes[m.From] = !m.Reject
+
+	if n.quorum(func(id string) bool { return n.votes[id] }) {
+		n.becomeLeader()
+	}
+}
+
+func (n *Node) handleAppend(m *Message) {
+	n.becomeFollower(m.Term, m.From)
+
+	prev := m.Index
+	prevTerm := m.LogTerm
+	entries := m.Entries
+
+	// skip entries that are included in the snapshot
+	if snap := n.log.snapshotMeta(); prev < snap.Index {
+		skip := snap.Index - prev
+
+		if skip >= uint64(len(entries)) {
+			n.send(m.From, &Message{Type: MsgAppResp, Index: snap.Index, Context: m.Context})
+			return
+		}
+
+		entries = entries[skip:]
+		prev = snap.Index
+		prevTerm = snap.Term
+	}
+
+	if term, err := n.log.term(prev); err != nil || term != prevTerm {
+		hint := prev - 1
+
+		if last := n.log.lastIndex(); last < hint {
+			hint = last
+		}
+
+		n.send(m.From, &Message{Type: MsgAppResp, Reject: true, Index: hint, Context: m.Context})
+		return
+	}
+
+	// append entries from the first entry that conflicts with the log
+	for i, e := range entries {
+		if term, err := n.log.term(e.Index); err == nil && term == e.Term {
+			continue
+		}
+
+		err := n.log.append(entries[i:]...)
+		if err != nil {
+			return
+		}
+
+		n.configure()
+
+		break
+	}
+
+	last := prev + uint64(len(entries))
+
+	if commit := min(m.Commit, last); commit > atomic.LoadUint64(&n.commit) {
+		n.setCommit(commit)
+	}
+
+	n.send(m.From, &Message{Type: MsgAppResp, Index: last, Context: m.Context})
+}
+
+func (n *Node) handleAppendResp(m *Message) {
+	pr := n.progress[m.From]
+	if n.state != Leader || pr == nil {
+		return
+	}
+
+	if m.Context > pr.ack {
+		pr.ack = m.Context
+		n.checkReads()
+	}
+
+	if m.Reject {
+		if m.Index+1 < pr.next {
+			pr.next = m.Index + 1
+		}
+
+		if pr.next <= pr.match {
+			pr.next = pr.match + 1
+		}
+
+		n.sendAppend(m.From)
+		return
+	}
+
+	if m.Index > pr.match {
+		pr.match = m.Index
+
+		if pr.next <= m.Index {
+			pr.next = m.Index + 1
+		}
+
+		n.maybeCommit()
+	}
+
+	if n.state == Leader && pr.snap == nil && pr.next <= n.log.lastIndex() {
+		n.sendAppend(m.From)
+	}
+}
+
+// handleSnapshot writes a chunk of a snapshot sent by the leader,
+// installing the snapshot once all of it has been received
+func (n *Node) handleSnapshot(m *Message) {
+	n.becomeFollower(m.Term, m.From)
+
+	c := m.Snapshot
+	if c == nil {
+		return
+	}
+
+	if c.Offset == 0 {
+		err := n.receive(c.Index)
+		if err != nil {
+			return
+		}
+	}
+
+	switch {
+	case c.Index != n.recvIndex:
+		n.send(m.From, &Message{Type: MsgSnapResp})
+		return
+	case n.recv == nil || c.Offset != n.recvOffset:
+		n.send(m.From, &Message{Type: MsgSnapResp, Index: uint64(n.recvOffset)})
+		return
+	}
+
+	_, err := n.recv.WriteAt(c.Data, c.Offset)
+	if err != nil {
+		return
+	}
+
+	n.recvOffset = n.recvOffset + int64(len(c.Data))
+
+	if c.Done {
+		err = n.install(c)
+		if err != nil {
+			return
+		}
+	}
+
+	n.send(m.From, &Message{Type: MsgSnapResp, Index: uint64(n.recvOffset)})
+}
+
+func (n *Node) handleSnapshotResp(m *Message) {
+	pr := n.progress[m.From]
+	if n.state != Leader || pr == nil || pr.snap == nil {
+		return
+	}
+
+	if int64(m.Index) < pr.snapSize {
+		pr.snapOffset = int64(m.Index)
+		n.sendChunk(m.From, pr)
+		return
+	}
+
+	if pr.snapMeta.Index > pr.match {
+		pr.match = pr.snapMeta.Index
+	}
+
+	pr.next = pr.snapMeta.Index + 1
+	pr.close()
+
+	n.maybeCommit()
+	n.sendAppend(m.From)
+}
+
+// receive starts receiving a snapshot from the leader
+func (n *Node) receive(index uint64) error {
+	if n.recv != nil {
+		n.recv.Close()
+		n.recv = nil
+	}
+
+	f, err := os.Create(n.snapshotPath() + ".recv")
+	if err != nil {
+		return err
+	}
+
+	n.recv = f
+	n.recvIndex = index
+	n.recvOffset = 0
+
+	return nil
+}
+
+// install replaces the log with a snapshot received from the leader.
+// The database is restored from the snapshot before any more entries are applied
+func (n *Node) install(c *SnapshotChunk) error {
+	err := n.recv.Close()
+	n.recv = nil
+
+	if err != nil {
+		return err
+	}
+
+	n.snapMu.Lock()
+	defer n.snapMu.Unlock()
+
+	// entries included in the snapshot have already been committed
+	if c.Index <= n.log.snapshotMeta().Index || c.Index <= atomic.LoadUint64(&n.commit) {
+		return os.Remove(n.snapshotPath() + ".recv")
+	}
+
+	err = os.Rename(n.snapshotPath()+".recv", n.snapshotPath())
+	if err != nil {
+		return err
+	}
+
+	err = n.log.setSnapshot(snapshotMeta{
+		Index:   c.Index,
+		Term:    c.Term,
+		Members: copyMembers(c.Members),
+	}, true)
+
+	if err != nil {
+		return err
+	}
+
+	n.configure()
+	n.setCommit(c.Index)
+
+	return nil
+}
+
+// handleReadIndex confirms the leader's read index for a follower
+func (n *Node) handleReadIndex(m *Message) {
+	n.read(&readRequest{
+		done: func(index uint64, err error) {
+			n.send(m.From, &Message{
+				Type:    MsgReadIndexResp,
+				Index:   index,
+				Reject:  err != nil,
+				Context: m.Context,
+			})
+		},
+	})
+}
+
+func (n *Node) handleReadIndexResp(m *Message) {
+	r := n.forwarded[m.Context]
+	if r == nil {
+		return
+	}
+
+	delete(n.forwarded, m.Context)
+
+	if m.Reject {
+		r.done(0, ErrNotLeader)
+		return
+	}
+
+	r.done(m.Index, nil)
+}
+
+// readIndex requests a read index from the leader
+func (n *Node) readIndex(r *readRequest) {
+	switch {
+	case n.state == Leader:
+		n.read(r)
+	case n.leader != "":
+		n.readID++
+		n.forwarded[n.readID] = r
+
+		n.send(n.leader, &Message{Type: MsgReadIndex, Context: n.readID})
+	default:
+		r.done(0, ErrNotLeader)
+	}
+}
+
+// read records the commit index as a read's index, which is confirmed once
+// a quorum of members acknowledge a heartbeat sent after the read was made
+func (n *Node) read(r *readRequest) {
+	if n.state != Leader {
+		r.done(0, ErrNotLeader)
+		return
+	}
+
+	// the leader does not know the latest commit index until
+	// it has committed an entry from its own term
+	if term, _ := n.log.term(atomic.LoadUint64(&n.commit)); term != n.term {
+		n.waiting = append(n.waiting, r)
+		return
+	}
+
+	n.readSeq++
+
+	r.index = atomic.LoadUint64(&n.commit)
+	r.seq = n.readSeq
+
+	n.reads = append(n.reads, r)
+
+	n.checkReads()
+
+	if len(n.reads) > 0 {
+		n.broadcast()
+	}
+}
+
+// checkReads confirms reads acknowledged by a quorum of members
+func (n *Node) checkReads() {
+	var confirmed int
+
+	for _, r := range n.reads {
+		acked := n.quorum(func(id string) bool {
+			if id == n.config.ID {
+				return true
+			}
+
+			pr := n.progress[id]
+
+			return pr != nil && pr.ack >= r.seq
+		})
+
+		if !acked {
+			break
+		}
+
+		r.done(r.index, nil)
+		confirmed++
+	}
+
+	n.reads = append(n.reads[:0], n.reads[confirmed:]...)
+}
+
+// failReads fails all reads waiting to be confirmed
+func (n *Node) failReads(err error) {
+	for _, r := range n.reads {
+		r.done(0, err)
+	}
+
+	for _, r := range n.waiting {
+		r.done(0, err)
+	}
+
+	n.reads = nil
+	n.waiting = nil
+
+	n.failForwarded()
+}
+
+// failForwarded fails all reads waiting for a response from the leader
+func (n *Node) failForwarded() {
+	for id, r := range n.forwarded {
+		r.done(0, ErrNotLeader)
+		delete(n.forwarded, id)
+	}
+}
+
+// appendEntry appends a proposed entry to the leader's log
+func (n *Node) appendEntry(p *proposal, t EntryType, data []byte) {
+	if n.state != Leader {
+		p.ch <- ErrNotLeader
+		return
+	}
+
+	if t == EntryConfig {
+		if n.configIndex > atomic.LoadUint64(&n.commit) {
+			p.ch <- ErrConfigChangePending
+			return
+		}
+
+		var c change
+
+		err := json.Unmarshal(data, &c)
+		if err != nil {
+			p.ch <- err
+			return
+		}
+
+		if _, ok := n.members[c.ID]; c.Remove && !ok {
+			p.ch <- ErrUnknownMember
+			return
+		}
+	}
+
+	e := Entry{
+		Index: n.log.lastIndex() + 1,
+		Term:  n.term,
+		Type:  t,
+		Data:  data,
+	}
+
+	p.term = n.term
+
+	n.mu.Lock()
+	n.proposals[e.Index] = p
+	n.mu.Unlock()
+
+	err := n.log.append(e)
+	if err != nil {
+		n.mu.Lock()
+		delete(n.proposals, e.Index)
+		n.mu.Unlock()
+
+		p.ch <- err
+		return
+	}
+
+	if t == EntryConfig {
+		n.configure()
+	}
+
+	n.broadcast()
+	n.maybeCommit()
+}
+
+// maybeCommit commits the latest entry from the leader's
+// term that has been replicated to a quorum of members
+func (n *Node) maybeCommit() {
+	var matches []uint64
+
+	for id := range n.members {
+		if id == n.config.ID {
+			matches = append(matches, n.log.lastIndex())
+		} else if pr := n.progress[id]; pr != nil {
+			matches = append(matches, pr.match)
+		} else {
+			matches = append(matches, 0)
+		}
+	}
+
+	if len(matches) < 1 {
+		return
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+
+	commit := matches[len(matches)/2]
+
+	if commit <= atomic.LoadUint64(&n.commit) {
+		return
+	}
+
+	if term, _ := n.log.term(commit); term != n.term {
+		return
+	}
+
+	n.setCommit(commit)
+}
+
+// setCommit updates the commit index and wakes the apply goroutine
+func (n *Node) setCommit(commit uint64) {
+	atomic.StoreUint64(&n.commit, commit)
+
+	select {
+	case n.applyc <- struct{}{}:
+	default:
+	}
+
+	if n.state != Leader {
+		return
+	}
+
+	// reads can be confirmed once an entry from the leader's term is committed
+	if len(n.waiting) > 0 {
+		waiting := n.waiting
+		n.waiting = nil
+
+		for _, r := range waiting {
+			n.read(r)
+		}
+	}
+
+	// a leader that has been removed steps down once its removal is committed
+	if _, ok := n.members[n.config.ID]; !ok && commit >= n.configIndex {
+		n.broadcast()
+		n.becomeFollower(n.term, "")
+	}
+}
+
+// configure updates the members of the cluster to the latest configuration in the log
+func (n *Node) configure() {
+	n.members, n.configIndex = n.log.members()
+
+	if n.state != Leader {
+		return
+	}
+
+	for id, pr := range n.progress {
+		if _, ok := n.members[id]; !ok {
+			pr.close()
+			delete(n.progress, id)
+		}
+	}
+
+	for id := range n.members {
+		if _, ok := n.progress[id]; !ok && id != n.config.ID {
+			n.progress[id] = &progress{next: n.log.lastIndex() + 1}
+		}
+	}
+}
+
+// broadcast sends entries or a heartbeat to all followers
+func (n *Node) broadcast() {
+	for id := range n.progress {
+		n.sendAppend(id)
+	}
+}
+
+// sendAppend sends the entries a follower is missing, or a snapshot
+// if the entries have been removed from the log
+func (n *Node) sendAppend(id string) {
+	pr := n.progress[id]
+
+	if pr.snap != nil {
+		n.sendChunk(id, pr)
+		return
+	}
+
+	last := n.log.lastIndex()
+
+	if pr.next > last+1 {
+		pr.next = last + 1
+	}
+
+	prev := pr.next - 1
+
+	prevTerm, err := n.log.term(prev)
+	if err != nil {
+		n.sendSnapshot(id, pr)
+		return
+	}
+
+	entries, err := n.log.slice(pr.next, min(last, prev+maxAppendEntries)+1)
+	if err != nil {
+		n.sendSnapshot(id, pr)
+		return
+	}
+
+	n.send(id, &Message{
+		Type:    MsgApp,
+		Index:   prev,
+		LogTerm: prevTerm,
+		Entries: entries,
+		Commit:  atomic.LoadUint64(&n.commit),
+		Context: n.readSeq,
+	})
+
+	// optimistically send the following entries next
+	pr.next = pr.next + uint64(len(entries))
+}
+
+// sendSnapshot starts sending the latest snapshot to a follower
+func (n *Node) sendSnapshot(id string, pr *progress) {
+	n.snapMu.Lock()
+	f, err := os.Open(n.snapshotPath())
+	meta := n.log.snapshotMeta()
+	n.snapMu.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return
+	}
+
+	pr.snap = f
+	pr.snapMeta = meta
+	pr.snapSize = info.Size()
+	pr.snapOffset = 0
+
+	n.sendChunk(id, pr)
+}
+
+// sendChunk sends the part of the snapshot following the follower's offset
+func (n *Node) sendChunk(id string, pr *progress) {
+	size := pr.snapSize - pr.snapOffset
+	if size > snapshotChunkSize {
+		size = snapshotChunkSize
+	}
+
+	data := make([]byte, size)
+
+	_, err := pr.snap.ReadAt(data, pr.snapOffset)
+	if err != nil {
+		return
+	}
+
+	n.send(id, &Message{
+		Type: MsgSnap,
+		Snapshot: &SnapshotChunk{
+			Index:   pr.snapMeta.Index,
+			Term:    pr.snapMeta.Term,
+			Members: pr.snapMeta.Members,
+			Offset:  pr.snapOffset,
+			Data:    data,
+			Done:    pr.snapOffset+size == pr.snapSize,
+		},
+	})
+}
+
+// send sends a message to a node
+func (n *Node) send(id string, m *Message) {
+	address, ok := n.members[id]
+	if !ok {
+		address = n.addresses[id]
+	}
+
+	if address == "" {
+		return
+	}
+
+	m.From = n.config.ID
+	m.To = id
+	m.Term = n.term
+	m.Address = n.members[n.config.ID]
+
+	n.config.Transport.Send(address, m)
+}
+
+// persist durably stores a term and vote, before the node acts on them
+func (n *Node) persist(term uint64, vote string) error {
+	return n.log.setHardState(term, vote)
+}
+
+// quorum returns true if the condition is true for a majority of members
+func (n *Node) quorum(fn func(id string) bool) bool {
+	var count int
+
+	for id := range n.members {
+		if fn(id) {
+			count++
+		}
+	}
+
+	return count > len(n.members)/2
+}
+
+// resetDeadline sets a random deadline for hearing from the leader
+func (n *Node) resetDeadline() {
+	timeout := n.config.ElectionTimeout + time.Duration(n.rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// close stops sending a snapshot
+func (pr *progress) close() {
+	if pr.snap != nil {
+		pr.snap.Close()
+		pr.snap = nil
+	}
+}
+
+func min(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
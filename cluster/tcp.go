@@ -0,0 +1,251 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/gob"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// tcpDialTimeout the maximum time taken to connect to a node
+	tcpDialTimeout = time.Second
+	// tcpRetryInterval the minimum time between attempts to connect to a node
+	tcpRetryInterval = time.Millisecond * 100
+)
+
+// TCPTransport a transport that sends gob encoded messages over tcp connections
+type TCPTransport struct {
+	listener net.Listener
+	messages chan *Message
+	done     chan struct{}
+	mu       sync.Mutex
+	peers    map[string]*tcpPeer
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+// tcpPeer the outbound connection to a node
+type tcpPeer struct {
+	address  string
+	messages chan *Message
+}
+
+// NewTCPTransport creates a transport that receives messages on an address
+func NewTCPTransport(address string) (*TCPTransport, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TCPTransport{
+		listener: l,
+		messages: make(chan *Message, transportBufferSize),
+		done:     make(chan struct{}),
+		peers:    make(map[string]*tcpPeer),
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	t.wg.Add(1)
+	go t.accept()
+
+	return t, nil
+}
+
+// Addr returns the address the transport is listening on
+func (t *TCPTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Send queues a message to be sent to the node at an address.
+// The message is dropped if the queue for the node is full
+func (t *TCPTransport) Send(address string, m *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return ErrTransportClosed
+	}
+
+	p := t.peers[address]
+
+	if p == nil {
+		p = &tcpPeer{
+			address:  address,
+			messages: make(chan *Message, transportBufferSize),
+		}
+
+		t.peers[address] = p
+
+		t.wg.Add(1)
+		go t.send(p)
+	}
+
+	select {
+	case p.messages <- m:
+	default:
+	}
+
+	return nil
+}
+
+// Receive returns the channel messages sent to this transport are delivered on
+func (t *TCPTransport) Receive() <-chan *Message {
+	return t.messages
+}
+
+// Close closes the listener and all connections
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+
+	t.closed = true
+	close(t.done)
+
+	for c := range t.conns {
+		c.Close()
+	}
+
+	t.mu.Unlock()
+
+	err := t.listener.Close()
+
+	t.wg.Wait()
+
+	return err
+}
+
+// accept accepts inbound connections
+func (t *TCPTransport) accept() {
+	defer t.wg.Done()
+
+	for {
+		c, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if !t.track(c) {
+			c.Close()
+			return
+		}
+
+		t.wg.Add(1)
+		go t.receive(c)
+	}
+}
+
+// receive decodes messages from an inbound connection
+func (t *TCPTransport) receive(c net.Conn) {
+	defer t.wg.Done()
+	defer t.untrack(c)
+
+	dec := gob.NewDecoder(bufio.NewReader(c))
+
+	for {
+		var m Message
+
+		err := dec.Decode(&m)
+		if err != nil {
+			return
+		}
+
+		select {
+		case t.messages <- &m:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// send encodes messages queued for a node, reconnecting if the connection fails
+func (t *TCPTransport) send(p *tcpPeer) {
+	defer t.wg.Done()
+
+	var c net.Conn
+	var bw *bufio.Writer
+	var enc *gob.Encoder
+	var last time.Time
+
+	defer func() {
+		if c != nil {
+			t.untrack(c)
+		}
+	}()
+
+	for {
+		var m *Message
+
+		select {
+		case m = <-p.messages:
+		case <-t.done:
+			return
+		}
+
+		if c == nil {
+			// drop messages until the node can be reconnected to
+			if time.Since(last) < tcpRetryInterval {
+				continue
+			}
+
+			last = time.Now()
+
+			var err error
+
+			c, err = net.DialTimeout("tcp", p.address, tcpDialTimeout)
+			if err != nil {
+				c = nil
+				continue
+			}
+
+			if !t.track(c) {
+				c.Close()
+				return
+			}
+
+			bw = bufio.NewWriter(c)
+			enc = gob.NewEncoder(bw)
+		}
+
+		err := enc.Encode(m)
+
+		// flush once all queued messages have been encoded
+		if err == nil && len(p.messages) < 1 {
+			err = bw.Flush()
+		}
+
+		if err != nil {
+			t.untrack(c)
+			c = nil
+		}
+	}
+}
+
+// track records a connection so it can be closed with the transport
+func (t *TCPTransport) track(c net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return false
+	}
+
+	t.conns[c] = struct{}{}
+
+	return true
+}
+
+// untrack closes a connection and stops tracking it
+func (t *TCPTransport) untrack(c net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, c)
+	t.mu.Unlock()
+
+	c.Close()
+}
@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	// transportBufferSize the number of messages buffered for a node before they are dropped
+	transportBufferSize = 1024
+)
+
+var (
+	// ErrTransportClosed the transport has been closed
+	ErrTransportClosed = errors.New("transport closed")
+)
+
+// MessageType the type of a message sent between nodes
+type MessageType int
+
+const (
+	// MsgVote a candidate requesting a vote
+	MsgVote MessageType = iota + 1
+	// MsgVoteResp a response to a vote request
+	MsgVoteResp
+	// MsgApp a leader appending entries to a follower's log, or sending a heartbeat
+	MsgApp
+	// MsgAppResp a response to an append request
+	MsgAppResp
+	// MsgSnap a leader sending part of a snapshot to a follower
+	MsgSnap
+	// MsgSnapResp a response to a snapshot request
+	MsgSnapResp
+	// MsgReadIndex a follower requesting a read index from the leader
+	MsgReadIndex
+	// MsgReadIndexResp a response to a read index request
+	MsgReadIndexResp
+)
+
+// Message a message sent between nodes
+type Message struct {
+	Type     MessageType
+	From     string
+	To       string
+	Address  string // address of the sender, if it is a member of the cluster
+	Term     uint64
+	Index    uint64 // index of the entry preceding Entries, the last entry of a candidate's log, or the index a response refers to
+	LogTerm  uint64 // term of the entry at Index
+	Commit   uint64
+	Entries  []Entry
+	Reject   bool
+	Context  uint64 // heartbeat sequence or read request id, echoed by responses
+	Snapshot *SnapshotChunk
+}
+
+// SnapshotChunk part of a snapshot sent to a follower
+type SnapshotChunk struct {
+	Index   uint64
+	Term    uint64
+	Members map[string]string
+	Offset  int64
+	Data    []byte
+	Done    bool
+}
+
+// Transport sends messages between the nodes of a cluster. Messages
+// may be dropped, delayed or reordered, but must not be corrupted
+type Transport interface {
+	// Send sends a message to the node at an address, without waiting for it to be received
+	Send(address string, m *Message) error
+	// Receive returns the channel messages sent to this node are delivered on
+	Receive() <-chan *Message
+	// Close stops sending and receiving messages
+	Close() error
+}
+
+// MemoryNetwork connects in memory transports, which can be
+// disconnected from each other to simulate network partitions
+type MemoryNetwork struct {
+	mu           sync.RWMutex
+	transports   map[string]*MemoryTransport
+	disconnected map[string]bool
+}
+
+// MemoryTransport a transport that delivers messages in memory
+type MemoryTransport struct {
+	network  *MemoryNetwork
+	address  string
+	messages chan *Message
+	closed   bool
+}
+
+// NewMemoryNetwork creates a network of in memory transports
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		transports:   make(map[string]*MemoryTransport),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Transport creates a transport that receives messages sent to an address
+func (n *MemoryNetwork) Transport(address string) *MemoryTransport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t := &MemoryTransport{
+		network:  n,
+		address:  address,
+		messages: make(chan *Message, transportBufferSize),
+	}
+
+	n.transports[address] = t
+
+	return t
+}
+
+// Disconnect drops all messages sent to and from an address
+func (n *MemoryNetwork) Disconnect(address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.disconnected[address] = true
+}
+
+// Connect stops dropping messages sent to and from an address
+func (n *MemoryNetwork) Connect(address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.disconnected, address)
+}
+
+// Send sends a message to the transport at an address. The message
+// is dropped if the address is disconnected or its buffer is full
+func (t *MemoryTransport) Send(address string, m *Message) error {
+	t.network.mu.RLock()
+	defer t.network.mu.RUnlock()
+
+	if t.closed {
+		return ErrTransportClosed
+	}
+
+	to := t.network.transports[address]
+	if to == nil || to.closed || t.network.disconnected[address] || t.network.disconnected[t.address] {
+		return nil
+	}
+
+	select {
+	case to.messages <- m:
+	default:
+	}
+
+	return nil
+}
+
+// Receive returns the channel messages sent to this transport are delivered on
+func (t *MemoryTransport) Receive() <-chan *Message {
+	return t.messages
+}
+
+// Close stops sending and receiving messages
+func (t *MemoryTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+
+	t.closed = true
+
+	if t.network.transports[t.address] == t {
+		delete(t.network.transports, t.address)
+	}
+
+	return nil
+}
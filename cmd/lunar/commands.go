@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/purehyperbole/lunar"
+	"github.com/purehyperbole/lunar/header"
+)
+
+var getCommand = &command{
+	usage:       "<path> <key>",
+	description: "print the value of a key",
+	min:         2,
+	max:         2,
+	run: func(e *env, f *flags) error {
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		value, err := db.Gets(f.Arg(1))
+		if err != nil {
+			return err
+		}
+
+		_, err = e.stdout.Write(value)
+
+		return err
+	},
+}
+
+var setCommand = &command{
+	usage:       "<path> <key> [value]",
+	description: "set the value of a key, reading the value from stdin if not provided",
+	min:         2,
+	max:         3,
+	run: func(e *env, f *flags) error {
+		var value []byte
+
+		if f.NArg() > 2 {
+			value = []byte(f.Arg(2))
+		} else {
+			var err error
+
+			value, err = ioutil.ReadAll(e.stdin)
+			if err != nil {
+				return err
+			}
+		}
+
+		db, err := f.open(true)
+		if err != nil {
+			return err
+		}
+
+		err = db.Sets(f.Arg(1), value)
+		if err != nil {
+			db.Close()
+			return err
+		}
+
+		return db.Close()
+	},
+}
+
+var deleteCommand = &command{
+	usage:       "<path> <key>",
+	description: "delete a key",
+	min:         2,
+	max:         2,
+	run: func(e *env, f *flags) error {
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		err = db.Deletes(f.Arg(1))
+		if err != nil {
+			db.Close()
+			return err
+		}
+
+		return db.Close()
+	},
+}
+
+var scanCommand = &command{
+	usage:       "[--prefix prefix] [--keys-only] <path>",
+	description: "print all keys and values, in key order",
+	min:         1,
+	max:         1,
+	flags: func(f *flags) {
+		f.StringVar(&f.prefix, "prefix", "", "only print keys with the given prefix")
+		f.BoolVar(&f.keysOnly, "keys-only", false, "only print keys")
+	},
+	run: func(e *env, f *flags) error {
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		return db.Scan([]byte(f.prefix), func(key, value []byte) error {
+			if f.keysOnly {
+				_, err := fmt.Fprintln(e.stdout, printable(key))
+				return err
+			}
+
+			_, err := fmt.Fprintf(e.stdout, "%s\t%s\n", printable(key), printable(value))
+			return err
+		})
+	},
+}
+
+var dumpCommand = &command{
+	usage:       "<path>",
+	description: "print every record in the data files, in the order they were written",
+	min:         1,
+	max:         1,
+	run: func(e *env, f *flags) error {
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		return db.Walk(func(r *lunar.Record) error {
+			_, err := fmt.Fprintf(e.stdout, "Segment: %d Offset: %d Key: %s\n", r.Segment, r.Offset, printable(r.Key))
+			if err != nil {
+				return err
+			}
+
+			header.Fprint(e.stdout, r.Header)
+
+			return nil
+		})
+	},
+}
+
+var statsCommand = &command{
+	usage:       "<path>",
+	description: "print statistics about the database",
+	min:         1,
+	max:         1,
+	run: func(e *env, f *flags) error {
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		stats := db.Stats()
+
+		fmt.Fprintf(e.stdout, "segments: %d\n", stats.Segments)
+		fmt.Fprintf(e.stdout, "keys: %d\n", stats.Keys)
+		fmt.Fprintf(e.stdout, "deleted: %d\n", stats.Deleted)
+		fmt.Fprintf(e.stdout, "size: %d\n", stats.Size)
+		fmt.Fprintf(e.stdout, "used: %d\n", stats.Used)
+		fmt.Fprintf(e.stdout, "live: %d\n", stats.Live)
+
+		return nil
+	},
+}
+
+var compactCommand = &command{
+	usage:       "<path>",
+	description: "remove stale records from the database",
+	min:         1,
+	max:         1,
+	run: func(e *env, f *flags) error {
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		err = db.Compact()
+		if err != nil {
+			db.Close()
+			return err
+		}
+
+		return db.Close()
+	},
+}
+
+var verifyCommand = &command{
+	usage:       "<path>",
+	description: "check every record and index entry in the database",
+	min:         1,
+	max:         1,
+	run: func(e *env, f *flags) error {
+		report, err := lunar.Check(f.Arg(0), f.options()...)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range report.Problems {
+			fmt.Fprintln(e.stdout, p)
+		}
+
+		fmt.Fprintf(e.stdout, "segments: %d records: %d keys: %d problems: %d\n", report.Segments, report.Records, report.Keys, len(report.Problems))
+
+		if !report.OK() {
+			return errors.New("verification failed")
+		}
+
+		// ensure the index can be built from the data files
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		report, err = db.Verify(context.Background())
+		if err != nil {
+			return err
+		}
+
+		for _, p := range report.Problems {
+			fmt.Fprintln(e.stdout, p)
+		}
+
+		if !report.OK() {
+			return errors.New("verification failed")
+		}
+
+		return nil
+	},
+}
+
+var backupCommand = &command{
+	usage:       "[--since position] <path> [file]",
+	description: "write a backup of the database to a file or stdout, printing the position to use for an incremental backup",
+	min:         1,
+	max:         2,
+	flags: func(f *flags) {
+		f.Uint64Var(&f.since, "since", 0, "only backup records written since a previous backup's position")
+	},
+	run: func(e *env, f *flags) error {
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		if f.NArg() < 2 {
+			return backup(e, db, e.stdout, f.since)
+		}
+
+		fd, err := os.OpenFile(f.Arg(1), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+
+		err = backup(e, db, fd, f.since)
+		if err != nil {
+			fd.Close()
+			return err
+		}
+
+		err = fd.Sync()
+		if err != nil {
+			fd.Close()
+			return err
+		}
+
+		return fd.Close()
+	},
+}
+
+var upgradeCommand = &command{
+	usage:       "<path>",
+	description: "convert a data file written before records were versioned",
+	min:         1,
+	max:         1,
+	run: func(e *env, f *flags) error {
+		return lunar.Upgrade(f.Arg(0))
+	},
+}
+
+var restoreCommand = &command{
+	usage:       "<path> [file]",
+	description: "restore a database from a backup file or stdin",
+	min:         1,
+	max:         2,
+	run: func(e *env, f *flags) error {
+		r := e.stdin
+
+		if f.NArg() > 1 {
+			fd, err := os.Open(f.Arg(1))
+			if err != nil {
+				return err
+			}
+
+			defer fd.Close()
+
+			r = fd
+		}
+
+		return lunar.Restore(r, f.Arg(0), f.options()...)
+	},
+}
+
+var exportCommand = &command{
+	usage:       "[--format format] <path> [file]",
+	description: "write every key and value to a file or stdout, in key order",
+	min:         1,
+	max:         2,
+	flags:       (*flags).addFormat,
+	run: func(e *env, f *flags) error {
+		format, err := lunar.ParseFormat(f.format)
+		if err != nil {
+			return err
+		}
+
+		db, err := f.open(false)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		if f.NArg() < 2 {
+			return db.Export(e.stdout, format)
+		}
+
+		fd, err := os.OpenFile(f.Arg(1), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+
+		err = db.Export(fd, format)
+		if err != nil {
+			fd.Close()
+			return err
+		}
+
+		return fd.Close()
+	},
+}
+
+var importCommand = &command{
+	usage:       "[--format format] <path> [file]",
+	description: "set keys and values read from a file or stdin",
+	min:         1,
+	max:         2,
+	flags:       (*flags).addFormat,
+	run: func(e *env, f *flags) error {
+		format, err := lunar.ParseFormat(f.format)
+		if err != nil {
+			return err
+		}
+
+		r := e.stdin
+
+		if f.NArg() > 1 {
+			fd, err := os.Open(f.Arg(1))
+			if err != nil {
+				return err
+			}
+
+			defer fd.Close()
+
+			r = fd
+		}
+
+		db, err := f.open(true)
+		if err != nil {
+			return err
+		}
+
+		err = db.Import(r, format)
+		if err != nil {
+			db.Close()
+			return err
+		}
+
+		return db.Close()
+	},
+}
+
+func backup(e *env, db *lunar.DB, w io.Writer, since uint64) error {
+	pos, err := db.Backup(w, since)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(e.stderr, "position: %d\n", pos)
+
+	return err
+}
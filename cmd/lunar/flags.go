@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/purehyperbole/lunar"
+)
+
+var (
+	errUsage = errors.New("invalid arguments")
+	errHelp  = errors.New("help requested")
+)
+
+// flags the flags and arguments of a command
+type flags struct {
+	*flag.FlagSet
+	segmentSize int64
+	prefix      string
+	keysOnly    bool
+	since       uint64
+	format      string
+	resp        string
+	http        string
+	memcache    string
+}
+
+func newFlags(e *env, name string, cmd *command) *flags {
+	f := flags{
+		FlagSet: flag.NewFlagSet(name, flag.ContinueOnError),
+	}
+
+	f.SetOutput(e.stderr)
+	f.Int64Var(&f.segmentSize, "segment-size", 0, "maximum size of each segment, if the database is segmented")
+
+	f.Usage = func() {
+		fmt.Fprintf(e.stderr, "usage: lunar %s %s\n\n%s\n\nflags:\n", name, cmd.usage, cmd.description)
+		f.PrintDefaults()
+	}
+
+	return &f
+}
+
+// parse parses the flags and checks the number of remaining arguments
+func (f *flags) parse(args []string, min, max int) error {
+	err := f.Parse(args)
+	if err == flag.ErrHelp {
+		return errHelp
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if f.NArg() < min || f.NArg() > max {
+		f.Usage()
+		return errUsage
+	}
+
+	return nil
+}
+
+// options returns the options used to open the database
+func (f *flags) options() []func(*lunar.DB) error {
+	var opts []func(*lunar.DB) error
+
+	if f.segmentSize > 0 {
+		opts = append(opts, lunar.SegmentSize(f.segmentSize))
+	}
+
+	return opts
+}
+
+// addFormat adds the format flag used by export and import
+func (f *flags) addFormat() {
+	f.StringVar(&f.format, "format", "jsonl", "format of the data, one of jsonl, jsonl-base64 or csv")
+}
+
+// open opens the database specified by the first argument.
+// the database will only be created if create is true
+func (f *flags) open(create bool) (*lunar.DB, error) {
+	_, err := os.Stat(f.Arg(0))
+	if err != nil && (!create || !os.IsNotExist(err)) {
+		return nil, err
+	}
+
+	return lunar.Open(f.Arg(0), f.options()...)
+}
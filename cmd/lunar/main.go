@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command a lunar subcommand
+type command struct {
+	usage       string
+	description string
+	min, max    int            // number of arguments accepted
+	flags       func(f *flags) // registers any additional flags
+	run         func(e *env, f *flags) error
+}
+
+// env the input and outputs available to a command
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands = map[string]*command{
+	"get":     getCommand,
+	"set":     setCommand,
+	"delete":  deleteCommand,
+	"scan":    scanCommand,
+	"dump":    dumpCommand,
+	"stats":   statsCommand,
+	"compact": compactCommand,
+	"verify":  verifyCommand,
+	"upgrade": upgradeCommand,
+	"backup":  backupCommand,
+	"restore": restoreCommand,
+	"export":  exportCommand,
+	"import":  importCommand,
+	"serve":   serveCommand,
+}
+
+func main() {
+	err := run(os.Args[1:], &env{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	})
+
+	if err == errHelp {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "lunar:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, e *env) error {
+	if len(args) < 1 {
+		usage(e.stderr)
+		return errUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(e.stderr)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	f := newFlags(e, args[0], cmd)
+
+	if cmd.flags != nil {
+		cmd.flags(f)
+	}
+
+	err := f.parse(args[1:], cmd.min, cmd.max)
+	if err != nil {
+		return err
+	}
+
+	return cmd.run(e, f)
+}
+
+func usage(w io.Writer) {
+	var names []string
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: lunar <command> [flags] <path> [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].description)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run 'lunar <command> -h' for details of a command")
+}
+
+// printable returns data as a string, quoting it if it contains non printable characters
+func printable(data []byte) string {
+	s := string(data)
+
+	if strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f || r == 0xfffd }) < 0 {
+		return s
+	}
+
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/purehyperbole/lunar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRun(t *testing.T, stdin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := run(args, &env{
+		stdin:  strings.NewReader(stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+	})
+
+	return stdout.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	defer os.Remove("test.db")
+	defer os.Remove("test.db.hint")
+	defer os.Remove("test.bak")
+	defer os.Remove("test-restore.db")
+	defer os.Remove("test-import.db")
+
+	_, err := testRun(t, "", "get", "test.db", "test-key")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = testRun(t, "", "set", "test.db", "test-key", "test-1234")
+	require.Nil(t, err)
+
+	_, err = testRun(t, "test-4567", "set", "test.db", "test-key-2")
+	require.Nil(t, err)
+
+	_, err = testRun(t, "", "set", "test.db", "other-key", "test")
+	require.Nil(t, err)
+
+	out, err := testRun(t, "", "get", "test.db", "test-key")
+	require.Nil(t, err)
+	assert.Equal(t, "test-1234", out)
+
+	out, err = testRun(t, "", "scan", "--prefix", "test", "test.db")
+	require.Nil(t, err)
+	assert.Equal(t, "test-key\ttest-1234\ntest-key-2\ttest-4567\n", out)
+
+	_, err = testRun(t, "", "delete", "test.db", "test-key")
+	require.Nil(t, err)
+
+	out, err = testRun(t, "", "scan", "--keys-only", "test.db")
+	require.Nil(t, err)
+	assert.Equal(t, "other-key\ntest-key-2\n", out)
+
+	out, err = testRun(t, "", "dump", "test.db")
+	require.Nil(t, err)
+	assert.Contains(t, out, "Segment: 0 Offset: 0 Key: test-key\n")
+	assert.Equal(t, 4, strings.Count(out, "Segment:"))
+
+	out, err = testRun(t, "", "stats", "test.db")
+	require.Nil(t, err)
+	assert.Contains(t, out, "keys: 2\n")
+	assert.Contains(t, out, "deleted: 1\n")
+
+	_, err = testRun(t, "", "compact", "test.db")
+	require.Nil(t, err)
+
+	out, err = testRun(t, "", "verify", "test.db")
+	require.Nil(t, err)
+	assert.Contains(t, out, "problems: 0")
+
+	_, err = testRun(t, "", "backup", "test.db", "test.bak")
+	require.Nil(t, err)
+
+	_, err = testRun(t, "", "restore", "test-restore.db", "test.bak")
+	require.Nil(t, err)
+
+	out, err = testRun(t, "", "scan", "test-restore.db")
+	require.Nil(t, err)
+	assert.Equal(t, "other-key\ttest\ntest-key-2\ttest-4567\n", out)
+
+	out, err = testRun(t, "", "export", "--format", "csv", "test.db")
+	require.Nil(t, err)
+	assert.Equal(t, "other-key,test\ntest-key-2,test-4567\n", out)
+
+	_, err = testRun(t, out, "import", "--format", "csv", "test-import.db")
+	require.Nil(t, err)
+
+	out, err = testRun(t, "", "scan", "test-import.db")
+	require.Nil(t, err)
+	assert.Equal(t, "other-key\ttest\ntest-key-2\ttest-4567\n", out)
+
+	_, err = testRun(t, "", "export", "--format", "xml", "test.db")
+	assert.Equal(t, lunar.ErrInvalidFormat, err)
+
+	_, err = testRun(t, "", "serve", "test.db")
+	assert.Equal(t, errUsage, err)
+
+	_, err = testRun(t, "", "unknown")
+	assert.NotNil(t, err)
+
+	_, err = testRun(t, "", "get", "test.db")
+	assert.Equal(t, errUsage, err)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/purehyperbole/lunar"
+	lhttp "github.com/purehyperbole/lunar/server/http"
+	"github.com/purehyperbole/lunar/server/memcache"
+	"github.com/purehyperbole/lunar/server/resp"
+)
+
+// server a network server for a database
+type server interface {
+	Serve(l net.Listener) error
+	Close() error
+}
+
+// listener a server and the address it should listen on
+type listener struct {
+	name    string
+	address string
+	create  func(db *lunar.DB) server
+}
+
+var serveCommand = &command{
+	usage:       "[--resp address] [--http address] [--memcache address] <path>",
+	description: "serve the database over the network until interrupted",
+	min:         1,
+	max:         1,
+	flags: func(f *flags) {
+		f.StringVar(&f.resp, "resp", "", "address to serve the redis protocol on")
+		f.StringVar(&f.http, "http", "", "address to serve the http api on")
+		f.StringVar(&f.memcache, "memcache", "", "address to serve the memcached text protocol on")
+	},
+	run: func(e *env, f *flags) error {
+		listeners := []*listener{
+			{"redis protocol", f.resp, func(db *lunar.DB) server { return resp.New(db) }},
+			{"http api", f.http, func(db *lunar.DB) server { return &http.Server{Handler: lhttp.NewHandler(db)} }},
+			{"memcached protocol", f.memcache, func(db *lunar.DB) server { return memcache.New(db) }},
+		}
+
+		var enabled []*listener
+
+		for _, l := range listeners {
+			if l.address != "" {
+				enabled = append(enabled, l)
+			}
+		}
+
+		if len(enabled) < 1 {
+			f.Usage()
+			return errUsage
+		}
+
+		db, err := f.open(true)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		return serve(e, db, enabled)
+	},
+}
+
+// serve runs servers until one of them fails or the process is interrupted
+func serve(e *env, db *lunar.DB, listeners []*listener) error {
+	var servers []server
+
+	defer func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}()
+
+	errs := make(chan error, len(listeners))
+
+	for _, l := range listeners {
+		nl, err := net.Listen("tcp", l.address)
+		if err != nil {
+			return err
+		}
+
+		s := l.create(db)
+		servers = append(servers, s)
+
+		go func() {
+			errs <- s.Serve(nl)
+		}()
+
+		fmt.Fprintf(e.stderr, "serving %s on %s\n", l.name, nl.Addr())
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-sig:
+		return nil
+	}
+}
@@ -0,0 +1,174 @@
+package lunar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrInvalidKey the key could not be decoded
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// Codec encodes and decodes values of a type
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec encodes values as json
+type JSONCodec[T any] struct{}
+
+// Encode encodes a value as json
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode decodes a value from json
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob. Each value is encoded
+// with its own type information, so values can be decoded individually
+type GobCodec[T any] struct{}
+
+// Encode encodes a value with gob
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// Decode decodes a value with gob
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// ProtoMessage a message that can be marshaled to and from the protobuf wire
+// format, such as those generated by gogo/protobuf or vtprotobuf
+type ProtoMessage[T any] interface {
+	*T
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// ProtoCodec encodes messages in the protobuf wire format
+type ProtoCodec[T any, PT ProtoMessage[T]] struct{}
+
+// NewProtoCodec creates a codec for a protobuf message type
+func NewProtoCodec[T any, PT ProtoMessage[T]]() ProtoCodec[T, PT] {
+	return ProtoCodec[T, PT]{}
+}
+
+// Encode encodes a message in the protobuf wire format
+func (ProtoCodec[T, PT]) Encode(v T) ([]byte, error) {
+	return PT(&v).Marshal()
+}
+
+// Decode decodes a message from the protobuf wire format
+func (ProtoCodec[T, PT]) Decode(data []byte) (T, error) {
+	var v T
+	err := PT(&v).Unmarshal(data)
+	return v, err
+}
+
+// MsgpackMessage a value that can be marshaled to and from msgpack,
+// such as those generated by tinylib/msgp
+type MsgpackMessage[T any] interface {
+	*T
+	MarshalMsg(b []byte) ([]byte, error)
+	UnmarshalMsg(data []byte) ([]byte, error)
+}
+
+// MsgpackCodec encodes values as msgpack
+type MsgpackCodec[T any, PT MsgpackMessage[T]] struct{}
+
+// NewMsgpackCodec creates a codec for a msgpack type
+func NewMsgpackCodec[T any, PT MsgpackMessage[T]]() MsgpackCodec[T, PT] {
+	return MsgpackCodec[T, PT]{}
+}
+
+// Encode encodes a value as msgpack
+func (MsgpackCodec[T, PT]) Encode(v T) ([]byte, error) {
+	return PT(&v).MarshalMsg(nil)
+}
+
+// Decode decodes a value from msgpack
+func (MsgpackCodec[T, PT]) Decode(data []byte) (T, error) {
+	var v T
+	_, err := PT(&v).UnmarshalMsg(data)
+	return v, err
+}
+
+// BytesCodec stores byte slices as they are
+type BytesCodec struct{}
+
+// Encode returns the byte slice
+func (BytesCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+// Decode returns the byte slice
+func (BytesCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// StringCodec stores strings as their bytes
+type StringCodec struct{}
+
+// Encode returns the bytes of a string
+func (StringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+// Decode returns the bytes as a string
+func (StringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// Uint64Codec encodes integers as big endian bytes, so keys sort in numeric order
+type Uint64Codec struct{}
+
+// Encode encodes an integer as big endian bytes
+func (Uint64Codec) Encode(v uint64) ([]byte, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, v)
+	return data, nil
+}
+
+// Decode decodes an integer from big endian bytes
+func (Uint64Codec) Decode(data []byte) (uint64, error) {
+	if len(data) != 8 {
+		return 0, ErrInvalidKey
+	}
+
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// Int64Codec encodes integers as big endian bytes with the sign bit
+// flipped, so keys sort in numeric order, including negative numbers
+type Int64Codec struct{}
+
+// Encode encodes an integer as big endian bytes
+func (Int64Codec) Encode(v int64) ([]byte, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(v)^1<<63)
+	return data, nil
+}
+
+// Decode decodes an integer from big endian bytes
+func (Int64Codec) Decode(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, ErrInvalidKey
+	}
+
+	return int64(binary.BigEndian.Uint64(data) ^ 1<<63), nil
+}
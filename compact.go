@@ -0,0 +1,375 @@
+package lunar
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+)
+
+// Compact removes stale records from the database.
+// When using segments, the live records of every sealed segment
+// are rewritten to the active segment and the sealed segment is deleted.
+// When using a single data file, the file is rewritten and
+// all reads and writes are blocked until compaction is complete.
+// A hint file is written for each remaining data file, and value
+// files that are no longer referenced by a key are removed
+func (db *DB) Compact() error {
+	return db.CompactContext(context.Background())
+}
+
+// CompactContext compacts the database, stopping when the context is done.
+// Records that have already been moved to the active segment remain there,
+// and the remaining records are compacted by the next compaction
+func (db *DB) CompactContext(ctx context.Context) error {
+	start := time.Now()
+
+	err := db.compact(ctx)
+
+	switch {
+	case err != nil && err == ctx.Err():
+		db.logger.Info("compaction cancelled", "path", db.path, "error", err)
+	case err != nil:
+		db.logger.Error("compaction failed", "path", db.path, "error", err)
+	default:
+		db.logger.Info("compacted database", "path", db.path, "duration", time.Since(start))
+	}
+
+	return err
+}
+
+func (db *DB) compact(ctx context.Context) error {
+	if !db.segmented() {
+		err := db.compactFile(ctx)
+		if err != nil {
+			return err
+		}
+
+		db.metrics.Compaction(1, 1)
+
+		return db.removeValues()
+	}
+
+	s := db.current()
+
+	ids := s.ids()
+
+	var done int
+
+	for _, id := range ids {
+		if id == s.active {
+			continue
+		}
+
+		err := db.compactSegment(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		done++
+
+		// every segment except the active segment is compacted
+		db.metrics.Compaction(done, len(ids)-1)
+	}
+
+	err := db.writeHints()
+	if err != nil {
+		return err
+	}
+
+	return db.removeValues()
+}
+
+// CompactSegment rewrites the live records of a sealed
+// segment to the active segment and deletes the sealed segment
+func (db *DB) CompactSegment(id uint32) error {
+	err := db.compactSegment(context.Background(), id)
+	if err != nil {
+		return err
+	}
+
+	return db.writeHints()
+}
+
+func (db *DB) compactSegment(ctx context.Context, id uint32) error {
+	t := db.table(id)
+	if t == nil {
+		return ErrSegmentNotFound
+	}
+
+	if id == db.current().active {
+		return ErrSegmentActive
+	}
+
+	// tombstones and expired keys can only be removed if there
+	// are no older segments that may contain the deleted key
+	drop := db.current().ids()[0] == id
+
+	err := scan(t, func(h *header.Header, key []byte, offset int64) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return db.relocate(id, key, h, offset, drop)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// wait for any reads of the segment to complete before removing it
+	db.snapshot.Lock()
+	defer db.snapshot.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.logger.Debug("compacted segment", "path", db.path, "segment", id)
+
+	return db.remove(id)
+}
+
+// relocate copies a record to the active segment if
+// it is the current version of the key's value
+func (db *DB) relocate(id uint32, key []byte, h *header.Header, offset int64, drop bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	e := db.lookup(key)
+	if e == nil {
+		return nil
+	}
+
+	// the record may be part of a chain of merge operands, which
+	// is collapsed so it no longer refers to the segment
+	if e.live() {
+		collapsed, err := db.collapse(key, e)
+		if collapsed || err != nil {
+			return err
+		}
+	}
+
+	if e.segment != id || e.offset != offset {
+		return nil
+	}
+
+	if !e.live() && drop {
+		db.setEntry(key, nil)
+
+		// readers of changes that have not read the record will miss it
+		atomic.StoreUint64(&db.dropped, db.position(id, offset+h.TotalSize()))
+
+		return nil
+	}
+
+	data, err := db.table(id).Read(h.TotalSize(), offset)
+	if err != nil {
+		return err
+	}
+
+	seg, off, err := db.write(detach(data))
+	if err != nil {
+		return err
+	}
+
+	db.setEntry(key, &entry{
+		segment:   seg,
+		size:      h.TotalSize(),
+		offset:    off,
+		tombstone: e.tombstone,
+		expires:   e.expires,
+	})
+
+	return nil
+}
+
+// compactFile rewrites the live records of a single data file to a new file
+func (db *DB) compactFile(ctx context.Context) error {
+	// the data file is replaced, so it must not be read without db.mu
+	err := lock(ctx, db.snapshot.Lock, db.snapshot.Unlock)
+	if err != nil {
+		return err
+	}
+
+	defer db.snapshot.Unlock()
+
+	err = lock(ctx, db.mu.Lock, db.mu.Unlock)
+	if err != nil {
+		return err
+	}
+
+	defer db.mu.Unlock()
+
+	tmp := db.path + ".compact"
+
+	ct, err := db.openTable(tmp)
+	if err != nil {
+		return err
+	}
+
+	var entries []*entry
+	var keys [][]byte
+
+	err = scan(db.table(0), func(h *header.Header, key []byte, offset int64) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		e := db.lookup(key)
+		if e == nil || e.offset != offset {
+			return nil
+		}
+
+		// all versions of a deleted or expired key are removed
+		if !e.live() {
+			keys = append(keys, key)
+			entries = append(entries, nil)
+			return nil
+		}
+
+		data, err := db.table(0).Read(h.TotalSize(), offset)
+		if err != nil {
+			return err
+		}
+
+		// chains of merge operands are collapsed into their merged value
+		if h.Merge() {
+			value, err := db.merged(key, h, data)
+			if err != nil {
+				return err
+			}
+
+			var nh header.Header
+
+			data, err = db.separate(record(&nh, key, value))
+			if err != nil {
+				return err
+			}
+		}
+
+		off, err := ct.Write(detach(data))
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, key)
+		entries = append(entries, &entry{
+			size:   int64(len(data)),
+			offset: off,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		ct.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	// remove the old hint file first, so it is never used with the new data file
+	err = db.removeHint(0)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, db.path)
+	if err != nil {
+		return err
+	}
+
+	old := db.table(0)
+
+	db.rotation.Lock()
+	db.store(db.current().with(0, ct))
+	db.rotation.Unlock()
+
+	for i := range keys {
+		if entries[i] == nil {
+			db.setEntry(keys[i], nil)
+			continue
+		}
+
+		db.setEntry(keys[i], entries[i])
+	}
+
+	err = old.Close()
+	if err != nil {
+		return err
+	}
+
+	return db.writeHint(0, ct)
+}
+
+// writeHints writes a hint file for every segment that does not have one.
+// the hint file for the active segment is always rewritten, as it
+// will only cover the records written to it so far
+func (db *DB) writeHints() error {
+	// prevent hint files covering a batch that is being written
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	s := db.current()
+
+	for _, id := range s.ids() {
+		if id != s.active && exists(db.hintPath(id)) {
+			continue
+		}
+
+		err := db.writeHint(id, s.tables[id])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scan calls fn for every record in a table, in the order they were written
+func scan(t *table.Table, fn func(h *header.Header, key []byte, offset int64) error) error {
+	_, err := scanRange(t, 0, t.Position(), func(h *header.Header, data []byte, offset int64) error {
+		key := make([]byte, h.KeySize())
+		copy(key, data[header.HeaderSize:h.DataOffset()])
+
+		return fn(h, key, offset)
+	})
+
+	return err
+}
+
+// scanRange calls fn with the data of every record in a table between two
+// offsets, returning the offset that scanning stopped at. scanning stops early
+// if a record has not been written yet. the data is only valid until fn returns
+func scanRange(t *table.Table, from, to int64, fn func(h *header.Header, data []byte, offset int64) error) (int64, error) {
+	pos := from
+
+	for pos+header.HeaderSize <= to {
+		hd, err := t.Read(header.HeaderSize, pos)
+		if err != nil {
+			return pos, err
+		}
+
+		h := header.Deserialize(hd)
+
+		if h.KeySize() < 1 {
+			return pos, nil
+		}
+
+		data, err := t.Read(h.TotalSize(), pos)
+		if err != nil {
+			return pos, err
+		}
+
+		err = fn(h, data, pos)
+		if err != nil {
+			return pos, err
+		}
+
+		pos = pos + h.TotalSize()
+	}
+
+	return pos, nil
+}
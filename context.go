@@ -0,0 +1,118 @@
+package lunar
+
+import (
+	"context"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+)
+
+// GetContext get a value by key, unless the context is done
+// before reads are unblocked by compaction. The context is not
+// checked once the value is being read
+func (db *DB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	start := time.Now()
+
+	err := lock(ctx, db.mu.RLock, db.mu.RUnlock)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.mu.RUnlock()
+
+	value, _, err := db.get(key)
+
+	db.metrics.Get(time.Since(start))
+
+	return value, err
+}
+
+// SetContext set value by key, unless the context is done
+// before writes are unblocked by compaction. A write that has
+// started, including growing the data file, is not interrupted
+func (db *DB) SetContext(ctx context.Context, key, value []byte) error {
+	var h header.Header
+
+	return db.putContext(ctx, key, record(&h, key, value))
+}
+
+// DeleteContext delete a value by key, unless the context is done
+// before writes are unblocked by compaction. A write that has
+// started, including growing the data file, is not interrupted
+func (db *DB) DeleteContext(ctx context.Context, key []byte) error {
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	err = lock(ctx, db.mu.RLock, db.mu.RUnlock)
+	if err != nil {
+		return err
+	}
+
+	defer db.mu.RUnlock()
+
+	e := db.lookup(key)
+	if e == nil || !e.live() {
+		return ErrNotFound
+	}
+
+	var h header.Header
+
+	// deleted records are marked with an xmax
+	h.SetXmax(1)
+
+	err = db.commit(ctx, key, record(&h, key, nil))
+
+	db.metrics.Set(time.Since(start))
+
+	return err
+}
+
+// ScanContext calls fn for every key with the given prefix and its value,
+// in key order, until the context is done. Keys stored in buckets are
+// not included. If fn returns an error, iteration stops and the error
+// is returned
+func (db *DB) ScanContext(ctx context.Context, prefix []byte, fn func(key, value []byte) error) error {
+	return db.Scan(prefix, func(key, value []byte) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fn(key, value)
+	})
+}
+
+// lock acquires a lock, unless the context is done before it is acquired.
+// if the context is done first, the lock is released once it is acquired
+func lock(ctx context.Context, acquire, release func()) error {
+	if ctx.Done() == nil {
+		acquire()
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	acquired := make(chan struct{})
+
+	go func() {
+		acquire()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			release()
+		}()
+
+		return ctx.Err()
+	}
+}
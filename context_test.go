@@ -0,0 +1,124 @@
+package lunar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContext(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	ctx := context.Background()
+
+	require.Nil(t, db.SetContext(ctx, []byte("test-key"), []byte("test")))
+
+	value, err := db.GetContext(ctx, []byte("test-key"))
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), value)
+
+	require.Nil(t, db.DeleteContext(ctx, []byte("test-key")))
+	assert.Equal(t, ErrNotFound, db.DeleteContext(ctx, []byte("test-key")))
+
+	// a cancelled context
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	assert.Equal(t, context.Canceled, db.SetContext(cctx, []byte("test-key"), []byte("test")))
+
+	_, err = db.GetContext(cctx, []byte("test-key"))
+	assert.Equal(t, context.Canceled, err)
+
+	// writes blocked by compaction
+	db.mu.Lock()
+
+	tctx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, db.SetContext(tctx, []byte("test-key"), []byte("test")))
+
+	db.mu.Unlock()
+
+	require.Nil(t, db.SetContext(ctx, []byte("test-key"), []byte("test")))
+
+	// deletes blocked by compaction
+	db.mu.Lock()
+
+	tctx, cancel = context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, db.DeleteContext(tctx, []byte("test-key")))
+
+	db.mu.Unlock()
+
+	assert.Equal(t, context.Canceled, db.DeleteContext(cctx, []byte("test-key")))
+	require.Nil(t, db.Delete([]byte("test-key")))
+}
+
+func TestScanContext(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("test-key-%d", i), []byte("test")))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var count int
+
+	err = db.ScanContext(ctx, []byte("test-key-"), func(key, value []byte) error {
+		count++
+
+		if count == 5 {
+			cancel()
+		}
+
+		return nil
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 5, count)
+}
+
+func TestCompactContext(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	db, err := Open("test-segments", SegmentSize(1<<12))
+	require.Nil(t, err)
+	defer db.Close()
+
+	value := make([]byte, 1000)
+
+	for i := 0; i < 20; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("test-key-%d", i%5), value))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, db.CompactContext(ctx))
+
+	ids, err := segmentIDs("test-segments")
+	require.Nil(t, err)
+	assert.Len(t, ids, 7)
+
+	require.Nil(t, db.CompactContext(context.Background()))
+
+	for i := 0; i < 5; i++ {
+		data, err := db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Len(t, data, 1000)
+	}
+}
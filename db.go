@@ -1,7 +1,14 @@
 package lunar
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/purehyperbole/lunar/header"
 	"github.com/purehyperbole/lunar/table"
@@ -10,23 +17,68 @@ import (
 
 // DB Database
 type DB struct {
-	index      *rad.Radix
-	data       *table.Table
-	compaction bool // compaction on file open
+	counts         counts // accessed atomically, so must be 64 bit aligned
+	dropped        uint64 // position following the last deleted or expired record removed by compaction, accessed atomically
+	index          *rad.Radix
+	segments       unsafe.Pointer       // *segments
+	rotation       sync.Mutex           // held when adding or removing segments
+	bucketMu       sync.Mutex           // held when creating a bucket
+	mu             sync.RWMutex         // held exclusively when compaction relocates records
+	snapshot       sync.RWMutex         // held while data files are read without mu, and exclusively when they are removed
+	keys           [keyLocks]sync.Mutex // held when a key is written, by the hash of the key
+	writes         sync.RWMutex         // held while records are copied to a data file, and exclusively to wait for them
+	path           string
+	segmentSize    int64                 // maximum size of a segment, zero if using a single data file
+	compaction     bool                  // compaction on file open
+	changed        unsafe.Pointer        // *chan struct{}, closed when a record is written
+	waiters        int32                 // number of readers waiting for a record to be written
+	follower       *follower             // replication state, if the database is a follower
+	values         values                // value files storing values outside of the data files
+	valueThreshold int64                 // values larger than the threshold are stored in the value log, zero if disabled
+	indexes        map[string]*secondary // secondary indexes by name
+	merger         MergeOperator         // combines merge operands with a key's value
+	logger         Logger                // logs events, discarded by default
+	metrics        Metrics               // measures operations, discarded by default
+}
+
+// counts the number of keys and deleted keys in the index, and
+// the size of the current version of every key that is not deleted
+type counts struct {
+	keys    int64
+	deleted int64
+	live    int64
 }
 
 type entry struct {
-	offset int64
-	size   int64
+	segment   uint32
+	offset    int64
+	size      int64
+	tombstone bool  // the key has been deleted
+	expires   int64 // unix time in nanoseconds that the key expires, zero if it does not expire
 }
 
+// keyLocks the number of locks that writes to keys are distributed across
+const keyLocks = 64
+
 var (
 	ErrNotFound = errors.New("key not found")
+	// ErrSegmentNotFound the specified segment does not exist
+	ErrSegmentNotFound = errors.New("segment not found")
+	// ErrSegmentActive the specified segment is being written to
+	ErrSegmentActive = errors.New("segment is active")
+	// ErrCorrupt the data file contains an invalid record
+	ErrCorrupt = errors.New("data file is corrupt")
+	// ErrEmptyKey keys must contain at least one byte
+	ErrEmptyKey = errors.New("key must not be empty")
+	// ErrIncompatible the data file was written with a different version of the record format
+	ErrIncompatible = errors.New("data file format is incompatible")
+	// ErrInternalKey keys must not start with the prefix of the keys used internally
+	ErrInternalKey = errors.New("key must not start with the internal key prefix")
 )
 
 // Open open a database table and index, will create both if they dont exist
 func Open(path string, opts ...func(*DB) error) (*DB, error) {
-	var db DB
+	db := DB{logger: table.NopLogger{}, metrics: nopMetrics{}}
 
 	for _, opt := range opts {
 		err := opt(&db)
@@ -35,64 +87,319 @@ func Open(path string, opts ...func(*DB) error) (*DB, error) {
 		}
 	}
 
-	return &db, db.setup(path)
+	start := time.Now()
+
+	err := db.setup(path)
+	if err != nil {
+		db.logger.Error("failed to open database", "path", path, "error", err)
+		return &db, err
+	}
+
+	db.logger.Info("opened database", "path", path, "duration", time.Since(start))
+
+	db.purgeBuckets()
+
+	if db.follower == nil {
+		return &db, nil
+	}
+
+	return &db, db.follow()
 }
 
 // Close unmaps and closes data and index files
 func (db *DB) Close() error {
-	return db.data.Close()
+	s := db.current()
+	if s == nil {
+		return nil
+	}
+
+	db.mu.RLock()
+	f := db.follower
+	db.mu.RUnlock()
+
+	if f != nil {
+		err := db.unfollow(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range s.ids() {
+		err := s.tables[id].Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.closeValues()
 }
 
 // Get get a value by key
 func (db *DB) Get(key []byte) ([]byte, error) {
-	v := db.index.Lookup(key)
+	return db.GetContext(context.Background(), key)
+}
+
+// Set set value by key
+func (db *DB) Set(key, value []byte) error {
+	var h header.Header
+
+	return db.put(key, record(&h, key, value))
+}
+
+// Delete delete a value by key
+func (db *DB) Delete(key []byte) error {
+	return db.DeleteContext(context.Background(), key)
+}
+
+// SetTTL set value by key, which will expire after the given duration
+func (db *DB) SetTTL(key, value []byte, ttl time.Duration) error {
+	var h header.Header
+
+	h.SetExpires(time.Now().Add(ttl).UnixNano())
+
+	return db.put(key, record(&h, key, value))
+}
+
+// Expires returns the time a key expires, or the zero time if it does not expire
+func (db *DB) Expires(key []byte) (time.Time, error) {
+	e := db.lookup(key)
+	if e == nil || !e.live() {
+		return time.Time{}, ErrNotFound
+	}
+
+	if e.expires == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(0, e.expires), nil
+}
+
+// Sync flushes all writes to the data files and value log to disk
+func (db *DB) Sync() error {
+	// prevent compaction removing the data files while they are flushed
+	db.snapshot.RLock()
+	defer db.snapshot.RUnlock()
+
+	s := db.current()
+
+	for _, id := range s.ids() {
+		err := s.tables[id].Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	db.values.mu.Lock()
+	defer db.values.mu.Unlock()
+
+	if db.values.log == nil {
+		return nil
+	}
+
+	return db.values.log.Sync()
+}
+
+// Gets get a value by string key
+func (db *DB) Gets(key string) ([]byte, error) {
+	return db.Get([]byte(key))
+}
+
+// Sets set a value by string key
+func (db *DB) Sets(key string, value []byte) error {
+	return db.Set([]byte(key), value)
+}
+
+// Deletes delete a value by string key
+func (db *DB) Deletes(key string) error {
+	return db.Delete([]byte(key))
+}
+
+// put writes a serialized record to the active segment and indexes it
+func (db *DB) put(key, data []byte) error {
+	return db.putContext(context.Background(), key, data)
+}
+
+// putContext writes a serialized record to the active segment and
+// indexes it, unless the context is done before writes are unblocked
+func (db *DB) putContext(ctx context.Context, key, data []byte) error {
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
 
-	entry, ok := v.(*entry)
+	start := time.Now()
 
-	if v == nil || !ok {
-		return nil, ErrNotFound
+	err = lock(ctx, db.mu.RLock, db.mu.RUnlock)
+	if err != nil {
+		return err
+	}
+
+	defer db.mu.RUnlock()
+
+	err = db.commit(ctx, key, data)
+
+	db.metrics.Set(time.Since(start))
+
+	return err
+}
+
+// commit writes a serialized record and indexes it, unless the
+// context is already done. db.mu must be held
+func (db *DB) commit(ctx context.Context, key, data []byte) error {
+	// the write itself cannot be interrupted once it has started
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	err = db.writable()
+	if err != nil {
+		return err
+	}
+
+	data, err = db.separate(data)
+	if err != nil {
+		return err
 	}
 
-	data, err := db.data.Read(entry.size, entry.offset)
+	_, _, err = db.insert(key, data)
+
+	return err
+}
+
+// insert writes a serialized record and indexes it, returning its location.
+// db.mu must be held
+func (db *DB) insert(key, data []byte) (uint32, int64, error) {
+	// the index cannot store an empty key, so it must not be written
+	if len(key) < 1 {
+		return 0, 0, ErrEmptyKey
+	}
+
+	// concurrent writes to the same key are indexed in the order they are
+	// written, so merge operands are linked to the record written before
+	// them, and secondary indexes are updated with the last value written
+	mu := db.keyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	data = db.link(key, data)
+
+	seg, off, err := db.write(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	h := header.Deserialize(data)
+
+	db.setEntry(key, &entry{
+		segment:   seg,
+		size:      int64(len(data)),
+		offset:    off,
+		tombstone: h.Xmax() > 0,
+		expires:   h.Expires(),
+	})
+
+	db.reindex(key, data)
+
+	return seg, off, nil
+}
+
+// checkKey returns an error if a key cannot be written by the caller
+// of the database, because it is empty or is reserved for internal use
+func checkKey(key []byte) error {
+	if len(key) < 1 {
+		return ErrEmptyKey
+	}
+
+	if bytes.HasPrefix(key, internalPrefix) {
+		return ErrInternalKey
+	}
+
+	return nil
+}
+
+// keyLock returns the lock held when writing a key
+func (db *DB) keyLock(key []byte) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write(key)
+
+	return &db.keys[h.Sum32()%keyLocks]
+}
+
+// openTable opens a data file, logging its events to the database's logger
+func (db *DB) openTable(path string) (*table.Table, error) {
+	t, err := table.New(path)
 	if err != nil {
 		return nil, err
 	}
 
-	h := header.Deserialize(data[:header.HeaderSize])
+	t.SetLogger(db.logger)
+	t.SetMetrics(db.metrics)
 
-	return data[h.DataOffset():], nil
+	return t, nil
 }
 
-// Set set value by key
-func (db *DB) Set(key, value []byte) error {
-	var h header.Header
+// record serializes a header, key and value
+// to a record and calculates its checksum
+func record(h *header.Header, key, value []byte) []byte {
 	h.SetKeySize(int64(len(key)))
 	h.SetDataSize(int64(len(value)))
 
 	data := make([]byte, h.TotalSize())
-	copy(data[0:], header.Serialize(&h))
+	copy(data[0:], header.Serialize(h))
 	copy(data[header.HeaderSize:], key)
 	copy(data[h.DataOffset():], value)
 
-	off, err := db.data.Write(data)
-	if err != nil {
-		return err
+	header.Seal(data)
+
+	return data
+}
+
+func (db *DB) lookup(key []byte) *entry {
+	if len(key) < 1 {
+		return nil
 	}
 
-	db.index.MustInsert(key, &entry{
-		size:   h.TotalSize(),
-		offset: off,
-	})
+	e, ok := db.index.Lookup(key).(*entry)
+	if !ok {
+		return nil
+	}
 
-	return nil
+	return e
 }
 
-// Gets get a value by string key
-func (db *DB) Gets(key string) ([]byte, error) {
-	return db.Get([]byte(key))
+// setEntry replaces the index entry of a key, or removes it if the entry is
+// nil, updating the counts of keys. db.mu must be held exclusively, or the
+// key's lock must be held
+func (db *DB) setEntry(key []byte, e *entry) {
+	db.count(db.lookup(key), -1)
+
+	if e == nil {
+		// the entry must not be stored as a typed nil
+		db.index.MustInsert(key, nil)
+		return
+	}
+
+	db.index.MustInsert(key, e)
+	db.count(e, 1)
 }
 
-// Sets set a value by string key
-func (db *DB) Sets(key string, value []byte) error {
-	return db.Set([]byte(key), value)
+// count adds an entry to the counts of keys, or removes it if n is negative
+func (db *DB) count(e *entry, n int64) {
+	if e == nil {
+		return
+	}
+
+	if e.tombstone {
+		atomic.AddInt64(&db.counts.deleted, n)
+		return
+	}
+
+	atomic.AddInt64(&db.counts.keys, n)
+	atomic.AddInt64(&db.counts.live, n*e.size)
+}
+
+// live returns true if the key has not been deleted or expired
+func (e *entry) live() bool {
+	return !e.tombstone && (e.expires == 0 || e.expires > time.Now().UnixNano())
 }
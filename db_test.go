@@ -1,6 +1,7 @@
 package lunar
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
 	"os"
@@ -15,6 +16,9 @@ func cleanup(db *DB) {
 	db.Close()
 	os.Remove("test.db")
 	os.Remove("test.db.idx")
+	os.Remove("test.db.hint")
+	os.Remove("test.db.backup")
+	os.RemoveAll("test.db.values")
 }
 
 func TestDBOpen(t *testing.T) {
@@ -62,6 +66,36 @@ func TestDBSet(t *testing.T) {
 
 	require.Nil(t, err)
 	assert.Equal(t, []byte("test-1234"), data)
+
+	// empty keys are rejected before they are written
+	pos := db.active().Position()
+
+	assert.Equal(t, ErrEmptyKey, db.Sets("", []byte("test")))
+	assert.Equal(t, ErrEmptyKey, db.SetTTL(nil, []byte("test"), time.Minute))
+
+	var b Batch
+	b.Set([]byte("test-key-2"), []byte("test"))
+	b.Set(nil, []byte("test"))
+
+	assert.Equal(t, ErrEmptyKey, db.Apply(&b))
+	assert.Equal(t, pos, db.active().Position())
+
+	// keys used internally cannot be written
+	internal := append(append([]byte{}, internalPrefix...), "test-key"...)
+
+	assert.Equal(t, ErrInternalKey, db.Set(internal, []byte("test")))
+	assert.Equal(t, ErrInternalKey, db.Delete(replicaKey))
+
+	b.Reset()
+	b.Set(internal, []byte("test"))
+
+	assert.Equal(t, ErrInternalKey, db.Apply(&b))
+	assert.Equal(t, pos, db.active().Position())
+
+	db.Close()
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
 }
 
 func TestDBGet(t *testing.T) {
@@ -103,6 +137,200 @@ func TestDBGet(t *testing.T) {
 	assert.Equal(t, []byte("test-1234"), data)
 }
 
+func TestDBDelete(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	// delete a nonexistant key
+	err = db.Deletes("test-key")
+	assert.Equal(t, ErrNotFound, err)
+
+	err = db.Sets("test-key", []byte("test"))
+	require.Nil(t, err)
+
+	err = db.Sets("test-key-2", []byte("test-2"))
+	require.Nil(t, err)
+
+	err = db.Deletes("test-key")
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key")
+	assert.Equal(t, ErrNotFound, err)
+
+	err = db.Deletes("test-key")
+	assert.Equal(t, ErrNotFound, err)
+
+	// test persistence
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Equal(t, 1, db.Stats().Keys)
+	assert.Equal(t, 1, db.Stats().Deleted)
+
+	// deleted keys are removed by compaction
+	require.Nil(t, db.Compact())
+
+	_, err = db.Gets("test-key")
+	assert.Equal(t, ErrNotFound, err)
+
+	data, err := db.Gets("test-key-2")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-2"), data)
+
+	assert.Equal(t, 0, db.Stats().Deleted)
+
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestDBTTL(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	err = db.SetTTL([]byte("test-key"), []byte("test"), time.Millisecond*100)
+	require.Nil(t, err)
+
+	err = db.Sets("test-key-2", []byte("test-2"))
+	require.Nil(t, err)
+
+	data, err := db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), data)
+
+	expires, err := db.Expires([]byte("test-key"))
+	require.Nil(t, err)
+	assert.True(t, expires.After(time.Now()))
+
+	expires, err = db.Expires([]byte("test-key-2"))
+	require.Nil(t, err)
+	assert.True(t, expires.IsZero())
+
+	// test persistence
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	_, err = db.Expires([]byte("test-key"))
+	require.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 100)
+
+	_, err = db.Gets("test-key")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = db.Expires([]byte("test-key"))
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Equal(t, 1, db.Stats().Keys)
+	assert.Equal(t, 1, db.Stats().Deleted)
+
+	// expired keys are removed by compaction
+	require.Nil(t, db.Compact())
+
+	assert.Equal(t, 0, db.Stats().Deleted)
+
+	data, err = db.Gets("test-key-2")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-2"), data)
+}
+
+func TestDBScan(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for _, k := range []string{"b-2", "a-1", "b-1", "c-1", "b-3", "b"} {
+		err = db.Sets(k, []byte("value-"+k))
+		require.Nil(t, err)
+	}
+
+	err = db.Deletes("b-3")
+	require.Nil(t, err)
+
+	var keys []string
+
+	err = db.Scan([]byte("b"), func(key, value []byte) error {
+		assert.Equal(t, "value-"+string(key), string(value))
+		keys = append(keys, string(key))
+		return nil
+	})
+
+	require.Nil(t, err)
+	assert.Equal(t, []string{"b", "b-1", "b-2"}, keys)
+
+	keys = nil
+
+	err = db.Scan(nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		if len(keys) == 2 {
+			return ErrNotFound
+		}
+		return nil
+	})
+
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, []string{"a-1", "b"}, keys)
+
+	var records int
+
+	err = db.Walk(func(r *Record) error {
+		records++
+		return nil
+	})
+
+	require.Nil(t, err)
+	assert.Equal(t, 7, records)
+}
+
+func TestDBWalkConcurrentGrowth(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	done := grow(t, db)
+	value := bytes.Repeat([]byte("v"), 1<<18)
+
+	for walks := 0; ; walks++ {
+		select {
+		case <-done:
+			if walks > 0 {
+				return
+			}
+		default:
+		}
+
+		err = db.Walk(func(r *Record) error {
+			// allow the data file to be remapped while the record is held
+			time.Sleep(time.Millisecond)
+
+			if !bytes.Equal(value, r.Value) {
+				return fmt.Errorf("incomplete record %q", r.Key)
+			}
+
+			return nil
+		})
+
+		require.Nil(t, err)
+	}
+}
+
 func TestPersistence(t *testing.T) {
 	db, err := Open("test.db")
 	defer cleanup(db)
@@ -119,7 +347,7 @@ func TestPersistence(t *testing.T) {
 	err = db.Sets("test-key-2", []byte("test-2"))
 	require.Nil(t, err)
 
-	pos := db.data.Position()
+	pos := db.active().Position()
 
 	db.Close()
 
@@ -128,7 +356,7 @@ func TestPersistence(t *testing.T) {
 	db, err = Open("test.db")
 	require.Nil(t, err)
 
-	assert.Equal(t, pos, db.data.Position())
+	assert.Equal(t, pos, db.active().Position())
 
 	data, err := db.Gets("test-key")
 	require.Nil(t, err)
@@ -144,7 +372,7 @@ func TestPersistence(t *testing.T) {
 	db, err = Open("test.db", Compact(true))
 	require.Nil(t, err)
 
-	fmt.Println(db.data.Position())
+	fmt.Println(db.active().Position())
 
 	data, err = db.Gets("test-key")
 	require.Nil(t, err)
@@ -155,6 +383,154 @@ func TestPersistence(t *testing.T) {
 	assert.Equal(t, []byte("test-2"), data)
 }
 
+func TestSegments(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	db, err := Open("test-segments", SegmentSize(1<<12))
+	require.Nil(t, err)
+
+	value := make([]byte, 1000)
+
+	for i := 0; i < 20; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i%5), value)
+		require.Nil(t, err)
+	}
+
+	ids, err := segmentIDs("test-segments")
+	require.Nil(t, err)
+	assert.Len(t, ids, 7)
+
+	for _, id := range ids {
+		assert.True(t, db.table(id).Position() <= 1<<12)
+	}
+
+	require.Nil(t, db.Close())
+
+	// test reopen
+	db, err = Open("test-segments", SegmentSize(1<<12))
+	require.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		data, err := db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Len(t, data, 1000)
+	}
+
+	// active segment cannot be compacted
+	assert.Equal(t, ErrSegmentActive, db.CompactSegment(ids[len(ids)-1]))
+
+	// tombstones must be kept while older segments exist
+	err = db.Sets("test-key-5", value)
+	require.Nil(t, err)
+
+	err = db.Deletes("test-key-5")
+	require.Nil(t, err)
+
+	s := db.current()
+	require.Nil(t, db.CompactSegment(s.active-1))
+	require.Nil(t, db.Close())
+
+	db, err = Open("test-segments", SegmentSize(1<<12))
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key-5")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.Nil(t, db.Compact())
+
+	ids, err = segmentIDs("test-segments")
+	require.Nil(t, err)
+	assert.Len(t, ids, 2)
+
+	_, err = db.Gets("test-key-5")
+	assert.Equal(t, ErrNotFound, err)
+
+	for _, id := range ids {
+		assert.True(t, exists(db.hintPath(id)))
+	}
+
+	for i := 0; i < 5; i++ {
+		data, err := db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Len(t, data, 1000)
+	}
+
+	require.Nil(t, db.Close())
+}
+
+func TestCompact(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Sets("test-key", []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	err = db.Sets("test-key-2", []byte("test"))
+	require.Nil(t, err)
+
+	pos := db.active().Position()
+
+	require.Nil(t, db.Compact())
+	assert.True(t, db.active().Position() < pos)
+
+	data, err := db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-9"), data)
+
+	data, err = db.Gets("test-key-2")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), data)
+}
+
+func TestHints(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i%10), []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, db.Compact())
+	assert.True(t, exists("test.db.hint"))
+
+	// records written after the hint file
+	err = db.Sets("test-key-0", []byte("test-1234"))
+	require.Nil(t, err)
+
+	err = db.Sets("test-key-10", []byte("test-4567"))
+	require.Nil(t, err)
+
+	pos := db.active().Position()
+
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	assert.Equal(t, pos, db.active().Position())
+
+	data, err := db.Gets("test-key-0")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-1234"), data)
+
+	data, err = db.Gets("test-key-10")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-4567"), data)
+
+	for i := 1; i < 10; i++ {
+		data, err = db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("test-%d", 90+i)), data)
+	}
+}
+
 func BenchmarkDBSet(b *testing.B) {
 	db, err := Open("test.db")
 	defer cleanup(db)
@@ -218,3 +594,57 @@ func BenchmarkDBGet(b *testing.B) {
 
 	time.Sleep(time.Second * 20)
 }
+
+func TestDBSync(t *testing.T) {
+	db, err := Open("test.db", ValueThreshold(16))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key", make([]byte, 32)))
+	require.Nil(t, db.Sync())
+}
+
+func TestDBCounts(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	db, err := Open("test-segments", SegmentSize(1<<12))
+	require.Nil(t, err)
+
+	check := func() {
+		stats := db.Stats()
+		counts := db.Counts()
+
+		assert.Equal(t, stats, counts)
+	}
+
+	for i := 0; i < 50; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("test-key-%d", i%20), make([]byte, 100)))
+	}
+
+	require.Nil(t, db.Deletes("test-key-1"))
+	require.Nil(t, db.Bucket("test").Set([]byte("test-key"), []byte("test")))
+
+	var b Batch
+
+	b.Set([]byte("test-key-2"), []byte("test"))
+	b.Delete([]byte("test-key-3"))
+
+	require.Nil(t, db.Apply(&b))
+	check()
+
+	require.Nil(t, db.DropBucket("test"))
+	check()
+
+	require.Nil(t, db.Compact())
+	check()
+
+	require.Nil(t, db.Close())
+
+	db, err = Open("test-segments", SegmentSize(1<<12))
+	require.Nil(t, err)
+
+	defer db.Close()
+
+	check()
+}
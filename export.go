@@ -0,0 +1,296 @@
+package lunar
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Format the format of exported data
+type Format int
+
+const (
+	// JSONLines one JSON object per line, with keys and values as UTF-8 strings
+	JSONLines Format = iota
+	// JSONLinesBase64 one JSON object per line, with keys and values base64 encoded
+	JSONLinesBase64
+	// CSV two column comma separated values, with keys and values as UTF-8 strings
+	CSV
+)
+
+const (
+	// importBatchSize the number of keys written by each batch during an import
+	importBatchSize = 1000
+)
+
+var (
+	// ErrInvalidFormat the format is not supported
+	ErrInvalidFormat = errors.New("invalid format")
+	// ErrInvalidUTF8 a key or value is not valid UTF-8 and cannot be exported in the requested format
+	ErrInvalidUTF8 = errors.New("key or value is not valid UTF-8")
+)
+
+type jsonLine struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Bucket string `json:"bucket,omitempty"`
+}
+
+// ParseFormat returns the format with the given name,
+// one of "jsonl", "jsonl-base64" or "csv"
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "jsonl":
+		return JSONLines, nil
+	case "jsonl-base64":
+		return JSONLinesBase64, nil
+	case "csv":
+		return CSV, nil
+	}
+
+	return 0, ErrInvalidFormat
+}
+
+// Export writes every key and value to a writer in key order, followed by
+// the keys and values of each bucket, which include the bucket's name
+func (db *DB) Export(w io.Writer, format Format) error {
+	bw := bufio.NewWriter(w)
+
+	var err error
+
+	switch format {
+	case JSONLines, JSONLinesBase64:
+		enc := json.NewEncoder(bw)
+
+		err = db.export(func(bucket string, key, value []byte) error {
+			line, err := encodeLine(bucket, key, value, format)
+			if err != nil {
+				return err
+			}
+
+			return enc.Encode(line)
+		})
+	case CSV:
+		cw := csv.NewWriter(bw)
+
+		err = db.export(func(bucket string, key, value []byte) error {
+			if !utf8.Valid(key) || !utf8.Valid(value) || !utf8.ValidString(bucket) {
+				return fmt.Errorf("%w: %q", ErrInvalidUTF8, key)
+			}
+
+			row := []string{string(key), string(value)}
+
+			// keys stored in buckets have a third column with the bucket's name
+			if bucket != "" {
+				row = append(row, bucket)
+			}
+
+			return cw.Write(row)
+		})
+
+		if err == nil {
+			cw.Flush()
+			err = cw.Error()
+		}
+	default:
+		return ErrInvalidFormat
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// export calls fn for every key and value, followed by those of each bucket
+func (db *DB) export(fn func(bucket string, key, value []byte) error) error {
+	err := db.Scan(nil, func(key, value []byte) error {
+		return fn("", key, value)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range db.Buckets() {
+		err = db.Bucket(name).Scan(nil, func(key, value []byte) error {
+			return fn(name, key, value)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Import reads keys and values from a reader and writes them to the
+// database in batches. Keys exported from a bucket are written to it
+func (db *DB) Import(r io.Reader, format Format) error {
+	var b Batch
+	var bucket string
+
+	apply := func() error {
+		var err error
+
+		if bucket == "" {
+			err = db.Apply(&b)
+		} else {
+			err = db.Bucket(bucket).Apply(&b)
+		}
+
+		b.Reset()
+
+		return err
+	}
+
+	add := func(name string, key, value []byte) error {
+		if len(key) < 1 {
+			return errors.New("import contains an empty key")
+		}
+
+		// each batch only writes to a single bucket
+		if name != bucket {
+			err := apply()
+			if err != nil {
+				return err
+			}
+
+			bucket = name
+		}
+
+		b.Set(key, value)
+
+		if b.Len() < importBatchSize {
+			return nil
+		}
+
+		return apply()
+	}
+
+	var err error
+
+	switch format {
+	case JSONLines, JSONLinesBase64:
+		err = importLines(r, format, add)
+	case CSV:
+		err = importCSV(r, add)
+	default:
+		return ErrInvalidFormat
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return apply()
+}
+
+func encodeLine(bucket string, key, value []byte, format Format) (*jsonLine, error) {
+	if format == JSONLinesBase64 {
+		line := jsonLine{
+			Key:   base64.StdEncoding.EncodeToString(key),
+			Value: base64.StdEncoding.EncodeToString(value),
+		}
+
+		if bucket != "" {
+			line.Bucket = base64.StdEncoding.EncodeToString([]byte(bucket))
+		}
+
+		return &line, nil
+	}
+
+	if !utf8.Valid(key) || !utf8.Valid(value) || !utf8.ValidString(bucket) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidUTF8, key)
+	}
+
+	return &jsonLine{
+		Key:    string(key),
+		Value:  string(value),
+		Bucket: bucket,
+	}, nil
+}
+
+func importLines(r io.Reader, format Format, fn func(bucket string, key, value []byte) error) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	for line := 1; ; line++ {
+		var l jsonLine
+
+		err := dec.Decode(&l)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		bucket, key, value := l.Bucket, []byte(l.Key), []byte(l.Value)
+
+		if format == JSONLinesBase64 {
+			name, err := base64.StdEncoding.DecodeString(l.Bucket)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+
+			bucket = string(name)
+
+			key, err = base64.StdEncoding.DecodeString(l.Key)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+
+			value, err = base64.StdEncoding.DecodeString(l.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+		err = fn(bucket, key, value)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func importCSV(r io.Reader, fn func(bucket string, key, value []byte) error) error {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// keys stored in buckets have a third column with the bucket's name
+		var bucket string
+
+		switch len(row) {
+		case 2:
+		case 3:
+			bucket = row[2]
+		default:
+			line, _ := cr.FieldPos(0)
+			return &csv.ParseError{StartLine: line, Line: line, Err: csv.ErrFieldCount}
+		}
+
+		err = fn(bucket, []byte(row[0]), []byte(row[1]))
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package lunar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 2500; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%04d", i), []byte(fmt.Sprintf("test, \"%d\"\n", i)))
+		require.Nil(t, err)
+	}
+
+	err = db.Deletes("test-key-0000")
+	require.Nil(t, err)
+
+	formats := []Format{JSONLines, JSONLinesBase64, CSV}
+
+	for _, format := range formats {
+		var buf bytes.Buffer
+
+		err = db.Export(&buf, format)
+		require.Nil(t, err)
+
+		idb, err := Open("test-import.db")
+		require.Nil(t, err)
+
+		err = idb.Import(&buf, format)
+		require.Nil(t, err)
+
+		assert.Equal(t, 2499, idb.Stats().Keys)
+
+		_, err = idb.Gets("test-key-0000")
+		assert.Equal(t, ErrNotFound, err)
+
+		value, err := idb.Gets("test-key-2499")
+		require.Nil(t, err)
+		assert.Equal(t, []byte("test, \"2499\"\n"), value)
+
+		idb.Close()
+		os.Remove("test-import.db")
+		os.Remove("test-import.db.hint")
+	}
+
+	var buf bytes.Buffer
+
+	err = db.Export(&buf, JSONLines)
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), `{"key":"test-key-0001","value":"test, \"1\"\n"}`+"\n"))
+
+	// invalid utf-8 can only be exported as base64
+	err = db.Sets("test-binary", []byte{0xff, 0xfe})
+	require.Nil(t, err)
+
+	err = db.Export(&bytes.Buffer{}, JSONLines)
+	assert.True(t, errors.Is(err, ErrInvalidUTF8))
+
+	err = db.Export(&bytes.Buffer{}, CSV)
+	assert.True(t, errors.Is(err, ErrInvalidUTF8))
+
+	err = db.Export(&bytes.Buffer{}, JSONLinesBase64)
+	assert.Nil(t, err)
+
+	err = db.Export(&bytes.Buffer{}, Format(10))
+	assert.Equal(t, ErrInvalidFormat, err)
+
+	err = db.Import(strings.NewReader(`{"key":"test"`), JSONLines)
+	assert.NotNil(t, err)
+}
+
+func TestExportImportBuckets(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key", []byte("test")))
+	require.Nil(t, db.Bucket("a").Sets("test-key", []byte("test-a")))
+	require.Nil(t, db.Bucket("b").Sets("test-key-b", []byte("test-b")))
+
+	for _, format := range []Format{JSONLines, JSONLinesBase64, CSV} {
+		var buf bytes.Buffer
+
+		require.Nil(t, db.Export(&buf, format))
+
+		idb, err := Open("test-import.db")
+		require.Nil(t, err)
+
+		require.Nil(t, idb.Import(&buf, format))
+
+		value, err := idb.Gets("test-key")
+		require.Nil(t, err)
+		assert.Equal(t, []byte("test"), value)
+
+		value, err = idb.Bucket("a").Gets("test-key")
+		require.Nil(t, err)
+		assert.Equal(t, []byte("test-a"), value)
+
+		value, err = idb.Bucket("b").Gets("test-key-b")
+		require.Nil(t, err)
+		assert.Equal(t, []byte("test-b"), value)
+
+		assert.Equal(t, []string{"a", "b"}, idb.Buckets())
+
+		idb.Close()
+		os.Remove("test-import.db")
+		os.Remove("test-import.db.hint")
+	}
+
+	var buf bytes.Buffer
+
+	require.Nil(t, db.Export(&buf, CSV))
+	assert.Equal(t, "test-key,test\ntest-key,test-a,a\ntest-key-b,test-b,b\n", buf.String())
+}
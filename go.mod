@@ -1,9 +1,17 @@
 module github.com/purehyperbole/lunar
 
-go 1.13
+go 1.18
 
 require (
+	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
+	github.com/gomodule/redigo v1.7.0
 	github.com/google/uuid v1.1.1
 	github.com/purehyperbole/rad v1.0.0
 	github.com/stretchr/testify v1.4.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
@@ -2,13 +2,31 @@ package header
 
 import (
 	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
 	"strings"
 	"unsafe"
 )
 
 const (
 	// HeaderSize the allocated size of the header
-	HeaderSize = 48
+	HeaderSize = 60
+	// ChecksumOffset the offset of the checksum within the header
+	ChecksumOffset = 56
+	// FlagPointer the record's data points to a value stored outside of the data file
+	FlagPointer uint8 = 1 << 0
+	// FlagMerge the record's data is a merge operand, which is applied to the previous version's data
+	FlagMerge uint8 = 1 << 1
+	// FormatVersion the version of the record format, which is stored in every record.
+	// Records written before the format was versioned have a version of zero
+	FormatVersion uint8 = 1
+
+	// flags and the format version are stored in the most significant byte of the key size
+	flagShift    = 56
+	ksizeMask    = 1<<flagShift - 1
+	flagMask     = 0x0f
+	versionShift = 4
 )
 
 // Header data header stores
@@ -20,6 +38,10 @@ type Header struct {
 	poffset int64  // offset of the previous version of this data
 	size    int64  // size of current data
 	ksize   int64  // size of the current key
+	expires int64  // unix time in nanoseconds that the data expires, zero if it does not expire
+	crc     uint32 // checksum of the header, key and data
+	flags   uint8  // flags describing the record's data
+	version uint8  // version of the record format
 }
 
 // Xmin returns the transaction if of the node that created the data
@@ -52,6 +74,36 @@ func (h *Header) DataOffset() int64 {
 	return HeaderSize + h.ksize
 }
 
+// Expires returns the unix time in nanoseconds that the data expires, or zero
+func (h *Header) Expires() int64 {
+	return h.expires
+}
+
+// Checksum returns the checksum of the header, key and data
+func (h *Header) Checksum() uint32 {
+	return h.crc
+}
+
+// Flags returns the flags describing the record's data
+func (h *Header) Flags() uint8 {
+	return h.flags
+}
+
+// Version returns the version of the format the record was written with
+func (h *Header) Version() uint8 {
+	return h.version
+}
+
+// Pointer returns true if the record's data points to a value stored outside of the data file
+func (h *Header) Pointer() bool {
+	return h.flags&FlagPointer > 0
+}
+
+// Merge returns true if the record's data is a merge operand
+func (h *Header) Merge() bool {
+	return h.flags&FlagMerge > 0
+}
+
 // Previous returns the size and offset of the previous version's data
 func (h *Header) Previous() (int64, int64) {
 	return h.psize, h.poffset
@@ -88,9 +140,24 @@ func (h *Header) SetKeySize(size int64) {
 	h.ksize = size
 }
 
-// Serialize serialize a node to a byteslice
+// SetExpires sets the unix time in nanoseconds that the data expires
+func (h *Header) SetExpires(expires int64) {
+	h.expires = expires
+}
+
+// SetFlags sets the flags describing the record's data
+func (h *Header) SetFlags(flags uint8) {
+	h.flags = flags
+}
+
+// SetChecksum sets the checksum of the header, key and data
+func (h *Header) SetChecksum(crc uint32) {
+	h.crc = crc
+}
+
+// Serialize serialize a node to a byteslice, using the current format version
 func Serialize(h *Header) []byte {
-	data := make([]byte, 48)
+	data := make([]byte, HeaderSize)
 
 	xmin := *(*[8]byte)(unsafe.Pointer(&h.xmin))
 	copy(data[0:], xmin[:])
@@ -109,20 +176,56 @@ func Serialize(h *Header) []byte {
 
 	ksize := *(*[8]byte)(unsafe.Pointer(&h.ksize))
 	copy(data[40:], ksize[:])
+	data[47] = h.flags&flagMask | FormatVersion<<versionShift
+
+	expires := *(*[8]byte)(unsafe.Pointer(&h.expires))
+	copy(data[48:], expires[:])
+
+	crc := *(*[4]byte)(unsafe.Pointer(&h.crc))
+	copy(data[56:], crc[:])
 
 	return data
 }
 
 // Deserialize deserialize from a byteslice to a Node
 func Deserialize(data []byte) *Header {
+	ksize := *(*int64)(unsafe.Pointer(&data[40]))
+
 	return &Header{
 		xmin:    *(*uint64)(unsafe.Pointer(&data[0])),
 		xmax:    *(*uint64)(unsafe.Pointer(&data[8])),
 		psize:   *(*int64)(unsafe.Pointer(&data[16])),
 		poffset: *(*int64)(unsafe.Pointer(&data[24])),
 		size:    *(*int64)(unsafe.Pointer(&data[32])),
-		ksize:   *(*int64)(unsafe.Pointer(&data[40])),
+		ksize:   ksize & ksizeMask,
+		expires: *(*int64)(unsafe.Pointer(&data[48])),
+		crc:     *(*uint32)(unsafe.Pointer(&data[56])),
+		flags:   uint8(uint64(ksize)>>flagShift) & flagMask,
+		version: uint8(uint64(ksize) >> (flagShift + versionShift)),
+	}
+}
+
+// Checksum calculates the checksum of a record, which
+// consists of a serialized header, followed by its key and data.
+// the header's own checksum field is excluded
+func Checksum(record []byte) uint32 {
+	crc := crc32.ChecksumIEEE(record[:ChecksumOffset])
+	return crc32.Update(crc, crc32.IEEETable, record[HeaderSize:])
+}
+
+// Seal calculates and sets the checksum of a record
+func Seal(record []byte) {
+	crc := Checksum(record)
+	copy(record[ChecksumOffset:], (*[4]byte)(unsafe.Pointer(&crc))[:])
+}
+
+// Valid returns true if a record's checksum matches its contents
+func Valid(record []byte) bool {
+	if len(record) < HeaderSize {
+		return false
 	}
+
+	return *(*uint32)(unsafe.Pointer(&record[ChecksumOffset])) == Checksum(record)
 }
 
 // Prepend prepends header information to data
@@ -136,14 +239,25 @@ func Prepend(h *Header, data []byte) []byte {
 
 // Print prints header information to stdout
 func Print(h *Header) {
+	Fprint(os.Stdout, h)
+}
+
+// Fprint writes header information to a writer
+func Fprint(w io.Writer, h *Header) {
 	output := []string{"{"}
 
 	output = append(output, fmt.Sprintf("	Xmin: %d", h.xmin))
 	output = append(output, fmt.Sprintf("	Xmax: %d", h.xmax))
 	output = append(output, fmt.Sprintf("	Previous Version Size: %d", h.psize))
 	output = append(output, fmt.Sprintf("	Previous Version Offset: %d", h.poffset))
+	output = append(output, fmt.Sprintf("	Key Size: %d", h.ksize))
+	output = append(output, fmt.Sprintf("	Data Size: %d", h.size))
+	output = append(output, fmt.Sprintf("	Expires: %d", h.expires))
+	output = append(output, fmt.Sprintf("	Flags: %02x", h.flags))
+	output = append(output, fmt.Sprintf("	Version: %d", h.version))
+	output = append(output, fmt.Sprintf("	Checksum: %08x", h.crc))
 
 	output = append(output, "}")
 
-	fmt.Println(strings.Join(output, "\n"))
+	fmt.Fprintln(w, strings.Join(output, "\n"))
 }
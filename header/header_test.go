@@ -8,7 +8,7 @@ import (
 )
 
 func testBuildBytes() []byte {
-	data := make([]byte, 48)
+	data := make([]byte, HeaderSize)
 
 	var scratch []byte
 	xmin := uint64(2)
@@ -17,12 +17,16 @@ func testBuildBytes() []byte {
 	poffset := int64(4096)
 	size := int64(2048)
 	ksize := int64(8)
+	expires := int64(1000)
+	crc := uint32(1234)
 	scratch = append(scratch, (*[8]byte)(unsafe.Pointer(&xmin))[:]...)
 	scratch = append(scratch, (*[8]byte)(unsafe.Pointer(&xmax))[:]...)
 	scratch = append(scratch, (*[8]byte)(unsafe.Pointer(&psize))[:]...)
 	scratch = append(scratch, (*[8]byte)(unsafe.Pointer(&poffset))[:]...)
 	scratch = append(scratch, (*[8]byte)(unsafe.Pointer(&size))[:]...)
 	scratch = append(scratch, (*[8]byte)(unsafe.Pointer(&ksize))[:]...)
+	scratch = append(scratch, (*[8]byte)(unsafe.Pointer(&expires))[:]...)
+	scratch = append(scratch, (*[4]byte)(unsafe.Pointer(&crc))[:]...)
 
 	copy(data[0:], scratch[:])
 
@@ -37,17 +41,22 @@ func TestSerialize(t *testing.T) {
 		poffset: 4096,
 		size:    2048,
 		ksize:   8,
+		expires: 1000,
+		crc:     1234,
 	}
 
 	data := Serialize(&hdr)
 
-	assert.Len(t, data, 48)
+	assert.Len(t, data, HeaderSize)
 	assert.Equal(t, uint64(0), *(*uint64)(unsafe.Pointer(&data[0])))
 	assert.Equal(t, uint64(5), *(*uint64)(unsafe.Pointer(&data[8])))
 	assert.Equal(t, int64(4096), *(*int64)(unsafe.Pointer(&data[16])))
 	assert.Equal(t, int64(4096), *(*int64)(unsafe.Pointer(&data[24])))
 	assert.Equal(t, int64(2048), *(*int64)(unsafe.Pointer(&data[32])))
-	assert.Equal(t, int64(8), *(*int64)(unsafe.Pointer(&data[40])))
+	assert.Equal(t, int64(8), *(*int64)(unsafe.Pointer(&data[40]))&ksizeMask)
+	assert.Equal(t, FormatVersion<<versionShift, data[47])
+	assert.Equal(t, int64(1000), *(*int64)(unsafe.Pointer(&data[48])))
+	assert.Equal(t, uint32(1234), *(*uint32)(unsafe.Pointer(&data[56])))
 }
 
 func TestDeserialize(t *testing.T) {
@@ -61,4 +70,59 @@ func TestDeserialize(t *testing.T) {
 	assert.Equal(t, uint64(15), hdr.Xmax())
 	assert.Equal(t, int64(8192), sz)
 	assert.Equal(t, int64(4096), off)
+	assert.Equal(t, int64(1000), hdr.Expires())
+	assert.Equal(t, uint32(1234), hdr.Checksum())
+}
+
+func TestFlags(t *testing.T) {
+	var hdr Header
+	hdr.SetKeySize(8)
+	hdr.SetFlags(FlagPointer)
+
+	data := Serialize(&hdr)
+
+	assert.Equal(t, int64(8), *(*int64)(unsafe.Pointer(&data[40]))&ksizeMask)
+
+	h := Deserialize(data)
+
+	assert.Equal(t, int64(8), h.KeySize())
+	assert.True(t, h.Pointer())
+
+	hdr.SetFlags(0)
+
+	assert.False(t, Deserialize(Serialize(&hdr)).Pointer())
+}
+
+func TestVersion(t *testing.T) {
+	var hdr Header
+	hdr.SetKeySize(8)
+	hdr.SetFlags(FlagMerge)
+
+	h := Deserialize(Serialize(&hdr))
+
+	assert.Equal(t, FormatVersion, h.Version())
+	assert.Equal(t, FlagMerge, h.Flags())
+	assert.Equal(t, int64(8), h.KeySize())
+
+	// records written before the format was versioned
+	assert.Equal(t, uint8(0), Deserialize(testBuildBytes()).Version())
+}
+
+func TestChecksum(t *testing.T) {
+	var hdr Header
+	hdr.SetKeySize(4)
+	hdr.SetDataSize(4)
+
+	record := append(Serialize(&hdr), []byte("key1data")...)
+
+	assert.False(t, Valid(record))
+
+	Seal(record)
+
+	assert.True(t, Valid(record))
+	assert.Equal(t, Checksum(record), Deserialize(record).Checksum())
+
+	record[HeaderSize] = 'x'
+
+	assert.False(t, Valid(record))
 }
@@ -0,0 +1,200 @@
+package hint
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// EntrySize the size of an entry, excluding its key
+	EntrySize = 32
+	// TrailerSize the size of the trailer at the end of the hint file
+	TrailerSize = 12
+)
+
+const (
+	// FlagXmax the record has been marked as updated or deleted
+	FlagXmax uint32 = 1 << iota
+)
+
+var (
+	// ErrCorrupt the hint file is incomplete or its checksum does not match
+	ErrCorrupt = errors.New("hint file is corrupt")
+)
+
+// Entry describes the location of a record in a data file
+type Entry struct {
+	Key     []byte
+	Offset  int64
+	Size    int64
+	Expires int64
+	Flags   uint32
+}
+
+// Writer writes entries to a hint file
+type Writer struct {
+	path string
+	fd   *os.File
+	buf  *bufio.Writer
+	crc  hash.Hash32
+}
+
+// Create creates a new hint file. The hint file is written to a
+// temporary file and will only replace any existing hint file on Close
+func Create(path string) (*Writer, error) {
+	fd, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := Writer{
+		path: path,
+		fd:   fd,
+		crc:  crc32.NewIEEE(),
+	}
+
+	w.buf = bufio.NewWriter(fd)
+
+	return &w, nil
+}
+
+// Add adds an entry to the hint file
+func (w *Writer) Add(e *Entry) error {
+	data := make([]byte, EntrySize+len(e.Key))
+
+	binary.LittleEndian.PutUint32(data[0:], e.Flags)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(e.Key)))
+	binary.LittleEndian.PutUint64(data[8:], uint64(e.Offset))
+	binary.LittleEndian.PutUint64(data[16:], uint64(e.Size))
+	binary.LittleEndian.PutUint64(data[24:], uint64(e.Expires))
+	copy(data[EntrySize:], e.Key)
+
+	return w.write(data)
+}
+
+// Close completes the hint file, recording the position of the data file it covers
+func (w *Writer) Close(position int64) error {
+	trailer := make([]byte, TrailerSize)
+	binary.LittleEndian.PutUint64(trailer[0:], uint64(position))
+
+	err := w.write(trailer[:8])
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(trailer[8:], w.crc.Sum32())
+
+	_, err = w.buf.Write(trailer[8:])
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	err = w.buf.Flush()
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	err = w.fd.Sync()
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	err = w.fd.Close()
+	if err != nil {
+		os.Remove(w.fd.Name())
+		return err
+	}
+
+	return os.Rename(w.fd.Name(), w.path)
+}
+
+// Abort discards the hint file
+func (w *Writer) Abort() {
+	w.fd.Close()
+	os.Remove(w.fd.Name())
+}
+
+func (w *Writer) write(data []byte) error {
+	w.crc.Write(data)
+	_, err := w.buf.Write(data)
+	return err
+}
+
+// File a verified hint file
+type File struct {
+	data     []byte
+	position int64
+}
+
+// Open reads a hint file and verifies its checksum
+func Open(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < TrailerSize {
+		return nil, ErrCorrupt
+	}
+
+	body := data[:len(data)-4]
+
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, ErrCorrupt
+	}
+
+	return &File{
+		data:     data[:len(data)-TrailerSize],
+		position: int64(binary.LittleEndian.Uint64(data[len(data)-TrailerSize:])),
+	}, nil
+}
+
+// Position returns the position of the data file that the hint file covers
+func (f *File) Position() int64 {
+	return f.position
+}
+
+// Iterate calls fn for every entry in the hint file, in the order they were added
+func (f *File) Iterate(fn func(e *Entry) error) error {
+	var pos int
+
+	for pos < len(f.data) {
+		if pos+EntrySize > len(f.data) {
+			return ErrCorrupt
+		}
+
+		ksize := int(binary.LittleEndian.Uint32(f.data[pos+4:]))
+
+		if pos+EntrySize+ksize > len(f.data) {
+			return ErrCorrupt
+		}
+
+		e := Entry{
+			Key:     make([]byte, ksize),
+			Flags:   binary.LittleEndian.Uint32(f.data[pos:]),
+			Offset:  int64(binary.LittleEndian.Uint64(f.data[pos+8:])),
+			Size:    int64(binary.LittleEndian.Uint64(f.data[pos+16:])),
+			Expires: int64(binary.LittleEndian.Uint64(f.data[pos+24:])),
+		}
+
+		copy(e.Key, f.data[pos+EntrySize:])
+
+		err := fn(&e)
+		if err != nil {
+			return err
+		}
+
+		pos = pos + EntrySize + ksize
+	}
+
+	return nil
+}
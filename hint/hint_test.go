@@ -0,0 +1,73 @@
+package hint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRead(t *testing.T) {
+	defer os.Remove("test.hint")
+
+	w, err := Create("test.hint")
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = w.Add(&Entry{
+			Key:     []byte(fmt.Sprintf("test-key-%d", i)),
+			Offset:  int64(i * 100),
+			Size:    100,
+			Expires: int64(i),
+		})
+		require.Nil(t, err)
+	}
+
+	err = w.Close(1000)
+	require.Nil(t, err)
+
+	f, err := Open("test.hint")
+	require.Nil(t, err)
+	assert.Equal(t, int64(1000), f.Position())
+
+	var i int
+
+	err = f.Iterate(func(e *Entry) error {
+		assert.Equal(t, []byte(fmt.Sprintf("test-key-%d", i)), e.Key)
+		assert.Equal(t, int64(i*100), e.Offset)
+		assert.Equal(t, int64(100), e.Size)
+		assert.Equal(t, int64(i), e.Expires)
+		i++
+		return nil
+	})
+
+	require.Nil(t, err)
+	assert.Equal(t, 10, i)
+}
+
+func TestCorrupt(t *testing.T) {
+	defer os.Remove("test.hint")
+
+	w, err := Create("test.hint")
+	require.Nil(t, err)
+
+	err = w.Add(&Entry{Key: []byte("test-key"), Offset: 0, Size: 100})
+	require.Nil(t, err)
+
+	err = w.Close(100)
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadFile("test.hint")
+	require.Nil(t, err)
+
+	data[10] = data[10] + 1
+
+	err = ioutil.WriteFile("test.hint", data, 0644)
+	require.Nil(t, err)
+
+	_, err = Open("test.hint")
+	assert.Equal(t, ErrCorrupt, err)
+}
@@ -0,0 +1,153 @@
+package lunar
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/hint"
+	"github.com/purehyperbole/lunar/table"
+)
+
+const (
+	hintExt = ".hint"
+)
+
+// dataPath returns the path of a segment's data file
+func (db *DB) dataPath(id uint32) string {
+	if !db.segmented() {
+		return db.path
+	}
+
+	return segmentPath(db.path, id)
+}
+
+// hintPath returns the path of a segment's hint file
+func (db *DB) hintPath(id uint32) string {
+	if !db.segmented() {
+		return db.path + hintExt
+	}
+
+	return filepath.Join(db.path, fmt.Sprintf("%08d%s", id, hintExt))
+}
+
+// writeHint writes a hint file containing the
+// location of every record in a segment
+func (db *DB) writeHint(id uint32, t *table.Table) error {
+	w, err := hint.Create(db.hintPath(id))
+	if err != nil {
+		return err
+	}
+
+	var end int64
+
+	err = scan(t, func(h *header.Header, key []byte, offset int64) error {
+		end = offset + h.TotalSize()
+
+		var flags uint32
+
+		if h.Xmax() > 0 {
+			flags = flags | hint.FlagXmax
+		}
+
+		return w.Add(&hint.Entry{
+			Key:     key,
+			Offset:  offset,
+			Size:    h.TotalSize(),
+			Expires: h.Expires(),
+			Flags:   flags,
+		})
+	})
+
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	return w.Close(end)
+}
+
+// loadHint indexes all records listed in a segment's hint file,
+// returning the position of the data file the hint file covers.
+// if there is no valid hint file, zero is returned
+func (db *DB) loadHint(id uint32, t *table.Table) int64 {
+	f, err := hint.Open(db.hintPath(id))
+	if err != nil {
+		return 0
+	}
+
+	if f.Position() > t.Size() {
+		return 0
+	}
+
+	var first, last *hint.Entry
+
+	// validate the hint file before modifying the index
+	err = f.Iterate(func(e *hint.Entry) error {
+		if len(e.Key) < 1 || e.Offset+e.Size > f.Position() {
+			return hint.ErrCorrupt
+		}
+
+		if first == nil {
+			first = e
+		}
+
+		last = e
+
+		return nil
+	})
+
+	if err != nil {
+		return 0
+	}
+
+	// check the hint file belongs to the data file
+	if !matches(t, first) || !matches(t, last) {
+		return 0
+	}
+
+	f.Iterate(func(e *hint.Entry) error {
+		db.setEntry(e.Key, &entry{
+			segment:   id,
+			size:      e.Size,
+			offset:    e.Offset,
+			tombstone: e.Flags&hint.FlagXmax > 0,
+			expires:   e.Expires,
+		})
+		return nil
+	})
+
+	return f.Position()
+}
+
+// matches checks that a hint entry describes the record at its offset
+func matches(t *table.Table, e *hint.Entry) bool {
+	if e == nil {
+		return true
+	}
+
+	data, err := t.Read(header.HeaderSize+int64(len(e.Key)), e.Offset)
+	if err != nil {
+		return false
+	}
+
+	h := header.Deserialize(data)
+
+	if h.TotalSize() != e.Size {
+		return false
+	}
+
+	return bytes.Equal(data[header.HeaderSize:], e.Key)
+}
+
+// removeHint removes a segment's hint file if it exists
+func (db *DB) removeHint(id uint32) error {
+	err := os.Remove(db.hintPath(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,155 @@
+package lunar
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/purehyperbole/lunar/header"
+)
+
+// Record a record stored in a data file
+type Record struct {
+	Segment uint32
+	Offset  int64
+	Header  *header.Header
+	Key     []byte
+	Value   []byte
+}
+
+// Stats database statistics
+type Stats struct {
+	Segments int   // number of data files
+	Keys     int   // number of keys
+	Deleted  int   // number of deleted or expired keys that have not been compacted
+	Size     int64 // total size of the data files
+	Used     int64 // total size of all records written to the data files
+	Live     int64 // total size of the current version of every key
+}
+
+// Scan calls fn for every key with the given prefix and its value, in
+// key order. Keys stored in buckets are not included. If fn returns
+// an error, iteration stops and the error is returned
+func (db *DB) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	return db.scan(prefix, func(key, value []byte) error {
+		if bytes.HasPrefix(key, internalPrefix) {
+			return nil
+		}
+
+		return fn(key, value)
+	})
+}
+
+// scan calls fn for every key with the given prefix and its value, in key order
+func (db *DB) scan(prefix []byte, fn func(key, value []byte) error) error {
+	var err error
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		if err != nil || !bytes.HasPrefix(key, prefix) {
+			return
+		}
+
+		e, ok := v.(*entry)
+		if !ok || !e.live() {
+			return
+		}
+
+		value, gerr := db.Get(key)
+		if gerr == ErrNotFound {
+			// the key was deleted during iteration
+			return
+		}
+
+		if gerr != nil {
+			err = gerr
+			return
+		}
+
+		err = fn(key, value)
+	})
+
+	return err
+}
+
+// Walk calls fn for every record in the data files, in the order they
+// were written. This includes previous versions of values and deleted
+// keys that have not been compacted. The record is only valid until fn
+// returns. Records written after Walk starts are not included, and
+// compaction will be blocked until Walk returns
+func (db *DB) Walk(fn func(r *Record) error) error {
+	db.snapshot.RLock()
+	defer db.snapshot.RUnlock()
+
+	s, ends, release := db.freeze()
+	defer release()
+
+	for _, id := range s.ids() {
+		t := s.tables[id]
+
+		_, err := scanRange(t, 0, ends[id], func(h *header.Header, data []byte, offset int64) error {
+			return fn(&Record{
+				Segment: id,
+				Offset:  offset,
+				Header:  h,
+				Key:     data[header.HeaderSize:h.DataOffset()],
+				Value:   data[h.DataOffset():],
+			})
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns statistics about the database
+func (db *DB) Stats() Stats {
+	stats := db.files()
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		e, ok := v.(*entry)
+		if !ok {
+			return
+		}
+
+		if !e.live() {
+			stats.Deleted++
+			return
+		}
+
+		stats.Keys++
+		stats.Live = stats.Live + e.size
+	})
+
+	return stats
+}
+
+// Counts returns statistics about the database from counts that are
+// kept as keys are written, without walking the index like Stats.
+// Keys that have expired are counted as keys until they are compacted
+func (db *DB) Counts() Stats {
+	stats := db.files()
+
+	stats.Keys = int(atomic.LoadInt64(&db.counts.keys))
+	stats.Deleted = int(atomic.LoadInt64(&db.counts.deleted))
+	stats.Live = atomic.LoadInt64(&db.counts.live)
+
+	return stats
+}
+
+// files returns statistics about the data files
+func (db *DB) files() Stats {
+	var stats Stats
+
+	s := db.current()
+
+	stats.Segments = len(s.tables)
+
+	for _, t := range s.tables {
+		stats.Size = stats.Size + t.Size()
+		stats.Used = stats.Used + t.Position()
+	}
+
+	return stats
+}
@@ -0,0 +1,74 @@
+package lunar
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLogger records the messages of logged events
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log(msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log(msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log(msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log(msg, args) }
+
+func (l *testLogger) log(msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// args are key value pairs
+	if len(args)%2 != 0 {
+		panic("odd number of args")
+	}
+
+	l.messages = append(l.messages, msg)
+}
+
+func (l *testLogger) logged(msg string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, m := range l.messages {
+		if m == msg {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestLog(t *testing.T) {
+	var logger testLogger
+
+	_, err := Open("test.db", Log(nil))
+	assert.NotNil(t, err)
+
+	db, err := Open("test.db", Log(&logger))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+	assert.True(t, logger.logged("opened database"))
+
+	// grow the data file past its initial mapping
+	value := make([]byte, 1<<16)
+
+	require.Nil(t, db.Sets("test-key", value))
+	require.Nil(t, db.Sets("test-key", value))
+
+	assert.True(t, logger.logged("remapped table"))
+
+	require.Nil(t, db.Compact())
+	assert.True(t, logger.logged("compacted database"))
+
+	assert.Eventually(t, func() bool {
+		return logger.logged("unmapped table")
+	}, time.Second, time.Millisecond*10)
+}
@@ -0,0 +1,176 @@
+package lunar
+
+import (
+	"errors"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+)
+
+var (
+	// ErrNoMergeOperator a merge operator has not been registered with the Merger option
+	ErrNoMergeOperator = errors.New("no merge operator")
+)
+
+// MergeOperator combines a key's value with the operands written by Merge,
+// in the order they were written. The value is nil if the key did not have
+// a value before the operands were written
+type MergeOperator func(key, value []byte, operands [][]byte) ([]byte, error)
+
+// Merge writes an operand that is combined with the key's value by the merge
+// operator when the key is read, without reading the key's current value.
+// Operands are collapsed into a single value when the key is compacted
+func (db *DB) Merge(key, operand []byte) error {
+	if db.merger == nil {
+		return ErrNoMergeOperator
+	}
+
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	var h header.Header
+	h.SetFlags(header.FlagMerge)
+
+	return db.put(key, record(&h, key, operand))
+}
+
+// link returns a merge operand record that refers to the key's current
+// record as its previous version. db.mu and the key's lock must be held,
+// or db.mu must be held exclusively
+func (db *DB) link(key, data []byte) []byte {
+	return db.linkTo(db.lookup(key), data)
+}
+
+// linkTo returns a merge operand record that refers to
+// the record of an entry as its previous version
+func (db *DB) linkTo(e *entry, data []byte) []byte {
+	h := header.Deserialize(data)
+	if !h.Merge() {
+		return data
+	}
+
+	h.SetPrevious(0, 0)
+
+	if e != nil {
+		h.SetPrevious(e.size, int64(db.position(e.segment, e.offset)))
+	}
+
+	linked := make([]byte, len(data))
+	copy(linked, data)
+	copy(linked, header.Serialize(h))
+
+	header.Seal(linked)
+
+	return linked
+}
+
+// merged returns the value of a key whose current record is a merge
+// operand, by applying the operands that follow the key's last value
+// to it. db.mu must be held
+func (db *DB) merged(key []byte, h *header.Header, data []byte) ([]byte, error) {
+	if db.merger == nil {
+		return nil, ErrNoMergeOperator
+	}
+
+	var value []byte
+
+	operands := [][]byte{data[h.DataOffset():]}
+
+	for h.HasPrevious() {
+		var err error
+
+		h, data, err = db.previous(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if h.Merge() {
+			operands = append(operands, data[h.DataOffset():])
+			continue
+		}
+
+		if h.Xmax() > 0 || h.Expires() > 0 && h.Expires() <= time.Now().UnixNano() {
+			break
+		}
+
+		value = data[h.DataOffset():]
+
+		if h.Pointer() {
+			value, err = db.readValue(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		break
+	}
+
+	// apply the operands in the order they were written
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+
+	return db.merger(key, value, operands)
+}
+
+// previous reads the record a merge operand refers to as its previous version
+func (db *DB) previous(h *header.Header) (*header.Header, []byte, error) {
+	size, pos := h.Previous()
+
+	seg, off := db.location(uint64(pos))
+
+	t := db.table(seg)
+	if t == nil {
+		return nil, nil, ErrCorrupt
+	}
+
+	data, err := t.Read(size, off)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header.Deserialize(data), data, nil
+}
+
+// collapse replaces a chain of merge operands with a record containing
+// the merged value, so the records the chain refers to can be removed.
+// returns false if the key's current record is not a merge operand.
+// db.mu must be held exclusively
+func (db *DB) collapse(key []byte, e *entry) (bool, error) {
+	data, err := db.table(e.segment).Read(e.size, e.offset)
+	if err != nil {
+		return false, err
+	}
+
+	h := header.Deserialize(data)
+	if !h.Merge() {
+		return false, nil
+	}
+
+	value, err := db.merged(key, h, data)
+	if err != nil {
+		return false, err
+	}
+
+	var nh header.Header
+
+	data, err = db.separate(record(&nh, key, value))
+	if err != nil {
+		return false, err
+	}
+
+	seg, off, err := db.write(data)
+	if err != nil {
+		return false, err
+	}
+
+	db.setEntry(key, &entry{
+		segment: seg,
+		size:    int64(len(data)),
+		offset:  off,
+	})
+
+	return true, nil
+}
@@ -0,0 +1,239 @@
+package lunar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counter adds operands to the key's value
+func counter(key, value []byte, operands [][]byte) ([]byte, error) {
+	var total uint64
+
+	if value != nil {
+		total = binary.BigEndian.Uint64(value)
+	}
+
+	for _, op := range operands {
+		total = total + binary.BigEndian.Uint64(op)
+	}
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, total)
+
+	return data, nil
+}
+
+func count(n uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, n)
+	return data
+}
+
+func TestMerge(t *testing.T) {
+	db, err := Open("test.db", Merger(counter))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, db.Merge([]byte("test-key"), count(1)))
+	}
+
+	value, err := db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, count(10), value)
+
+	// operands are applied to the last value
+	require.Nil(t, db.Sets("test-key", count(100)))
+	require.Nil(t, db.Merge([]byte("test-key"), count(5)))
+
+	value, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, count(105), value)
+
+	// operands written after a delete are applied to a nil value
+	require.Nil(t, db.Deletes("test-key"))
+	require.Nil(t, db.Merge([]byte("test-key"), count(2)))
+
+	value, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, count(2), value)
+
+	r, err := db.GetReader([]byte("test-key"))
+	require.Nil(t, err)
+	r.Close()
+
+	db.Close()
+
+	// reopen
+	db, err = Open("test.db", Merger(counter))
+	require.Nil(t, err)
+
+	value, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, count(2), value)
+
+	require.Nil(t, db.Merge([]byte("test-key"), count(3)))
+
+	db.Close()
+
+	// compaction on open relinks the operands
+	db, err = Open("test.db", Merger(counter), Compact(true))
+	require.Nil(t, err)
+
+	value, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, count(5), value)
+
+	// compaction collapses the operands
+	require.Nil(t, db.Compact())
+
+	value, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, count(5), value)
+
+	h, _, _, err := db.read([]byte("test-key"))
+	require.Nil(t, err)
+	assert.False(t, h.Merge())
+}
+
+func TestMergeSegments(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	db, err := Open("test-segments", SegmentSize(1<<12), Merger(counter))
+	require.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		require.Nil(t, db.Merge([]byte(fmt.Sprintf("test-key-%d", i%5)), count(1)))
+	}
+
+	require.Nil(t, db.Compact())
+
+	for i := 0; i < 5; i++ {
+		value, err := db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, count(100), value)
+	}
+
+	db.Close()
+
+	db, err = Open("test-segments", SegmentSize(1<<12), Merger(counter))
+	require.Nil(t, err)
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		value, err := db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, count(100), value)
+	}
+}
+
+func TestMergeConcurrent(t *testing.T) {
+	db, err := Open("test.db", Merger(counter))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				assert.Nil(t, db.Merge([]byte("test-key"), count(1)))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	value, err := db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, count(800), value)
+}
+
+func TestMergeRepair(t *testing.T) {
+	defer os.Remove("test.db.corrupt")
+
+	db, err := Open("test.db", Merger(counter))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key-0", []byte("test-0")))
+	require.Nil(t, db.Sets("test-key-1", count(10)))
+	require.Nil(t, db.Merge([]byte("test-key-1"), count(1)))
+	require.Nil(t, db.Merge([]byte("test-key-1"), count(1)))
+
+	e := db.lookup([]byte("test-key-0"))
+
+	require.Nil(t, db.Close())
+
+	fd, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	require.Nil(t, err)
+
+	// remove the first record, moving the records that follow it
+	_, err = fd.WriteAt([]byte("x"), e.offset+e.size-1)
+	require.Nil(t, err)
+
+	require.Nil(t, fd.Close())
+
+	_, err = Repair("test.db", Merger(counter))
+	require.Nil(t, err)
+
+	db, err = Open("test.db", Merger(counter))
+	require.Nil(t, err)
+
+	value, err := db.Gets("test-key-1")
+	require.Nil(t, err)
+	assert.Equal(t, count(12), value)
+}
+
+func TestMergeCompactValues(t *testing.T) {
+	concat := func(key, value []byte, operands [][]byte) ([]byte, error) {
+		for _, op := range operands {
+			value = append(value, op...)
+		}
+		return value, nil
+	}
+
+	db, err := Open("test.db", ValueThreshold(16), Merger(concat))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	large := bytes.Repeat([]byte("x"), 100)
+
+	require.Nil(t, db.Sets("test-key", large))
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db", ValueThreshold(16), Merger(concat))
+	require.Nil(t, err)
+
+	// the value the operand is applied to is still referenced
+	require.Nil(t, db.Merge([]byte("test-key"), []byte("y")))
+	require.Nil(t, db.CompactValues())
+
+	value, err := db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, append(large, 'y'), value)
+}
+
+func TestMergeNoOperator(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	assert.Equal(t, ErrNoMergeOperator, db.Merge([]byte("test-key"), count(1)))
+}
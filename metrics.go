@@ -0,0 +1,25 @@
+package lunar
+
+import (
+	"time"
+
+	"github.com/purehyperbole/lunar/table"
+)
+
+// Metrics receives measurements of the database's operations,
+// including the operations of its data files
+type Metrics interface {
+	table.Metrics
+	Get(duration time.Duration) // a key was read
+	Set(duration time.Duration) // a key or batch was written or deleted
+	Compaction(done, total int) // the number of data files compacted so far, out of the total being compacted
+}
+
+// nopMetrics discards all measurements
+type nopMetrics struct {
+	table.NopMetrics
+}
+
+func (nopMetrics) Get(duration time.Duration) {}
+func (nopMetrics) Set(duration time.Duration) {}
+func (nopMetrics) Compaction(done, total int) {}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"expvar"
+	"strconv"
+
+	"github.com/purehyperbole/lunar"
+)
+
+// histogram a snapshot of a histogram
+type histogram struct {
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`     // total in seconds
+	Buckets map[string]uint64 `json:"buckets"` // cumulative counts by upper bound in seconds
+}
+
+// Expvar returns a variable that reports the collector's measurements and the
+// database's statistics as json. It can be published with expvar.Publish
+func (c *Collector) Expvar(db *lunar.DB) expvar.Var {
+	return expvar.Func(func() interface{} {
+		stats := db.Counts()
+		done, total := c.Compacted()
+
+		return map[string]interface{}{
+			"gets":          snapshot(c.Gets),
+			"sets":          snapshot(c.Sets),
+			"remaps":        snapshot(c.Remaps),
+			"syncs":         snapshot(c.Syncs),
+			"written_bytes": c.Written(),
+			"compaction": map[string]int{
+				"done":  done,
+				"total": total,
+			},
+			"segments":   stats.Segments,
+			"keys":       stats.Keys,
+			"deleted":    stats.Deleted,
+			"size_bytes": stats.Size,
+			"used_bytes": stats.Used,
+			"live_bytes": stats.Live,
+		}
+	})
+}
+
+func snapshot(h *Histogram) histogram {
+	s := histogram{
+		Count:   h.Count(),
+		Sum:     h.Sum().Seconds(),
+		Buckets: make(map[string]uint64, len(buckets)),
+	}
+
+	for i, count := range h.cumulative()[:len(buckets)] {
+		s.Buckets[seconds(i)] = count
+	}
+
+	return s
+}
+
+// seconds formats the upper bound of a bucket in seconds
+func seconds(bucket int) string {
+	return strconv.FormatFloat(buckets[bucket].Seconds(), 'g', -1, 64)
+}
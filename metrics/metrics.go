@@ -0,0 +1,139 @@
+// Package metrics collects the measurements of a lunar database, and exports
+// them with expvar or in the Prometheus text format
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// buckets the upper bounds of the histogram buckets
+var buckets = []time.Duration{
+	time.Microsecond * 10,
+	time.Microsecond * 50,
+	time.Microsecond * 100,
+	time.Microsecond * 500,
+	time.Millisecond,
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 500,
+	time.Second,
+	time.Second * 5,
+}
+
+// Histogram counts durations in fixed buckets
+type Histogram struct {
+	count  uint64
+	sum    int64
+	counts []uint64 // count of durations in each bucket, and those larger than every bucket
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{
+		counts: make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe adds a duration to the histogram
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+
+	for i < len(buckets) && d > buckets[i] {
+		i++
+	}
+
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// Count returns the number of durations observed
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Sum returns the total of the durations observed
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.sum))
+}
+
+// cumulative returns the number of durations less than or equal to the
+// upper bound of each bucket, followed by the number of all durations
+func (h *Histogram) cumulative() []uint64 {
+	counts := make([]uint64, len(h.counts))
+
+	var total uint64
+
+	for i := range h.counts {
+		total = total + atomic.LoadUint64(&h.counts[i])
+		counts[i] = total
+	}
+
+	return counts
+}
+
+// Collector aggregates the measurements of a database in memory.
+// It is registered with the database using the lunar.Instrument option
+type Collector struct {
+	written    uint64     // bytes written to the data files
+	compacted  int64      // data files compacted by the current or last compaction
+	compacting int64      // data files being compacted by the current or last compaction
+	Gets       *Histogram // latency of reads
+	Sets       *Histogram // latency of writes and deletes
+	Remaps     *Histogram // duration of data file remaps
+	Syncs      *Histogram // latency of data file syncs
+}
+
+// New creates a collector
+func New() *Collector {
+	return &Collector{
+		Gets:   newHistogram(),
+		Sets:   newHistogram(),
+		Remaps: newHistogram(),
+		Syncs:  newHistogram(),
+	}
+}
+
+// Get measures the latency of a read
+func (c *Collector) Get(duration time.Duration) {
+	c.Gets.Observe(duration)
+}
+
+// Set measures the latency of a write
+func (c *Collector) Set(duration time.Duration) {
+	c.Sets.Observe(duration)
+}
+
+// Write counts the bytes written to a data file
+func (c *Collector) Write(bytes int) {
+	atomic.AddUint64(&c.written, uint64(bytes))
+}
+
+// Remap measures the duration of a data file remap
+func (c *Collector) Remap(size int64, duration time.Duration) {
+	c.Remaps.Observe(duration)
+}
+
+// Sync measures the latency of a data file sync
+func (c *Collector) Sync(duration time.Duration) {
+	c.Syncs.Observe(duration)
+}
+
+// Compaction records the progress of a compaction
+func (c *Collector) Compaction(done, total int) {
+	atomic.StoreInt64(&c.compacted, int64(done))
+	atomic.StoreInt64(&c.compacting, int64(total))
+}
+
+// Written returns the number of bytes written to the data files
+func (c *Collector) Written() uint64 {
+	return atomic.LoadUint64(&c.written)
+}
+
+// Compacted returns the number of data files compacted by
+// the current or last compaction, out of the total being compacted
+func (c *Collector) Compacted() (int, int) {
+	return int(atomic.LoadInt64(&c.compacted)), int(atomic.LoadInt64(&c.compacting))
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+
+	h.Observe(time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(time.Minute)
+
+	assert.Equal(t, uint64(3), h.Count())
+	assert.Equal(t, time.Minute+time.Millisecond+time.Microsecond, h.Sum())
+
+	counts := h.cumulative()
+	require.Len(t, counts, len(buckets)+1)
+
+	assert.Equal(t, uint64(1), counts[0])
+	assert.Equal(t, uint64(2), counts[4])
+	assert.Equal(t, uint64(2), counts[len(buckets)-1])
+	assert.Equal(t, uint64(3), counts[len(buckets)])
+}
+
+func TestCollector(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	c := New()
+
+	db, err := lunar.Open("test-segments", lunar.SegmentSize(1<<12), lunar.Instrument(c))
+	require.Nil(t, err)
+	defer db.Close()
+
+	value := make([]byte, 1000)
+
+	for i := 0; i < 20; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("test-key-%d", i%5), value))
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err = db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, db.Compact())
+
+	assert.Equal(t, uint64(20), c.Sets.Count())
+	assert.Equal(t, uint64(5), c.Gets.Count())
+	assert.True(t, c.Written() > 20*1000)
+
+	done, total := c.Compacted()
+	assert.Equal(t, 6, done)
+	assert.Equal(t, 6, total)
+
+	// expvar
+	var vars map[string]interface{}
+
+	require.Nil(t, json.Unmarshal([]byte(c.Expvar(db).String()), &vars))
+	assert.Equal(t, float64(5), vars["keys"])
+	assert.Equal(t, float64(5), vars["gets"].(map[string]interface{})["count"])
+
+	// prometheus
+	w := httptest.NewRecorder()
+
+	c.Handler(db).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+
+	assert.Contains(t, body, "# TYPE lunar_get_duration_seconds histogram\n")
+	assert.Contains(t, body, "lunar_get_duration_seconds_bucket{le=\"+Inf\"} 5\n")
+	assert.Contains(t, body, "lunar_set_duration_seconds_count 20\n")
+	assert.Contains(t, body, "lunar_keys 5\n")
+	assert.Contains(t, body, "lunar_compaction_done 6\n")
+}
+
+func TestCollectorBucketsAndReaders(t *testing.T) {
+	defer os.Remove("test.db")
+	defer os.Remove("test.db.hint")
+	defer os.RemoveAll("test.db.values")
+
+	c := New()
+
+	db, err := lunar.Open("test.db", lunar.Instrument(c))
+	require.Nil(t, err)
+	defer db.Close()
+
+	b := db.Bucket("test")
+
+	require.Nil(t, b.Sets("test-key", []byte("test")))
+	require.Nil(t, b.Deletes("test-key"))
+
+	_, err = b.Gets("test-key")
+	assert.Equal(t, lunar.ErrNotFound, err)
+
+	require.Nil(t, db.SetReader([]byte("test-reader"), strings.NewReader("test"), 4))
+
+	r, version, err := db.GetReaderVersion([]byte("test-reader"))
+	require.Nil(t, err)
+	require.Nil(t, r.Close())
+
+	_, err = db.CompareAndSwap([]byte("test-reader"), []byte("test"), version)
+	require.Nil(t, err)
+
+	_, _, err = db.GetVersion([]byte("test-reader"))
+	require.Nil(t, err)
+
+	assert.Equal(t, uint64(4), c.Sets.Count())
+	assert.Equal(t, uint64(3), c.Gets.Count())
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/purehyperbole/lunar"
+)
+
+// Handler returns a handler that reports the collector's measurements
+// and the database's statistics in the Prometheus text format
+func (c *Collector) Handler(db *lunar.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		bw := bufio.NewWriter(w)
+
+		c.WritePrometheus(bw, db)
+
+		bw.Flush()
+	})
+}
+
+// WritePrometheus writes the collector's measurements and
+// the database's statistics in the Prometheus text format
+func (c *Collector) WritePrometheus(w io.Writer, db *lunar.DB) {
+	stats := db.Counts()
+	done, total := c.Compacted()
+
+	writeHistogram(w, "lunar_get_duration_seconds", "Latency of reads.", c.Gets)
+	writeHistogram(w, "lunar_set_duration_seconds", "Latency of writes and deletes.", c.Sets)
+	writeHistogram(w, "lunar_remap_duration_seconds", "Duration of data file remaps.", c.Remaps)
+	writeHistogram(w, "lunar_sync_duration_seconds", "Latency of data file syncs.", c.Syncs)
+
+	writeMetric(w, "lunar_written_bytes_total", "Bytes written to the data files.", "counter", float64(c.Written()))
+	writeMetric(w, "lunar_compaction_done", "Data files compacted by the current or last compaction.", "gauge", float64(done))
+	writeMetric(w, "lunar_compaction_total", "Data files being compacted by the current or last compaction.", "gauge", float64(total))
+	writeMetric(w, "lunar_segments", "Number of data files.", "gauge", float64(stats.Segments))
+	writeMetric(w, "lunar_keys", "Number of keys in the index, including expired keys that have not been compacted.", "gauge", float64(stats.Keys))
+	writeMetric(w, "lunar_deleted_keys", "Number of deleted keys that have not been compacted.", "gauge", float64(stats.Deleted))
+	writeMetric(w, "lunar_size_bytes", "Total size of the data files.", "gauge", float64(stats.Size))
+	writeMetric(w, "lunar_used_bytes", "Total size of all records written to the data files.", "gauge", float64(stats.Used))
+	writeMetric(w, "lunar_live_bytes", "Total size of the current version of every key.", "gauge", float64(stats.Live))
+}
+
+func writeMetric(w io.Writer, name, help, kind string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func writeHistogram(w io.Writer, name, help string, h *Histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	counts := h.cumulative()
+
+	// the count is derived from the buckets, so it is never less than their counts
+	count := counts[len(buckets)]
+
+	for i, c := range counts[:len(buckets)] {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, seconds(i), c)
+	}
+
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.Sum().Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
@@ -1,5 +1,14 @@
 package lunar
 
+import (
+	"errors"
+
+	"github.com/purehyperbole/lunar/table"
+)
+
+// Logger logs leveled events with key value pairs. It is satisfied by *slog.Logger
+type Logger = table.Logger
+
 // Compact option used when opening the database
 // Compaction only happens once when the data table is loaded
 // The existing database will be copied to a backup file and a new database table created
@@ -9,3 +18,76 @@ func Compact(c bool) func(db *DB) error {
 		return nil
 	}
 }
+
+// SegmentSize option used when opening the database
+// Data will be stored in a directory of numbered segment files,
+// each of which will not grow larger than the specified size.
+// When the active segment is full, a new segment will be created
+func SegmentSize(size int64) func(db *DB) error {
+	return func(db *DB) error {
+		if size < 1 {
+			return errors.New("segment size must be greater than zero")
+		}
+
+		if size > MaxSegmentSize {
+			return errors.New("segment size exceeds maximum")
+		}
+
+		db.segmentSize = size
+		return nil
+	}
+}
+
+// ValueThreshold option used when opening the database
+// Values larger than the threshold will be stored in a separate
+// value log, and the data file will store a pointer to the value.
+// This reduces the amount of data rewritten by compaction.
+// Space used by the value log is reclaimed by CompactValues
+func ValueThreshold(size int64) func(db *DB) error {
+	return func(db *DB) error {
+		if size < 1 {
+			return errors.New("value threshold must be greater than zero")
+		}
+
+		db.valueThreshold = size
+		return nil
+	}
+}
+
+// Merger option used when opening the database
+// Registers the merge operator used to combine the operands
+// written by Merge with a key's value when it is read
+func Merger(op MergeOperator) func(db *DB) error {
+	return func(db *DB) error {
+		db.merger = op
+		return nil
+	}
+}
+
+// Log option used when opening the database
+// Events such as remaps, compactions, recoveries and errors
+// are logged to the logger. Nothing is logged by default
+func Log(logger Logger) func(db *DB) error {
+	return func(db *DB) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+
+		db.logger = logger
+		return nil
+	}
+}
+
+// Instrument option used when opening the database
+// The latency of reads and writes, the progress of compactions
+// and the operations of the data files are measured by metrics
+func Instrument(metrics Metrics) func(db *DB) error {
+	return func(db *DB) error {
+		if metrics == nil {
+			return errors.New("metrics must not be nil")
+		}
+
+		db.metrics = metrics
+		return nil
+	}
+}
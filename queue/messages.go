@@ -0,0 +1,52 @@
+package queue
+
+import "container/heap"
+
+// message the id and deadline of a message that has not been acknowledged
+type message struct {
+	id       uint64
+	deadline int64
+}
+
+// messages a heap of messages, ordered by less
+type messages struct {
+	items []message
+	less  func(a, b message) bool
+}
+
+func byID(a, b message) bool {
+	return a.id < b.id
+}
+
+func byDeadline(a, b message) bool {
+	if a.deadline == b.deadline {
+		return a.id < b.id
+	}
+	return a.deadline < b.deadline
+}
+
+func (m *messages) Len() int           { return len(m.items) }
+func (m *messages) Less(i, j int) bool { return m.less(m.items[i], m.items[j]) }
+func (m *messages) Swap(i, j int)      { m.items[i], m.items[j] = m.items[j], m.items[i] }
+
+func (m *messages) Push(x interface{}) {
+	m.items = append(m.items, x.(message))
+}
+
+func (m *messages) Pop() interface{} {
+	last := m.items[len(m.items)-1]
+	m.items = m.items[:len(m.items)-1]
+	return last
+}
+
+func (m *messages) push(msg message) {
+	heap.Push(m, msg)
+}
+
+func (m *messages) pop() message {
+	return heap.Pop(m).(message)
+}
+
+func (m *messages) peek() message {
+	return m.items[0]
+}
@@ -0,0 +1,358 @@
+// Package queue provides persistent, named FIFO queues stored in a lunar database
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+)
+
+var (
+	// ErrEmpty there are no messages ready to be dequeued
+	ErrEmpty = errors.New("queue is empty")
+	// ErrInvalidName the queue name is empty or contains a null byte
+	ErrInvalidName = errors.New("invalid queue name")
+	// ErrInvalidMessage a stored message could not be decoded
+	ErrInvalidMessage = errors.New("invalid message")
+	// ErrStaleReceipt the message has been delivered again since the receipt was issued
+	ErrStaleReceipt = errors.New("message has been redelivered")
+)
+
+const (
+	// bucket the name of the bucket queues are stored in. It starts
+	// with a null byte so it does not collide with other buckets
+	bucket = "\x00queue"
+	// messageHeaderSize the size of a message's deadline and attempts
+	messageHeaderSize = 12
+)
+
+// Message a message that has been dequeued
+type Message struct {
+	ID       uint64    // the position of the message in the queue
+	Body     []byte    // the message's contents
+	Attempts int       // the number of times the message has been dequeued
+	Deadline time.Time // the time the message will be redelivered if it has not been acknowledged
+	Receipt  Receipt   // identifies this delivery of the message, to acknowledge it
+}
+
+// Receipt identifies a single delivery of a message. A receipt can
+// no longer be used once the message has been delivered again
+type Receipt struct {
+	ID       uint64
+	Attempts int
+}
+
+// Queue a named FIFO queue. Messages are ordered by a sequence that is
+// stored with them, and every change to a message's state is written
+// atomically, so no message is lost or duplicated by a crash. Messages
+// that are dequeued are redelivered if they are not acknowledged before
+// their visibility timeout. A queue should only be opened once per database
+type Queue struct {
+	mu        sync.Mutex
+	bucket    *lunar.Bucket
+	name      string
+	seq       uint64           // the id of the last message enqueued
+	deadlines map[uint64]int64 // the deadline of every message that has not been acknowledged
+	ready     messages         // messages that can be delivered, ordered by id
+	pending   messages         // messages that have been delivered, ordered by deadline
+}
+
+// Open opens a named queue stored in the database
+func Open(db *lunar.DB, name string) (*Queue, error) {
+	if name == "" || bytes.IndexByte([]byte(name), 0) > -1 {
+		return nil, ErrInvalidName
+	}
+
+	q := &Queue{
+		bucket:    db.Bucket(bucket),
+		name:      name,
+		deadlines: make(map[uint64]int64),
+		ready:     messages{less: byID},
+		pending:   messages{less: byDeadline},
+	}
+
+	data, err := q.bucket.Get(q.sequenceKey())
+	if err != nil && err != lunar.ErrNotFound {
+		return nil, err
+	}
+
+	if len(data) == 8 {
+		q.seq = binary.BigEndian.Uint64(data)
+	}
+
+	// the message's states are read once, then kept in memory
+	now := time.Now().UnixNano()
+
+	err = q.bucket.Scan(q.messagePrefix(), func(key, value []byte) error {
+		deadline, _, _, err := decode(value)
+		if err != nil {
+			return err
+		}
+
+		q.schedule(binary.BigEndian.Uint64(key[len(key)-8:]), deadline, now)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Queues returns the names of the queues stored in the database
+func Queues(db *lunar.DB) ([]string, error) {
+	var names []string
+
+	err := db.Bucket(bucket).Scan(nil, func(key, value []byte) error {
+		i := bytes.IndexByte(key, 0)
+		if i < 0 || !bytes.Equal(key[i+1:], []byte("s")) {
+			return nil
+		}
+
+		names = append(names, string(key[:i]))
+
+		return nil
+	})
+
+	return names, err
+}
+
+// Name returns the name of the queue
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// Len returns the number of messages that have not been acknowledged,
+// including messages that have been dequeued
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.deadlines)
+}
+
+// Enqueue adds a message to the end of the queue, returning its id
+func (q *Queue) Enqueue(body []byte) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := q.seq + 1
+
+	// the message and the sequence it was assigned are written together
+	var b lunar.Batch
+
+	b.Set(q.messageKey(id), encode(0, 0, body))
+	b.Set(q.sequenceKey(), uint64Bytes(id))
+
+	err := q.bucket.Apply(&b)
+	if err != nil {
+		return 0, err
+	}
+
+	q.seq = id
+	q.schedule(id, 0, 0)
+
+	return id, nil
+}
+
+// Dequeue returns the oldest message that is ready to be delivered. The
+// message is hidden from other calls to Dequeue until the timeout expires,
+// after which it is redelivered unless it has been acknowledged with Ack.
+// ErrEmpty is returned if there are no messages ready to be delivered
+func (q *Queue) Dequeue(timeout time.Duration) (*Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+
+	id, ok := q.next(now.UnixNano())
+	if !ok {
+		return nil, ErrEmpty
+	}
+
+	key := q.messageKey(id)
+
+	value, err := q.bucket.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	_, attempts, body, err := decode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		ID:       id,
+		Body:     body,
+		Attempts: attempts + 1,
+		Deadline: now.Add(timeout),
+	}
+
+	m.Receipt = Receipt{ID: id, Attempts: m.Attempts}
+
+	err = q.bucket.Set(key, encode(m.Deadline.UnixNano(), m.Attempts, m.Body))
+	if err != nil {
+		// the message is still ready to be delivered
+		q.ready.push(message{id: id})
+		return nil, err
+	}
+
+	q.schedule(id, m.Deadline.UnixNano(), now.UnixNano())
+
+	return m, nil
+}
+
+// Ack acknowledges a delivery of a message, removing it from the queue.
+// ErrStaleReceipt is returned if the message has been delivered again
+func (q *Queue) Ack(r Receipt) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := q.messageKey(r.ID)
+
+	_, err := q.delivery(key, r)
+	if err != nil {
+		return err
+	}
+
+	err = q.bucket.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	// any entries left in the ready or pending
+	// messages are skipped when they are reached
+	delete(q.deadlines, r.ID)
+
+	return nil
+}
+
+// Nack returns a delivered message to the queue, so it can be dequeued
+// again without waiting for its timeout. ErrStaleReceipt is returned
+// if the message has been delivered again
+func (q *Queue) Nack(r Receipt) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := q.messageKey(r.ID)
+
+	body, err := q.delivery(key, r)
+	if err != nil {
+		return err
+	}
+
+	err = q.bucket.Set(key, encode(0, r.Attempts, body))
+	if err != nil {
+		return err
+	}
+
+	q.schedule(r.ID, 0, 0)
+
+	return nil
+}
+
+// delivery returns the body of a message if it has not
+// been delivered again since the receipt was issued
+func (q *Queue) delivery(key []byte, r Receipt) ([]byte, error) {
+	value, err := q.bucket.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	_, attempts, body, err := decode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if attempts != r.Attempts {
+		return nil, ErrStaleReceipt
+	}
+
+	return body, nil
+}
+
+// schedule records a message's deadline, adding it to the ready
+// messages if the deadline has passed, or the pending messages if not
+func (q *Queue) schedule(id uint64, deadline, now int64) {
+	q.deadlines[id] = deadline
+
+	if deadline <= now {
+		q.ready.push(message{id: id, deadline: deadline})
+	} else {
+		q.pending.push(message{id: id, deadline: deadline})
+	}
+}
+
+// next removes and returns the id of the oldest message that
+// can be delivered, after moving any pending messages whose
+// deadline has passed to the ready messages
+func (q *Queue) next(now int64) (uint64, bool) {
+	for q.pending.Len() > 0 && q.pending.peek().deadline <= now {
+		m := q.pending.pop()
+
+		// skip messages that have been acknowledged or rescheduled
+		if d, ok := q.deadlines[m.id]; ok && d == m.deadline {
+			q.ready.push(m)
+		}
+	}
+
+	for q.ready.Len() > 0 {
+		m := q.ready.pop()
+
+		if d, ok := q.deadlines[m.id]; ok && d <= now {
+			return m.id, true
+		}
+	}
+
+	return 0, false
+}
+
+func (q *Queue) messagePrefix() []byte {
+	key := make([]byte, 0, len(q.name)+2)
+	key = append(key, q.name...)
+	return append(key, 0, 'm')
+}
+
+func (q *Queue) messageKey(id uint64) []byte {
+	return append(q.messagePrefix(), uint64Bytes(id)...)
+}
+
+func (q *Queue) sequenceKey() []byte {
+	key := make([]byte, 0, len(q.name)+2)
+	key = append(key, q.name...)
+	return append(key, 0, 's')
+}
+
+// encode encodes a message's deadline, attempts and body
+func encode(deadline int64, attempts int, body []byte) []byte {
+	data := make([]byte, messageHeaderSize+len(body))
+	binary.BigEndian.PutUint64(data, uint64(deadline))
+	binary.BigEndian.PutUint32(data[8:], uint32(attempts))
+	copy(data[messageHeaderSize:], body)
+	return data
+}
+
+// decode decodes a message's deadline, attempts and body
+func decode(data []byte) (int64, int, []byte, error) {
+	if len(data) < messageHeaderSize {
+		return 0, 0, nil, ErrInvalidMessage
+	}
+
+	deadline := int64(binary.BigEndian.Uint64(data))
+	attempts := int(binary.BigEndian.Uint32(data[8:]))
+
+	return deadline, attempts, data[messageHeaderSize:], nil
+}
+
+func uint64Bytes(v uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, v)
+	return data
+}
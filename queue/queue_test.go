@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cleanup(db *lunar.DB) {
+	db.Close()
+	os.Remove("test.db")
+	os.Remove("test.db.idx")
+	os.Remove("test.db.hint")
+}
+
+func TestQueue(t *testing.T) {
+	db, err := lunar.Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	q, err := Open(db, "jobs")
+	require.Nil(t, err)
+
+	_, err = q.Dequeue(time.Minute)
+	assert.Equal(t, ErrEmpty, err)
+
+	for i := 0; i < 5; i++ {
+		id, err := q.Enqueue([]byte(fmt.Sprintf("job-%d", i)))
+		require.Nil(t, err)
+		assert.Equal(t, uint64(i+1), id)
+	}
+
+	assert.Equal(t, 5, q.Len())
+
+	// queues are not stored in the database's keys
+	err = db.Scan(nil, func(key, value []byte) error {
+		t.Errorf("unexpected key %q", key)
+		return nil
+	})
+	require.Nil(t, err)
+
+	// messages are dequeued in order
+	m, err := q.Dequeue(time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), m.ID)
+	assert.Equal(t, []byte("job-0"), m.Body)
+	assert.Equal(t, 1, m.Attempts)
+
+	first := m.Receipt
+
+	m, err = q.Dequeue(time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), m.ID)
+
+	require.Nil(t, q.Ack(first))
+	assert.Equal(t, lunar.ErrNotFound, q.Ack(first))
+	assert.Equal(t, 4, q.Len())
+
+	// a nacked message is redelivered
+	require.Nil(t, q.Nack(m.Receipt))
+
+	m, err = q.Dequeue(time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), m.ID)
+	assert.Equal(t, 2, m.Attempts)
+
+	// a message is redelivered after its visibility timeout
+	m, err = q.Dequeue(time.Millisecond * 10)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(3), m.ID)
+
+	expired := m.Receipt
+
+	time.Sleep(time.Millisecond * 20)
+
+	m, err = q.Dequeue(time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(3), m.ID)
+	assert.Equal(t, 2, m.Attempts)
+
+	// the previous delivery can no longer be acknowledged
+	assert.Equal(t, ErrStaleReceipt, q.Ack(expired))
+	assert.Equal(t, ErrStaleReceipt, q.Nack(expired))
+	assert.Equal(t, 4, q.Len())
+
+	db.Close()
+
+	// reopen
+	db, err = lunar.Open("test.db")
+	require.Nil(t, err)
+
+	q, err = Open(db, "jobs")
+	require.Nil(t, err)
+
+	assert.Equal(t, 4, q.Len())
+
+	m, err = q.Dequeue(time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(4), m.ID)
+
+	// ids are not reused
+	id, err := q.Enqueue([]byte("job-5"))
+	require.Nil(t, err)
+	assert.Equal(t, uint64(6), id)
+}
+
+func TestQueueNamed(t *testing.T) {
+	db, err := lunar.Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	_, err = Open(db, "")
+	assert.Equal(t, ErrInvalidName, err)
+
+	_, err = Open(db, "jobs\x00")
+	assert.Equal(t, ErrInvalidName, err)
+
+	jobs, err := Open(db, "jobs")
+	require.Nil(t, err)
+
+	emails, err := Open(db, "emails")
+	require.Nil(t, err)
+
+	_, err = jobs.Enqueue([]byte("job"))
+	require.Nil(t, err)
+
+	_, err = emails.Enqueue([]byte("email"))
+	require.Nil(t, err)
+
+	m, err := jobs.Dequeue(time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("job"), m.Body)
+
+	_, err = jobs.Dequeue(time.Minute)
+	assert.Equal(t, ErrEmpty, err)
+
+	m, err = emails.Dequeue(time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("email"), m.Body)
+
+	names, err := Queues(db)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"emails", "jobs"}, names)
+}
@@ -0,0 +1,354 @@
+package lunar
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+)
+
+// Range a range of bytes within a data file
+type Range struct {
+	Segment uint32
+	Offset  int64
+	Size    int64
+}
+
+// RepairReport describes the outcome of a repair
+type RepairReport struct {
+	Records int      // number of valid records salvaged
+	Skipped []Range  // byte ranges that did not contain valid records
+	Lost    [][]byte // keys of invalid records that have no valid version
+}
+
+// Repair scans the data files of a database, salvaging all valid records.
+// Any data file that contains invalid records is rewritten with only its
+// valid records, with the original data file kept with a ".corrupt" suffix.
+// The same options used to open the database should be provided.
+// The database must not be open while it is being repaired
+func Repair(path string, opts ...func(*DB) error) (*RepairReport, error) {
+	db := DB{logger: table.NopLogger{}, metrics: nopMetrics{}}
+
+	for _, opt := range opts {
+		err := opt(&db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db.path = path
+
+	if !exists(path) {
+		return nil, os.ErrNotExist
+	}
+
+	ids := []uint32{0}
+
+	if db.segmented() {
+		var err error
+
+		ids, err = segmentIDs(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range ids {
+		err := checkFormat(db.dataPath(id))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r := repairer{
+		db:       &db,
+		report:   &RepairReport{},
+		salvaged: make(map[string]*entry),
+		invalid:  make(map[string]struct{}),
+	}
+
+	for _, id := range ids {
+		err := r.repair(&db, id)
+		if err != nil {
+			return r.report, err
+		}
+	}
+
+	for key := range r.invalid {
+		if _, ok := r.salvaged[key]; !ok {
+			r.report.Lost = append(r.report.Lost, []byte(key))
+		}
+	}
+
+	sort.Slice(r.report.Lost, func(i, j int) bool {
+		return bytes.Compare(r.report.Lost[i], r.report.Lost[j]) < 0
+	})
+
+	return r.report, nil
+}
+
+type repairer struct {
+	db       *DB
+	report   *RepairReport
+	salvaged map[string]*entry // location of the last record salvaged for each key
+	invalid  map[string]struct{}
+}
+
+func (r *repairer) repair(db *DB, id uint32) error {
+	src := db.dataPath(id)
+	tmp := src + ".repair"
+	corrupt := src + ".corrupt"
+
+	if exists(corrupt) {
+		return errors.New("could not backup corrupt data file")
+	}
+
+	rt, err := db.openTable(src)
+	if err != nil {
+		return err
+	}
+
+	os.Remove(tmp)
+
+	wt, err := db.openTable(tmp)
+	if err != nil {
+		rt.Close()
+		return err
+	}
+
+	skipped := len(r.report.Skipped)
+
+	err = r.salvage(id, rt, wt)
+
+	rt.Close()
+	wt.Close()
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// the data file is intact
+	if skipped == len(r.report.Skipped) {
+		return os.Remove(tmp)
+	}
+
+	err = db.removeHint(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(src, corrupt)
+	if err != nil {
+		return err
+	}
+
+	db.logger.Warn("repaired data file", "path", src, "skipped", len(r.report.Skipped)-skipped)
+
+	return os.Rename(tmp, src)
+}
+
+// salvage copies all valid records from the read table to the write table.
+// the records of a batch are only copied if every record in the batch is valid
+func (r *repairer) salvage(id uint32, rt, wt *table.Table) error {
+	var pos, start, next int64 // next is the offset the next record is expected at
+	var pending [][]byte       // records of a batch that has not been completely read
+
+	skip := int64(-1)
+	size := rt.Size()
+
+	// xmin of the next record of a discarded batch, which
+	// is also discarded if it is found after the corruption
+	orphan := int64(-1)
+
+	// discard the records of a batch that is incomplete, skipping them
+	discard := func() {
+		if len(pending) < 1 {
+			return
+		}
+
+		orphan = int64(header.Deserialize(pending[len(pending)-1]).Xmin()) - 1
+
+		for _, data := range pending {
+			h := header.Deserialize(data)
+			r.invalid[string(data[header.HeaderSize:h.DataOffset()])] = struct{}{}
+		}
+
+		if skip < 0 {
+			skip = start
+		}
+
+		pending = pending[:0]
+	}
+
+	for pos+header.HeaderSize <= size {
+		hd, err := rt.Read(header.HeaderSize, pos)
+		if err != nil {
+			return err
+		}
+
+		h := header.Deserialize(hd)
+
+		if empty(hd) {
+			// skip to the first offset a record could start
+			// at, based on the next non-zero byte
+			next, err := nonzero(rt, pos, size)
+			if err != nil {
+				return err
+			}
+
+			if next == size {
+				break
+			}
+
+			discard()
+
+			if skip < 0 {
+				skip = pos
+			}
+
+			pos = next - (header.ChecksumOffset - 1)
+
+			continue
+		}
+
+		if !sane(h, pos, size) {
+			discard()
+
+			if skip < 0 {
+				skip = pos
+			}
+
+			pos++
+			continue
+		}
+
+		data, err := rt.Read(h.TotalSize(), pos)
+		if err != nil {
+			return err
+		}
+
+		if !header.Valid(data) {
+			discard()
+
+			if skip < 0 {
+				skip = pos
+			}
+
+			// only records found where a record is expected to start are
+			// counted, as headers found within corrupt data may not be records
+			if pos == next {
+				r.invalid[string(data[header.HeaderSize:h.DataOffset()])] = struct{}{}
+				next = pos + h.TotalSize()
+
+				if orphan >= 0 {
+					orphan--
+				}
+			}
+
+			pos++
+			continue
+		}
+
+		// each record of a batch stores the number of records that follow it
+		if len(pending) > 0 && h.Xmin() != header.Deserialize(pending[len(pending)-1]).Xmin()-1 {
+			discard()
+		}
+
+		if orphan >= 0 && int64(h.Xmin()) <= orphan {
+			// the record belongs to a batch that has been discarded
+			if skip < 0 {
+				skip = pos
+			}
+
+			r.invalid[string(data[header.HeaderSize:h.DataOffset()])] = struct{}{}
+
+			orphan = int64(h.Xmin()) - 1
+			pos = pos + h.TotalSize()
+			next = pos
+
+			continue
+		}
+
+		orphan = -1
+
+		if skip >= 0 {
+			r.skip(id, skip, pos)
+			skip = -1
+		}
+
+		if len(pending) < 1 {
+			start = pos
+		}
+
+		pending = append(pending, data)
+		pos = pos + h.TotalSize()
+		next = pos
+
+		if h.Xmin() > 0 {
+			continue
+		}
+
+		for _, data := range pending {
+			h := header.Deserialize(data)
+			key := string(data[header.HeaderSize:h.DataOffset()])
+
+			// merge operands are linked to the key's record
+			// in the repaired data file, as offsets may change
+			offset, err := wt.Write(r.db.linkTo(r.salvaged[key], data))
+			if err != nil {
+				return err
+			}
+
+			r.salvaged[key] = &entry{segment: id, offset: offset, size: h.TotalSize()}
+			r.report.Records++
+		}
+
+		pending = pending[:0]
+	}
+
+	discard()
+
+	if skip >= 0 {
+		r.skip(id, skip, pos)
+	}
+
+	return nil
+}
+
+func (r *repairer) skip(id uint32, from, to int64) {
+	r.report.Skipped = append(r.report.Skipped, Range{
+		Segment: id,
+		Offset:  from,
+		Size:    to - from,
+	})
+}
+
+// nonzero returns the offset of the next non-zero byte in a table
+func nonzero(t *table.Table, offset, size int64) (int64, error) {
+	for offset < size {
+		n := int64(table.PageSize)
+
+		if offset+n > size {
+			n = size - offset
+		}
+
+		data, err := t.Read(n, offset)
+		if err != nil {
+			return 0, err
+		}
+
+		for i := range data {
+			if data[i] != 0 {
+				return offset + int64(i), nil
+			}
+		}
+
+		offset = offset + n
+	}
+
+	return size, nil
+}
@@ -0,0 +1,255 @@
+package lunar
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenChecksum(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), []byte(fmt.Sprintf("test-value-%d", i)))
+		require.Nil(t, err)
+	}
+
+	e1 := db.lookup([]byte("test-key-1"))
+	e2 := db.lookup([]byte("test-key-2"))
+
+	require.Nil(t, db.Close())
+
+	fd, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	require.Nil(t, err)
+
+	// a record that was not completely written is discarded
+	_, err = fd.WriteAt([]byte{0}, e2.offset+e2.size-1)
+	require.Nil(t, err)
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key-2")
+	assert.Equal(t, ErrNotFound, err)
+
+	// and cleared, so a shorter record can be written in its place
+	require.Nil(t, db.Sets("test-key-3", []byte("x")))
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	value, err := db.Gets("test-key-3")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("x"), value)
+
+	require.Nil(t, db.Close())
+
+	// any other damaged record prevents the database from being opened
+	_, err = fd.WriteAt([]byte("x"), e1.offset+e1.size-1)
+	require.Nil(t, err)
+
+	require.Nil(t, fd.Close())
+
+	db, err = Open("test.db")
+	assert.True(t, errors.Is(err, ErrCorrupt))
+}
+
+func TestRepair(t *testing.T) {
+	defer os.Remove("test.db.corrupt")
+
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	// update a key so the previous version can be salvaged
+	err = db.Sets("test-key-7", []byte("test-1234"))
+	require.Nil(t, err)
+
+	e3 := db.lookup([]byte("test-key-3"))
+	e7 := db.lookup([]byte("test-key-7"))
+
+	require.Nil(t, db.Close())
+
+	fd, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	require.Nil(t, err)
+
+	// corrupt the value of one record and the header of another
+	_, err = fd.WriteAt([]byte("x"), e3.offset+e3.size-1)
+	require.Nil(t, err)
+
+	_, err = fd.WriteAt([]byte{0xFF, 0xFF, 0xFF}, e7.offset+40)
+	require.Nil(t, err)
+
+	require.Nil(t, fd.Close())
+
+	_, err = Open("test.db")
+	assert.True(t, errors.Is(err, ErrCorrupt))
+
+	report, err := Repair("test.db")
+	require.Nil(t, err)
+
+	assert.Equal(t, 9, report.Records)
+	assert.Equal(t, [][]byte{[]byte("test-key-3")}, report.Lost)
+	assert.Equal(t, []Range{
+		{Offset: e3.offset, Size: e3.size},
+		{Offset: e7.offset, Size: e7.size},
+	}, report.Skipped)
+
+	assert.True(t, exists("test.db.corrupt"))
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key-3")
+	assert.Equal(t, ErrNotFound, err)
+
+	data, err := db.Gets("test-key-7")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-7"), data)
+
+	data, err = db.Gets("test-key-9")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-9"), data)
+
+	// repairing an intact database leaves it untouched
+	require.Nil(t, db.Close())
+	require.Nil(t, os.Remove("test.db.corrupt"))
+
+	report, err = Repair("test.db")
+	require.Nil(t, err)
+
+	assert.Equal(t, 9, report.Records)
+	assert.Len(t, report.Skipped, 0)
+	assert.False(t, exists("test.db.corrupt"))
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+}
+
+func TestRepairSegments(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	db, err := Open("test-segments", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), make([]byte, 200))
+		require.Nil(t, err)
+	}
+
+	e := db.lookup([]byte("test-key-10"))
+
+	require.Nil(t, db.Close())
+
+	fd, err := os.OpenFile(segmentPath("test-segments", e.segment), os.O_RDWR, 0644)
+	require.Nil(t, err)
+
+	_, err = fd.WriteAt([]byte("x"), e.offset+header.HeaderSize)
+	require.Nil(t, err)
+
+	require.Nil(t, fd.Close())
+
+	report, err := Repair("test-segments", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	assert.Equal(t, 19, report.Records)
+	assert.Equal(t, []Range{{Segment: e.segment, Offset: e.offset, Size: e.size}}, report.Skipped)
+	assert.Len(t, report.Lost, 1)
+
+	db, err = Open("test-segments", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key-10")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = db.Gets("test-key-11")
+	assert.Nil(t, err)
+
+	require.Nil(t, db.Close())
+}
+
+func TestRepairBatch(t *testing.T) {
+	defer os.Remove("test.db.corrupt")
+
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key-0", []byte("test-0")))
+
+	var b Batch
+
+	for i := 1; i < 4; i++ {
+		b.Set([]byte(fmt.Sprintf("test-key-%d", i)), []byte(fmt.Sprintf("test-%d", i)))
+	}
+
+	require.Nil(t, db.Apply(&b))
+	require.Nil(t, db.Sets("test-key-4", []byte("test-4")))
+	require.Nil(t, db.Sets("test-key-5", []byte("test-5")))
+	require.Nil(t, db.Sets("test-key-6", []byte("test-6")))
+
+	e1 := db.lookup([]byte("test-key-1"))
+	e2 := db.lookup([]byte("test-key-2"))
+	e4 := db.lookup([]byte("test-key-4"))
+	e5 := db.lookup([]byte("test-key-5"))
+
+	require.Nil(t, db.Close())
+
+	fd, err := os.OpenFile("test.db", os.O_RDWR, 0644)
+	require.Nil(t, err)
+
+	// corrupt the value of one record in the batch, and of two consecutive records
+	_, err = fd.WriteAt([]byte("x"), e2.offset+e2.size-1)
+	require.Nil(t, err)
+
+	_, err = fd.WriteAt([]byte("x"), e4.offset+e4.size-1)
+	require.Nil(t, err)
+
+	_, err = fd.WriteAt([]byte("x"), e5.offset+e5.size-1)
+	require.Nil(t, err)
+
+	require.Nil(t, fd.Close())
+
+	report, err := Repair("test.db")
+	require.Nil(t, err)
+
+	assert.Equal(t, 2, report.Records)
+	assert.Equal(t, [][]byte{
+		[]byte("test-key-1"),
+		[]byte("test-key-2"),
+		[]byte("test-key-3"),
+		[]byte("test-key-4"),
+		[]byte("test-key-5"),
+	}, report.Lost)
+	assert.Equal(t, []Range{
+		{Offset: e1.offset, Size: e5.offset + e5.size - e1.offset},
+	}, report.Skipped)
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	for i := 1; i < 6; i++ {
+		_, err = db.Gets(fmt.Sprintf("test-key-%d", i))
+		assert.Equal(t, ErrNotFound, err)
+	}
+
+	data, err := db.Gets("test-key-6")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-6"), data)
+}
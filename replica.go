@@ -0,0 +1,458 @@
+package lunar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+)
+
+const (
+	// HeartbeatInterval the interval a leader reports its position at while no records are written
+	HeartbeatInterval = time.Second
+)
+
+const (
+	// frameRecord a record written by the leader
+	frameRecord byte = iota + 1
+	// frameHeartbeat the position of the leader while no records are written
+	frameHeartbeat
+	// frameReset the position requested by the follower is no longer valid
+	frameReset
+)
+
+const (
+	// frameSize the size of a frame's type, position and the leader's position
+	frameSize = 17
+)
+
+var (
+	replicaMagic = []byte("LUNARRP1")
+	// replicaKey the key that stores the position of the leader that has been applied
+	replicaKey = []byte("\x00lunar\x00replica")
+)
+
+var (
+	// ErrReadOnly the database is a follower and cannot be written to
+	ErrReadOnly = errors.New("database is read only")
+	// ErrNotFollower the database is not a follower
+	ErrNotFollower = errors.New("database is not a follower")
+	// ErrInvalidReplication the replication stream is not valid
+	ErrInvalidReplication = errors.New("invalid replication stream")
+	// errStopped the follower has been closed or promoted
+	errStopped = errors.New("replication stopped")
+)
+
+// ReplicaStatus the replication status of a follower
+type ReplicaStatus struct {
+	Position uint64        // position of the leader that has been applied
+	Leader   uint64        // latest known position of the leader
+	Lag      time.Duration // time since the follower was last caught up with the leader
+	Err      error         // error that stopped replication
+}
+
+// follower the replication state of a follower database
+type follower struct {
+	conn     io.ReadWriter
+	position uint64 // position of the leader that has been applied
+	leader   uint64
+	caught   int64 // unix time in nanoseconds the follower was last caught up
+	stopped  bool  // set when the follower is closed or promoted. db.mu must be held
+	mu       sync.Mutex
+	err      error
+}
+
+// Follower option used when opening the database
+// The database will apply records streamed from a leader over the
+// connection in the order they were written. The database is read
+// only until it is promoted. The leader's position is stored so
+// replication resumes where it left off when the database is reopened
+func Follower(conn io.ReadWriter) func(db *DB) error {
+	return func(db *DB) error {
+		db.follower = &follower{conn: conn}
+		return nil
+	}
+}
+
+// Replicate streams every record written after the position requested
+// by a follower over the connection, until the context is cancelled or
+// the connection fails. Replication will stop if the follower's position
+// is invalidated by compacting a single data file, and the follower must
+// be restored from a backup
+func (db *DB) Replicate(ctx context.Context, conn io.ReadWriter) error {
+	hs := make([]byte, len(replicaMagic)+8)
+
+	_, err := io.ReadFull(conn, hs)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(hs[:len(replicaMagic)], replicaMagic) {
+		return ErrInvalidReplication
+	}
+
+	bw := bufio.NewWriter(conn)
+
+	c, err := db.Changes(binary.LittleEndian.Uint64(hs[len(replicaMagic):]))
+	if err != nil {
+		return db.reset(bw, err)
+	}
+
+	for {
+		wctx, cancel := context.WithTimeout(ctx, HeartbeatInterval)
+		e, err := c.Next(wctx)
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err == context.DeadlineExceeded:
+			err = writeFrame(bw, frameHeartbeat, c.Position(), c.Position())
+			if err != nil {
+				return err
+			}
+
+			err = bw.Flush()
+		case err == ErrInvalidPosition:
+			return db.reset(bw, err)
+		case err != nil:
+			return err
+		default:
+			err = writeFrame(bw, frameRecord, e.Position, db.head())
+			if err != nil {
+				return err
+			}
+
+			_, err = bw.Write(e.record)
+
+			// flush once all of the records that have been read are written
+			if err == nil && len(c.pending) < 1 {
+				err = bw.Flush()
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// reset tells the follower that its position is no longer valid
+func (db *DB) reset(bw *bufio.Writer, reason error) error {
+	err := writeFrame(bw, frameReset, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return reason
+}
+
+// writeFrame writes a frame's type, the position of the
+// leader following the frame and the leader's latest position
+func writeFrame(w io.Writer, ft byte, pos, head uint64) error {
+	frame := make([]byte, frameSize)
+	frame[0] = ft
+	binary.LittleEndian.PutUint64(frame[1:], pos)
+	binary.LittleEndian.PutUint64(frame[9:], head)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// head returns the position of the end of the active segment
+func (db *DB) head() uint64 {
+	s := db.current()
+
+	return db.position(s.active, s.tables[s.active].Position())
+}
+
+// Replica returns the replication status of a follower
+func (db *DB) Replica() (ReplicaStatus, error) {
+	db.mu.RLock()
+	f := db.follower
+	db.mu.RUnlock()
+
+	if f == nil {
+		return ReplicaStatus{}, ErrNotFollower
+	}
+
+	status := ReplicaStatus{
+		Position: atomic.LoadUint64(&f.position),
+		Leader:   atomic.LoadUint64(&f.leader),
+	}
+
+	if status.Position < status.Leader {
+		status.Lag = time.Since(time.Unix(0, atomic.LoadInt64(&f.caught)))
+	}
+
+	f.mu.Lock()
+	status.Err = f.err
+	f.mu.Unlock()
+
+	return status, nil
+}
+
+// Promote stops replicating from the leader and makes the database
+// writable. The connection is closed if it implements io.Closer
+func (db *DB) Promote() error {
+	db.mu.RLock()
+	f := db.follower
+	db.mu.RUnlock()
+
+	if f == nil {
+		return ErrNotFollower
+	}
+
+	err := db.unfollow(f)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.follower = nil
+
+	e := db.lookup(replicaKey)
+	if e == nil || !e.live() {
+		return nil
+	}
+
+	var h header.Header
+
+	// deleted records are marked with an xmax
+	h.SetXmax(1)
+
+	_, _, err = db.insert(replicaKey, record(&h, replicaKey, nil))
+
+	return err
+}
+
+// writable returns ErrReadOnly if the database is a follower. db.mu must be held
+func (db *DB) writable() error {
+	if db.follower != nil {
+		return ErrReadOnly
+	}
+
+	return nil
+}
+
+// follow requests records from the leader, starting
+// from the last position that was applied
+func (db *DB) follow() error {
+	f := db.follower
+
+	db.mu.RLock()
+	pos, _, err := db.get(replicaKey)
+	db.mu.RUnlock()
+
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if len(pos) != 8 {
+		pos = make([]byte, 8)
+	}
+
+	f.position = binary.LittleEndian.Uint64(pos)
+	f.leader = f.position
+	f.caught = time.Now().UnixNano()
+
+	_, err = f.conn.Write(append(append([]byte{}, replicaMagic...), pos...))
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := db.receive(f)
+		if err != errStopped {
+			db.logger.Error("replication stopped", "path", db.path, "error", err)
+
+			f.mu.Lock()
+			f.err = err
+			f.mu.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// receive applies records sent by the leader until the
+// connection fails or the follower is stopped
+func (db *DB) receive(f *follower) error {
+	br := bufio.NewReader(f.conn)
+
+	frame := make([]byte, frameSize)
+	hd := make([]byte, header.HeaderSize)
+
+	var keys, records [][]byte
+
+	for {
+		_, err := io.ReadFull(br, frame)
+		if err != nil {
+			return err
+		}
+
+		pos := binary.LittleEndian.Uint64(frame[1:])
+		atomic.StoreUint64(&f.leader, binary.LittleEndian.Uint64(frame[9:]))
+
+		switch frame[0] {
+		case frameHeartbeat:
+			if len(records) > 0 {
+				return ErrInvalidReplication
+			}
+
+			err = db.applied(f, pos)
+			if err != nil {
+				return err
+			}
+
+			continue
+		case frameReset:
+			return ErrInvalidPosition
+		case frameRecord:
+		default:
+			return ErrInvalidReplication
+		}
+
+		_, err = io.ReadFull(br, hd)
+		if err != nil {
+			return err
+		}
+
+		h := header.Deserialize(hd)
+
+		if empty(hd) || !sane(h, 0, table.MaxStep) {
+			return ErrInvalidReplication
+		}
+
+		data := make([]byte, h.TotalSize())
+		copy(data, hd)
+
+		_, err = io.ReadFull(br, data[header.HeaderSize:])
+		if err != nil {
+			return err
+		}
+
+		if !header.Valid(data) {
+			return ErrCorrupt
+		}
+
+		// records from a batch are applied together once the batch is complete
+		keys = append(keys, data[header.HeaderSize:h.DataOffset()])
+		records = append(records, data)
+
+		if h.Xmin() > 0 {
+			continue
+		}
+
+		err = db.apply(f, keys, records, pos)
+		if err != nil {
+			return err
+		}
+
+		keys = keys[:0]
+		records = records[:0]
+	}
+}
+
+// apply writes records received from the leader and the position
+// of the leader that follows them, so they are stored atomically
+func (db *DB) apply(f *follower, keys, records [][]byte, pos uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if f.stopped {
+		return errStopped
+	}
+
+	keys = append(keys, replicaKey)
+	records = append(records, positionRecord(pos))
+
+	// the records are written as a single batch
+	for i := range records {
+		setXmin(records[i], uint64(len(records)-i-1))
+	}
+
+	err := db.insertAll(keys, records)
+	if err != nil {
+		return err
+	}
+
+	db.advance(f, pos)
+
+	return nil
+}
+
+// applied stores the position of the leader
+// once all records before it have been applied
+func (db *DB) applied(f *follower, pos uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if f.stopped {
+		return errStopped
+	}
+
+	if pos != atomic.LoadUint64(&f.position) {
+		_, _, err := db.insert(replicaKey, positionRecord(pos))
+		if err != nil {
+			return err
+		}
+	}
+
+	db.advance(f, pos)
+
+	return nil
+}
+
+// advance updates the position of the leader that has been applied. db.mu must be held
+func (db *DB) advance(f *follower, pos uint64) {
+	atomic.StoreUint64(&f.position, pos)
+
+	if pos >= atomic.LoadUint64(&f.leader) {
+		atomic.StoreInt64(&f.caught, time.Now().UnixNano())
+	}
+}
+
+// positionRecord returns a record storing the position of the leader
+func positionRecord(pos uint64) []byte {
+	var h header.Header
+
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, pos)
+
+	return record(&h, replicaKey, value)
+}
+
+// unfollow stops applying records from the leader and closes the connection
+func (db *DB) unfollow(f *follower) error {
+	db.mu.Lock()
+	stopped := f.stopped
+	f.stopped = true
+	db.mu.Unlock()
+
+	if stopped {
+		return nil
+	}
+
+	if c, ok := f.conn.(io.Closer); ok {
+		c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package lunar
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplication(t *testing.T) {
+	defer os.Remove("test-follower.db")
+	defer os.Remove("test-follower.db.hint")
+
+	leader, err := Open("test.db")
+	defer cleanup(leader)
+
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	replicate := func() net.Conn {
+		lc, fc := net.Pipe()
+		go leader.Replicate(ctx, lc)
+		return fc
+	}
+
+	require.Nil(t, leader.Sets("test-key-1", []byte("test-1")))
+	require.Nil(t, leader.Sets("test-key-2", []byte("test-2")))
+	require.Nil(t, leader.Deletes("test-key-2"))
+
+	follower, err := Open("test-follower.db", Follower(replicate()))
+	require.Nil(t, err)
+
+	eventually(t, func() bool {
+		value, err := follower.Gets("test-key-1")
+		return err == nil && string(value) == "test-1"
+	})
+
+	_, err = follower.Gets("test-key-2")
+	assert.Equal(t, ErrNotFound, err)
+
+	// followers are read only
+	assert.Equal(t, ErrReadOnly, follower.Sets("test-key-3", []byte("test-3")))
+
+	var b Batch
+
+	for i := 0; i < 10; i++ {
+		b.Set([]byte(fmt.Sprintf("test-batch-%d", i)), []byte("test"))
+	}
+
+	require.Nil(t, leader.Apply(&b))
+
+	eventually(t, func() bool {
+		_, err := follower.Gets("test-batch-9")
+		return err == nil
+	})
+
+	status, err := follower.Replica()
+	require.Nil(t, err)
+	assert.Nil(t, status.Err)
+	assert.Equal(t, leader.head(), status.Position)
+	assert.Equal(t, time.Duration(0), status.Lag)
+
+	// resume replication after reopening
+	require.Nil(t, follower.Close())
+	require.Nil(t, leader.Sets("test-key-3", []byte("test-3")))
+
+	follower, err = Open("test-follower.db", Follower(replicate()))
+	require.Nil(t, err)
+
+	eventually(t, func() bool {
+		value, err := follower.Gets("test-key-3")
+		return err == nil && string(value) == "test-3"
+	})
+
+	// the leader's position is stored with the records
+	assert.Equal(t, 13, follower.Stats().Keys)
+
+	position, err := follower.Get(replicaKey)
+	require.Nil(t, err)
+	assert.Equal(t, leader.head(), binary.LittleEndian.Uint64(position))
+
+	// promote the follower
+	require.Nil(t, follower.Promote())
+	require.Nil(t, follower.Sets("test-key-4", []byte("test-4")))
+
+	_, err = follower.Replica()
+	assert.Equal(t, ErrNotFollower, err)
+
+	require.Nil(t, follower.Close())
+}
+
+func TestReplicationInvalidPosition(t *testing.T) {
+	defer os.Remove("test-follower.db")
+	defer os.Remove("test-follower.db.hint")
+
+	leader, err := Open("test.db")
+	defer cleanup(leader)
+
+	require.Nil(t, err)
+
+	follower, err := Open("test-follower.db")
+	require.Nil(t, err)
+
+	_, _, err = follower.insert(replicaKey, positionRecord(1))
+	require.Nil(t, err)
+	require.Nil(t, follower.Close())
+
+	lc, fc := net.Pipe()
+
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- leader.Replicate(context.Background(), lc)
+	}()
+
+	follower, err = Open("test-follower.db", Follower(fc))
+	require.Nil(t, err)
+
+	defer follower.Close()
+
+	assert.Equal(t, ErrInvalidPosition, <-errs)
+
+	eventually(t, func() bool {
+		status, err := follower.Replica()
+		return err == nil && status.Err == ErrInvalidPosition
+	})
+}
+
+// eventually waits up to a second for a condition to be true
+func eventually(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,281 @@
+package lunar
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/rad"
+)
+
+var (
+	// ErrIndexNotFound the index does not exist
+	ErrIndexNotFound = errors.New("index not found")
+	// ErrIndexExists an index with the same name already exists
+	ErrIndexExists = errors.New("index already exists")
+)
+
+// IndexFunc returns the terms a key is indexed by, derived from its key and value
+type IndexFunc func(key, value []byte) [][]byte
+
+// secondary an index from terms to the keys they were derived from
+type secondary struct {
+	mu      sync.RWMutex
+	extract IndexFunc
+	terms   *rad.Radix          // term to a set of keys
+	keys    map[string][][]byte // key to the terms it is indexed by
+}
+
+// CreateIndex creates an index of the terms returned by extract for
+// every key. The index is built from the current keys in the database,
+// and is updated as keys are written or deleted. Indexes are not
+// persisted, and must be created every time the database is opened.
+// Keys stored in buckets are not indexed
+func (db *DB) CreateIndex(name string, extract IndexFunc) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.indexes[name] != nil {
+		return ErrIndexExists
+	}
+
+	s := &secondary{
+		extract: extract,
+		terms:   rad.New(),
+		keys:    make(map[string][][]byte),
+	}
+
+	var err error
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		if err != nil || bytes.HasPrefix(key, internalPrefix) {
+			return
+		}
+
+		e, ok := v.(*entry)
+		if !ok || !e.live() {
+			return
+		}
+
+		var value []byte
+
+		value, _, err = db.get(key)
+		if err != nil {
+			return
+		}
+
+		s.update(key, extract(key, value))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if db.indexes == nil {
+		db.indexes = make(map[string]*secondary)
+	}
+
+	db.indexes[name] = s
+
+	return nil
+}
+
+// DropIndex removes an index
+func (db *DB) DropIndex(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.indexes[name] == nil {
+		return ErrIndexNotFound
+	}
+
+	delete(db.indexes, name)
+
+	return nil
+}
+
+// QueryIndex returns the keys indexed by a term, in key order
+func (db *DB) QueryIndex(name string, term []byte) ([][]byte, error) {
+	s := db.secondary(name)
+	if s == nil {
+		return nil, ErrIndexNotFound
+	}
+
+	if len(term) < 1 {
+		return nil, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set, _ := s.terms.Lookup(term).(map[string]struct{})
+
+	return db.liveKeys(set, make(map[string]bool)), nil
+}
+
+// QueryIndexPrefix returns the keys indexed by terms with the
+// given prefix, in the order of their terms and then their keys
+func (db *DB) QueryIndexPrefix(name string, prefix []byte) ([][]byte, error) {
+	return db.query(name, prefix, nil)
+}
+
+// QueryIndexRange returns the keys indexed by terms from start up to, but not
+// including end, in the order of their terms and then their keys. A nil end
+// includes all terms after start
+func (db *DB) QueryIndexRange(name string, start, end []byte) ([][]byte, error) {
+	return db.query(name, nil, func(t []byte) bool {
+		return bytes.Compare(t, start) >= 0 && (end == nil || bytes.Compare(t, end) < 0)
+	})
+}
+
+// secondary returns an index by name
+func (db *DB) secondary(name string) *secondary {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.indexes[name]
+}
+
+// query returns the live keys indexed by every term with the prefix that
+// matches, or every term with the prefix if match is nil
+func (db *DB) query(name string, prefix []byte, match func(term []byte) bool) ([][]byte, error) {
+	s := db.secondary(name)
+	if s == nil {
+		return nil, ErrIndexNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys [][]byte
+
+	seen := make(map[string]bool)
+
+	s.terms.Iterate(prefix, func(term []byte, v interface{}) {
+		set, ok := v.(map[string]struct{})
+		if !ok || match != nil && !match(term) {
+			return
+		}
+
+		keys = append(keys, db.liveKeys(set, seen)...)
+	})
+
+	return keys, nil
+}
+
+// liveKeys returns the keys in a set that are live and have not been seen, in key order
+func (db *DB) liveKeys(set map[string]struct{}, seen map[string]bool) [][]byte {
+	var matched [][]byte
+
+	for key := range set {
+		// a key may have expired or been removed from the index by a dropped bucket
+		e := db.lookup([]byte(key))
+		if e == nil || !e.live() || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		matched = append(matched, []byte(key))
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return bytes.Compare(matched[i], matched[j]) < 0
+	})
+
+	return matched
+}
+
+// reindex updates the secondary indexes with a record that has been
+// written. If the record's value cannot be read, the key is removed from
+// the indexes, as the record has already been written. db.mu must be held,
+// along with the key's lock or db.mu held exclusively
+func (db *DB) reindex(key, data []byte) {
+	if len(db.indexes) < 1 || bytes.HasPrefix(key, internalPrefix) {
+		return
+	}
+
+	h := header.Deserialize(data)
+
+	if h.Xmax() > 0 {
+		for _, s := range db.indexes {
+			s.update(key, nil)
+		}
+
+		return
+	}
+
+	var err error
+
+	value := data[h.DataOffset():]
+
+	switch {
+	case h.Pointer():
+		value, err = db.readValue(value)
+	case h.Merge():
+		value, err = db.merged(key, h, data)
+	}
+
+	if err != nil {
+		db.logger.Error("failed to index key", "key", key, "error", err)
+
+		for _, s := range db.indexes {
+			s.update(key, nil)
+		}
+
+		return
+	}
+
+	for _, s := range db.indexes {
+		s.update(key, s.extract(key, value))
+	}
+}
+
+// update replaces the terms a key is indexed by
+func (s *secondary) update(key []byte, terms [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := string(key)
+
+	for _, term := range s.keys[k] {
+		set, ok := s.terms.Lookup(term).(map[string]struct{})
+		if !ok {
+			continue
+		}
+
+		delete(set, k)
+
+		if len(set) < 1 {
+			s.terms.MustInsert(term, nil)
+		}
+	}
+
+	delete(s.keys, k)
+
+	var indexed [][]byte
+
+	for _, term := range terms {
+		if len(term) < 1 {
+			continue
+		}
+
+		// the extracted term may be modified by the caller
+		term = append([]byte{}, term...)
+
+		set, ok := s.terms.Lookup(term).(map[string]struct{})
+		if !ok {
+			set = make(map[string]struct{})
+			s.terms.MustInsert(term, set)
+		}
+
+		set[k] = struct{}{}
+
+		indexed = append(indexed, term)
+	}
+
+	if len(indexed) > 0 {
+		s.keys[k] = indexed
+	}
+}
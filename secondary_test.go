@@ -0,0 +1,116 @@
+package lunar
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCity indexes values of the form "name:city" by city
+func testCity(key, value []byte) [][]byte {
+	i := bytes.IndexByte(value, ':')
+	if i < 0 {
+		return nil
+	}
+
+	return [][]byte{value[i+1:]}
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("user-1", []byte("alice:london")))
+	require.Nil(t, db.Sets("user-2", []byte("bob:paris")))
+
+	require.Nil(t, db.CreateIndex("city", testCity))
+	assert.Equal(t, ErrIndexExists, db.CreateIndex("city", testCity))
+
+	// existing keys are indexed when the index is created
+	keys, err := db.QueryIndex("city", []byte("london"))
+	require.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-1")}, keys)
+
+	// the index is updated by writes
+	require.Nil(t, db.Sets("user-3", []byte("carol:london")))
+	require.Nil(t, db.Sets("user-1", []byte("alice:berlin")))
+
+	var b Batch
+	b.Set([]byte("user-4"), []byte("dave:lisbon"))
+	b.Delete([]byte("user-2"))
+	require.Nil(t, db.Apply(&b))
+
+	keys, err = db.QueryIndex("city", []byte("london"))
+	require.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-3")}, keys)
+
+	keys, err = db.QueryIndex("city", []byte("paris"))
+	require.Nil(t, err)
+	assert.Len(t, keys, 0)
+
+	// terms must match exactly
+	keys, err = db.QueryIndex("city", []byte("lon"))
+	require.Nil(t, err)
+	assert.Len(t, keys, 0)
+
+	keys, err = db.QueryIndexPrefix("city", []byte("l"))
+	require.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-4"), []byte("user-3")}, keys)
+
+	keys, err = db.QueryIndexRange("city", []byte("berlin"), []byte("london"))
+	require.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-1"), []byte("user-4")}, keys)
+
+	keys, err = db.QueryIndexRange("city", []byte("lisbon"), nil)
+	require.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-4"), []byte("user-3")}, keys)
+
+	require.Nil(t, db.DropIndex("city"))
+
+	_, err = db.QueryIndex("city", []byte("london"))
+	assert.Equal(t, ErrIndexNotFound, err)
+}
+
+func TestSecondaryIndexConcurrent(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	// a slow extractor widens the window between writing a value and indexing it
+	require.Nil(t, db.CreateIndex("city", func(key, value []byte) [][]byte {
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		return testCity(key, value)
+	}))
+
+	for round := 0; round < 50; round++ {
+		var wg sync.WaitGroup
+
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+				assert.Nil(t, db.Sets("user-1", []byte(fmt.Sprintf("alice:city-%d", i))))
+			}(i)
+		}
+
+		wg.Wait()
+
+		// the index matches the value that was written last
+		value, err := db.Gets("user-1")
+		require.Nil(t, err)
+
+		keys, err := db.QueryIndex("city", value[len("alice:"):])
+		require.Nil(t, err)
+		require.Equal(t, [][]byte{[]byte("user-1")}, keys, "round %d", round)
+	}
+}
@@ -0,0 +1,282 @@
+package lunar
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/purehyperbole/lunar/table"
+)
+
+const (
+	segmentExt = ".data"
+	// segmentBits the number of bits of a position used to store an offset within a segment
+	segmentBits = 40
+	// MaxSegmentSize the maximum size of a segment
+	MaxSegmentSize = 1 << segmentBits
+)
+
+// segments an immutable view of the data tables
+// that make up the database. it is replaced as
+// a whole when a segment is added or removed
+type segments struct {
+	active uint32
+	tables map[uint32]*table.Table
+}
+
+// with returns a copy of the segments with an additional table
+func (s *segments) with(id uint32, t *table.Table) *segments {
+	ns := segments{
+		active: s.active,
+		tables: make(map[uint32]*table.Table, len(s.tables)+1),
+	}
+
+	for sid, st := range s.tables {
+		ns.tables[sid] = st
+	}
+
+	ns.tables[id] = t
+
+	if id > ns.active {
+		ns.active = id
+	}
+
+	return &ns
+}
+
+// without returns a copy of the segments with a table removed
+func (s *segments) without(id uint32) *segments {
+	ns := segments{
+		active: s.active,
+		tables: make(map[uint32]*table.Table, len(s.tables)),
+	}
+
+	for sid, st := range s.tables {
+		if sid != id {
+			ns.tables[sid] = st
+		}
+	}
+
+	return &ns
+}
+
+// ids returns all segment ids in ascending order
+func (s *segments) ids() []uint32 {
+	ids := make([]uint32, 0, len(s.tables))
+
+	for id := range s.tables {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+func (db *DB) current() *segments {
+	return (*segments)(atomic.LoadPointer(&db.segments))
+}
+
+func (db *DB) store(s *segments) {
+	atomic.StorePointer(&db.segments, unsafe.Pointer(s))
+}
+
+// table returns the data table for a given segment
+func (db *DB) table(id uint32) *table.Table {
+	return db.current().tables[id]
+}
+
+// active returns the data table new records are being written to
+func (db *DB) active() *table.Table {
+	s := db.current()
+	return s.tables[s.active]
+}
+
+// segmented returns true if the database is made up of multiple segment files
+func (db *DB) segmented() bool {
+	return db.segmentSize > 0
+}
+
+// write appends data to the active segment, rotating
+// to a new segment if the active segment is full
+func (db *DB) write(data []byte) (uint32, int64, error) {
+	for {
+		s := db.current()
+
+		db.writes.RLock()
+		off, err := s.tables[s.active].Write(data)
+		db.writes.RUnlock()
+
+		if err == nil {
+			db.notify()
+		}
+
+		if err != table.ErrTableFull {
+			return s.active, off, err
+		}
+
+		err = db.rotate(s.active)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
+
+// rotate seals the full segment and creates a new active segment
+func (db *DB) rotate(full uint32) error {
+	db.rotation.Lock()
+	defer db.rotation.Unlock()
+
+	s := db.current()
+
+	// another writer has already rotated the segment
+	if s.active != full {
+		return nil
+	}
+
+	return db.create(s.active + 1)
+}
+
+// create opens a new segment and makes it the active segment.
+// must be called with the rotation lock held
+func (db *DB) create(id uint32) error {
+	err := db.removeHint(id)
+	if err != nil {
+		return err
+	}
+
+	t, err := db.openTable(segmentPath(db.path, id))
+	if err != nil {
+		return err
+	}
+
+	t.SetLimit(db.segmentSize)
+
+	db.store(db.current().with(id, t))
+
+	return nil
+}
+
+// freeze returns the data files and the position each of them had reached,
+// once every record before those positions has been written. The data files
+// are pinned, so the records read from them remain valid if they are resized,
+// until release is called. db.snapshot must be held, so the data files can be
+// read up to their positions without holding db.mu
+func (db *DB) freeze() (s *segments, ends map[uint32]int64, release func()) {
+	// wait for any batch being written, so none are partially included
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// wait for records that have been reserved but not yet copied to finish
+	db.writes.Lock()
+	defer db.writes.Unlock()
+
+	s = db.current()
+
+	ends = make(map[uint32]int64, len(s.tables))
+
+	for id, t := range s.tables {
+		t.Pin()
+		ends[id] = t.Position()
+	}
+
+	return s, ends, func() {
+		for _, t := range s.tables {
+			t.Unpin()
+		}
+	}
+}
+
+// remove closes a sealed segment and deletes its data file
+func (db *DB) remove(id uint32) error {
+	db.rotation.Lock()
+
+	s := db.current()
+
+	if id == s.active {
+		db.rotation.Unlock()
+		return fmt.Errorf("cannot remove active segment %d", id)
+	}
+
+	t := s.tables[id]
+	db.store(s.without(id))
+
+	db.rotation.Unlock()
+
+	if t == nil {
+		return nil
+	}
+
+	err := t.Close()
+	if err != nil {
+		return err
+	}
+
+	err = db.removeHint(id)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(db.dataPath(id))
+}
+
+// position encodes a segment and an offset within it as a single
+// value, which increases as records are written to the database
+func (db *DB) position(id uint32, offset int64) uint64 {
+	if !db.segmented() {
+		return uint64(offset)
+	}
+
+	return uint64(id)<<segmentBits | uint64(offset)
+}
+
+// location decodes a position into a segment and an offset
+func (db *DB) location(pos uint64) (uint32, int64) {
+	if !db.segmented() {
+		return 0, int64(pos)
+	}
+
+	return uint32(pos >> segmentBits), int64(pos & (MaxSegmentSize - 1))
+}
+
+// segmentPath returns the path of a segment's data file
+func segmentPath(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+// segmentIDs lists the ids of all segment files in a directory in ascending order
+func segmentIDs(dir string) ([]uint32, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint32
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentExt), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, uint32(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids, nil
+}
@@ -0,0 +1,503 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+)
+
+const (
+	// DefaultMaxValueSize the default maximum size of a request body
+	DefaultMaxValueSize = 64 << 20
+	// DefaultBufferSize the default size of a value that is read into memory
+	DefaultBufferSize = 1 << 20
+	// DefaultLimit the default number of keys returned by a list request
+	DefaultLimit = 100
+	// MaxLimit the maximum number of keys returned by a list request
+	MaxLimit = 10000
+)
+
+var (
+	errTooLarge         = errors.New("request body too large")
+	errLengthRequired   = errors.New("content length required")
+	errPrecondition     = errors.New("precondition failed")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errStop             = errors.New("stop iteration")
+)
+
+// Handler serves a database over HTTP
+type Handler struct {
+	db *lunar.DB
+	// MaxValueSize the maximum size of a value or batch request body
+	MaxValueSize int64
+	// BufferSize the maximum size of a value that is read into memory.
+	// Larger values are streamed to and from their own value file
+	BufferSize int64
+}
+
+// operation a write in a batch request
+type operation struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// list the response to a list request
+type list struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// stats the response to a stats request
+type stats struct {
+	Segments int   `json:"segments"`
+	Keys     int   `json:"keys"`
+	Deleted  int   `json:"deleted"`
+	Size     int64 `json:"size"`
+	Used     int64 `json:"used"`
+	Live     int64 `json:"live"`
+}
+
+// NewHandler creates a handler that serves a database. The handler can
+// be mounted under a path prefix with http.StripPrefix
+func NewHandler(db *lunar.DB) *Handler {
+	return &Handler{
+		db:           db,
+		MaxValueSize: DefaultMaxValueSize,
+		BufferSize:   DefaultBufferSize,
+	}
+}
+
+// ServeHTTP routes a request to the handler for its path
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+
+	switch {
+	case strings.HasPrefix(path, "/keys/"):
+		key, err := url.PathUnescape(strings.TrimPrefix(path, "/keys/"))
+		if err != nil || key == "" {
+			h.error(w, http.StatusBadRequest, errors.New("invalid key"))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.get(w, r, []byte(key))
+		case http.MethodPut:
+			h.put(w, r, []byte(key))
+		case http.MethodDelete:
+			h.delete(w, r, []byte(key))
+		default:
+			h.methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+		}
+	case path == "/keys":
+		if r.Method != http.MethodGet {
+			h.methodNotAllowed(w, "GET")
+			return
+		}
+
+		h.list(w, r)
+	case path == "/batch":
+		if r.Method != http.MethodPost {
+			h.methodNotAllowed(w, "POST")
+			return
+		}
+
+		h.batch(w, r)
+	case path == "/stats":
+		if r.Method != http.MethodGet {
+			h.methodNotAllowed(w, "GET")
+			return
+		}
+
+		h.stats(w, r)
+	case path == "/compact":
+		if r.Method != http.MethodPost {
+			h.methodNotAllowed(w, "POST")
+			return
+		}
+
+		h.compact(w, r)
+	default:
+		h.error(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// get writes a key's value. Range and conditional requests
+// are handled using the key's version as its ETag
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, key []byte) {
+	value, version, err := h.db.GetReaderVersion(key)
+	if err != nil {
+		h.error(w, status(err), err)
+		return
+	}
+
+	defer value.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", etag(version))
+
+	expires, err := h.db.Expires(key)
+	if err == nil && !expires.IsZero() {
+		w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+	}
+
+	rs, ok := value.(io.ReadSeeker)
+	if !ok {
+		io.Copy(w, value)
+		return
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, rs)
+}
+
+// put sets a key's value. If-Match and If-None-Match headers
+// are used to compare and swap the key's current version
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, key []byte) {
+	var ttl time.Duration
+
+	if r.URL.Query().Get("ttl") != "" {
+		var err error
+
+		ttl, err = time.ParseDuration(r.URL.Query().Get("ttl"))
+		if err != nil || ttl <= 0 {
+			h.error(w, http.StatusBadRequest, errors.New("invalid ttl"))
+			return
+		}
+	}
+
+	version, conditional, err := h.precondition(r, key)
+	if err != nil {
+		h.error(w, status(err), err)
+		return
+	}
+
+	var updated uint64
+
+	if r.ContentLength > h.BufferSize {
+		updated, err = h.stream(r, key, ttl, version, conditional)
+	} else {
+		updated, err = h.write(r, key, ttl, version, conditional)
+	}
+
+	if err != nil {
+		h.error(w, status(err), err)
+		return
+	}
+
+	if !conditional {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("ETag", etag(updated))
+
+	if version == 0 {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// write reads a request body into memory and sets it as a key's value
+func (h *Handler) write(r *http.Request, key []byte, ttl time.Duration, version uint64, conditional bool) (uint64, error) {
+	// bodies of an unknown length are only read if they fit in memory
+	limit := h.MaxValueSize
+
+	if r.ContentLength < 0 && h.BufferSize < limit {
+		limit = h.BufferSize
+	}
+
+	value, err := h.readLimit(r, limit)
+	if err == errTooLarge && limit < h.MaxValueSize {
+		return 0, errLengthRequired
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case conditional && ttl > 0:
+		return h.db.CompareAndSwapTTL(key, value, ttl, version)
+	case conditional:
+		return h.db.CompareAndSwap(key, value, version)
+	case ttl > 0:
+		return 0, h.db.SetTTL(key, value, ttl)
+	}
+
+	return 0, h.db.Set(key, value)
+}
+
+// stream streams a request body to a value file and sets it as a key's value
+func (h *Handler) stream(r *http.Request, key []byte, ttl time.Duration, version uint64, conditional bool) (uint64, error) {
+	if r.ContentLength > h.MaxValueSize {
+		return 0, errTooLarge
+	}
+
+	size := r.ContentLength
+
+	switch {
+	case conditional && ttl > 0:
+		return h.db.CompareAndSwapReaderTTL(key, r.Body, size, ttl, version)
+	case conditional:
+		return h.db.CompareAndSwapReader(key, r.Body, size, version)
+	case ttl > 0:
+		return 0, h.db.SetReaderTTL(key, r.Body, size, ttl)
+	}
+
+	return 0, h.db.SetReader(key, r.Body, size)
+}
+
+// delete deletes a key, comparing its version if an If-Match header is given
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, key []byte) {
+	version, conditional, err := h.precondition(r, key)
+	if err != nil {
+		h.error(w, status(err), err)
+		return
+	}
+
+	if conditional {
+		err = h.db.CompareAndDelete(key, version)
+	} else {
+		err = h.db.Delete(key)
+	}
+
+	if err != nil {
+		h.error(w, status(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list writes the keys with a given prefix in key order. Up to limit keys
+// are returned, starting after the key given by after. If there are more
+// keys, next contains the value to use for after in the following request
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit := DefaultLimit
+
+	if q.Get("limit") != "" {
+		var err error
+
+		limit, err = strconv.Atoi(q.Get("limit"))
+		if err != nil || limit < 1 || limit > MaxLimit {
+			h.error(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+	}
+
+	after := []byte(q.Get("after"))
+	resp := list{Keys: []string{}}
+
+	err := h.db.Scan([]byte(q.Get("prefix")), func(key, value []byte) error {
+		if len(after) > 0 && bytes.Compare(key, after) <= 0 {
+			return nil
+		}
+
+		if len(resp.Keys) == limit {
+			resp.Next = resp.Keys[len(resp.Keys)-1]
+			return errStop
+		}
+
+		resp.Keys = append(resp.Keys, string(key))
+
+		return nil
+	})
+
+	if err != nil && err != errStop {
+		h.error(w, status(err), err)
+		return
+	}
+
+	h.json(w, http.StatusOK, resp)
+}
+
+// batch applies a list of set and delete operations atomically
+func (h *Handler) batch(w http.ResponseWriter, r *http.Request) {
+	body, err := h.read(r)
+	if err != nil {
+		h.error(w, status(err), err)
+		return
+	}
+
+	var ops []operation
+
+	err = json.Unmarshal(body, &ops)
+	if err != nil {
+		h.error(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var b lunar.Batch
+
+	for i, op := range ops {
+		if op.Key == "" {
+			h.error(w, http.StatusBadRequest, fmt.Errorf("operation %d: invalid key", i))
+			return
+		}
+
+		switch op.Op {
+		case "set":
+			if op.TTL == "" {
+				b.Set([]byte(op.Key), []byte(op.Value))
+				continue
+			}
+
+			ttl, err := time.ParseDuration(op.TTL)
+			if err != nil || ttl <= 0 {
+				h.error(w, http.StatusBadRequest, fmt.Errorf("operation %d: invalid ttl", i))
+				return
+			}
+
+			b.SetTTL([]byte(op.Key), []byte(op.Value), ttl)
+		case "delete":
+			b.Delete([]byte(op.Key))
+		default:
+			h.error(w, http.StatusBadRequest, fmt.Errorf("operation %d: invalid op %q", i, op.Op))
+			return
+		}
+	}
+
+	err = h.db.Apply(&b)
+	if err != nil {
+		h.error(w, status(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	s := h.db.Stats()
+
+	h.json(w, http.StatusOK, stats{
+		Segments: s.Segments,
+		Keys:     s.Keys,
+		Deleted:  s.Deleted,
+		Size:     s.Size,
+		Used:     s.Used,
+		Live:     s.Live,
+	})
+}
+
+func (h *Handler) compact(w http.ResponseWriter, r *http.Request) {
+	err := h.db.Compact()
+	if err != nil {
+		h.error(w, status(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// precondition returns the version a write should be compared
+// against, and whether the write is conditional
+func (h *Handler) precondition(r *http.Request, key []byte) (uint64, bool, error) {
+	if match := r.Header.Get("If-Match"); match != "" {
+		if match != "*" {
+			version, err := parseETag(match)
+			return version, true, err
+		}
+
+		version, err := h.db.Version(key)
+		if err == lunar.ErrNotFound {
+			return 0, true, errPrecondition
+		}
+
+		return version, true, err
+	}
+
+	if r.Header.Get("If-None-Match") == "*" {
+		return 0, true, nil
+	}
+
+	return 0, false, nil
+}
+
+// read reads a request body, up to the maximum value size
+func (h *Handler) read(r *http.Request) ([]byte, error) {
+	return h.readLimit(r, h.MaxValueSize)
+}
+
+// readLimit reads a request body, up to the given size
+func (h *Handler) readLimit(r *http.Request, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, errTooLarge
+	}
+
+	return data, nil
+}
+
+func (h *Handler) json(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (h *Handler) error(w http.ResponseWriter, code int, err error) {
+	h.json(w, code, map[string]string{"error": err.Error()})
+}
+
+func (h *Handler) methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	h.error(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+}
+
+// status returns the http status code for an error
+func status(err error) int {
+	switch err {
+	case lunar.ErrNotFound:
+		return http.StatusNotFound
+	case lunar.ErrVersionMismatch, errPrecondition:
+		return http.StatusPreconditionFailed
+	case errTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case errLengthRequired:
+		return http.StatusLengthRequired
+	case io.ErrUnexpectedEOF:
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// etag formats a version as an ETag
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 16) + `"`
+}
+
+// parseETag parses a version from an ETag
+func parseETag(tag string) (uint64, error) {
+	tag = strings.TrimSpace(tag)
+
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, errPrecondition
+	}
+
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 16, 64)
+	if err != nil {
+		return 0, errPrecondition
+	}
+
+	return version, nil
+}
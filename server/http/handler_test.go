@@ -0,0 +1,225 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/purehyperbole/lunar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testServer(t *testing.T) (*httptest.Server, func()) {
+	db, err := lunar.Open("test.db")
+	require.Nil(t, err)
+
+	s := httptest.NewServer(NewHandler(db))
+
+	return s, func() {
+		s.Close()
+		db.Close()
+		os.Remove("test.db")
+		os.Remove("test.db.hint")
+		os.RemoveAll("test.db.values")
+	}
+}
+
+func testRequest(t *testing.T, method, url, body string, headers ...string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.Nil(t, err)
+
+	for i := 0; i < len(headers); i = i + 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	return resp, string(data)
+}
+
+func TestHandlerKeys(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	resp, _ := testRequest(t, "GET", s.URL+"/keys/test-key", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test-key", "test-1234")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body := testRequest(t, "GET", s.URL+"/keys/test-key", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "test-1234", body)
+
+	tag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, tag)
+
+	// range requests
+	resp, body = testRequest(t, "GET", s.URL+"/keys/test-key", "", "Range", "bytes=5-")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "1234", body)
+
+	resp, _ = testRequest(t, "GET", s.URL+"/keys/test-key", "", "If-None-Match", tag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// conditional writes
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test-key", "test", "If-None-Match", "*")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test-key", "test-5678", "If-Match", tag)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	updated := resp.Header.Get("ETag")
+	assert.NotEqual(t, tag, updated)
+
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test-key", "test", "If-Match", tag)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = testRequest(t, "DELETE", s.URL+"/keys/test-key", "", "If-Match", tag)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = testRequest(t, "DELETE", s.URL+"/keys/test-key", "", "If-Match", updated)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, _ = testRequest(t, "DELETE", s.URL+"/keys/test-key", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test-key", "test", "If-None-Match", "*")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// keys containing escaped slashes
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test%2Fkey?ttl=1m", "test")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body = testRequest(t, "GET", s.URL+"/keys/test%2Fkey", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "test", body)
+	assert.NotEmpty(t, resp.Header.Get("Expires"))
+
+	resp, _ = testRequest(t, "POST", s.URL+"/keys/test-key", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestHandlerStream(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	h := s.Config.Handler.(*Handler)
+	h.BufferSize = 4
+	h.MaxValueSize = 16
+
+	// values larger than the buffer size are streamed
+	resp, _ := testRequest(t, "PUT", s.URL+"/keys/test-key", "test-1234")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body := testRequest(t, "GET", s.URL+"/keys/test-key", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "test-1234", body)
+
+	tag := resp.Header.Get("ETag")
+
+	resp, body = testRequest(t, "GET", s.URL+"/keys/test-key", "", "Range", "bytes=5-")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "1234", body)
+
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test-key?ttl=1m", "test-5678", "If-Match", tag)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NotEqual(t, tag, resp.Header.Get("ETag"))
+
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test-key", "test-0000", "If-Match", tag)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, body = testRequest(t, "GET", s.URL+"/keys/test-key", "")
+	assert.Equal(t, "test-5678", body)
+	assert.NotEmpty(t, resp.Header.Get("Expires"))
+
+	resp, _ = testRequest(t, "PUT", s.URL+"/keys/test-key", "test-1234-1234-1234")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// bodies of an unknown length must fit in the buffer
+	req, err := http.NewRequest("PUT", s.URL+"/keys/test-key", ioutil.NopCloser(strings.NewReader("test-1234")))
+	require.Nil(t, err)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusLengthRequired, resp.StatusCode)
+}
+
+func TestHandlerList(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	var ops []operation
+
+	for i := 0; i < 25; i++ {
+		ops = append(ops, operation{Op: "set", Key: fmt.Sprintf("test-key-%02d", i), Value: "test"})
+	}
+
+	ops = append(ops, operation{Op: "set", Key: "other-key", Value: "test"})
+	ops = append(ops, operation{Op: "delete", Key: "test-key-00"})
+
+	data, err := json.Marshal(ops)
+	require.Nil(t, err)
+
+	resp, _ := testRequest(t, "POST", s.URL+"/batch", string(data))
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, _ = testRequest(t, "POST", s.URL+"/batch", `[{"op":"unknown","key":"test"}]`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var keys []string
+
+	after := ""
+
+	for {
+		resp, body := testRequest(t, "GET", s.URL+"/keys?prefix=test-&limit=10&after="+after, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var l list
+
+		require.Nil(t, json.Unmarshal([]byte(body), &l))
+
+		keys = append(keys, l.Keys...)
+
+		if l.Next == "" {
+			break
+		}
+
+		assert.Len(t, l.Keys, 10)
+
+		after = l.Next
+	}
+
+	assert.Len(t, keys, 24)
+	assert.Equal(t, "test-key-01", keys[0])
+	assert.Equal(t, "test-key-24", keys[23])
+
+	resp, body := testRequest(t, "GET", s.URL+"/stats", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var st stats
+
+	require.Nil(t, json.Unmarshal([]byte(body), &st))
+	assert.Equal(t, 25, st.Keys)
+	assert.Equal(t, 1, st.Deleted)
+
+	resp, _ = testRequest(t, "POST", s.URL+"/compact", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, _ = testRequest(t, "GET", s.URL+"/unknown", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,609 @@
+package memcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+)
+
+const (
+	// MaxKeySize the maximum size of a key
+	MaxKeySize = 250
+	// MaxValueSize the maximum size of a value
+	MaxValueSize = 64 << 20
+	// Version the version reported to clients
+	Version = "lunar"
+	// maxLineSize the maximum size of a command line
+	maxLineSize = 2048
+	// relativeExpiry expiry times up to 30 days are relative to the current time,
+	// larger expiry times are an absolute unix time
+	relativeExpiry = 60 * 60 * 24 * 30
+	// flagsSize the size of the flags stored before each value
+	flagsSize = 4
+	// flushBatchSize the number of keys deleted by each batch when flushing
+	flushBatchSize = 1000
+)
+
+var (
+	errLineTooLong = errors.New("line too long")
+)
+
+// stats counters reported by the stats command
+type stats struct {
+	connections int64
+	gets        int64
+	sets        int64
+	touches     int64
+	hits        int64
+	misses      int64
+	started     time.Time
+}
+
+// conn the state of a client connection
+type conn struct {
+	br      *bufio.Reader
+	bw      *bufio.Writer
+	noreply bool // the current command should not be replied to
+	quit    bool
+}
+
+// line reads a line terminated by \r\n or \n
+func (c *conn) line() (string, error) {
+	data, err := c.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(data) > maxLineSize {
+		return "", errLineTooLong
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	data = data[:len(data)-1]
+
+	if len(data) > 0 && data[len(data)-1] == '\r' {
+		data = data[:len(data)-1]
+	}
+
+	return string(data), nil
+}
+
+// reply writes a line, unless the client requested no reply
+func (c *conn) reply(s string) {
+	if c.noreply {
+		return
+	}
+
+	c.bw.WriteString(s)
+	c.bw.WriteString("\r\n")
+}
+
+// execute runs a command. An error is only returned if the connection should be closed
+func (s *Server) execute(c *conn, args []string) error {
+	c.noreply = false
+
+	switch args[0] {
+	case "get", "gets":
+		s.get(c, args)
+	case "set", "add", "replace", "cas":
+		return s.store(c, args)
+	case "delete":
+		s.delete(c, args)
+	case "incr", "decr":
+		s.incr(c, args)
+	case "touch":
+		s.touch(c, args)
+	case "flush_all":
+		s.flushAll(c, args)
+	case "stats":
+		s.stat(c, args)
+	case "version":
+		c.reply("VERSION " + Version)
+	case "verbosity":
+		c.noreply = args[len(args)-1] == "noreply"
+		c.reply("OK")
+	case "quit":
+		c.quit = true
+	default:
+		c.reply("ERROR")
+	}
+
+	return nil
+}
+
+// get writes the value of each key that exists. gets also writes each value's cas token
+func (s *Server) get(c *conn, args []string) {
+	if len(args) < 2 {
+		c.reply("ERROR")
+		return
+	}
+
+	for _, key := range args[1:] {
+		if !validKey(key) {
+			c.reply("CLIENT_ERROR bad command line format")
+			return
+		}
+	}
+
+	for _, key := range args[1:] {
+		atomic.AddInt64(&s.stats.gets, 1)
+
+		value, version, err := s.db.GetVersion([]byte(key))
+		if err == lunar.ErrNotFound {
+			atomic.AddInt64(&s.stats.misses, 1)
+			continue
+		}
+
+		if err != nil {
+			c.reply("SERVER_ERROR " + err.Error())
+			return
+		}
+
+		atomic.AddInt64(&s.stats.hits, 1)
+
+		flags, data := s.decode(value)
+
+		line := "VALUE " + key + " " + strconv.FormatUint(uint64(flags), 10) + " " + strconv.Itoa(len(data))
+
+		if args[0] == "gets" {
+			line = line + " " + strconv.FormatUint(version, 10)
+		}
+
+		c.reply(line)
+		c.bw.Write(data)
+		c.reply("")
+	}
+
+	c.reply("END")
+}
+
+// store handles the set, add, replace and cas commands
+func (s *Server) store(c *conn, args []string) error {
+	size := 5
+
+	if args[0] == "cas" {
+		size = 6
+	}
+
+	if len(args) == size+1 && args[size] == "noreply" {
+		c.noreply = true
+		args = args[:size]
+	}
+
+	if len(args) != size || !validKey(args[1]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	flags, ferr := strconv.ParseUint(args[2], 10, 32)
+	exptime, eerr := strconv.ParseInt(args[3], 10, 64)
+	length, lerr := strconv.Atoi(args[4])
+
+	var token uint64
+	var terr error
+
+	if args[0] == "cas" {
+		token, terr = strconv.ParseUint(args[5], 10, 64)
+	}
+
+	if lerr != nil || length < 0 {
+		c.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	if length > MaxValueSize {
+		// discard the data block
+		_, err := io.CopyN(ioutil.Discard, c.br, int64(length)+2)
+		if err != nil {
+			return err
+		}
+
+		c.reply("SERVER_ERROR object too large for cache")
+		return nil
+	}
+
+	data := make([]byte, length+2)
+
+	_, err := io.ReadFull(c.br, data)
+	if err != nil {
+		return err
+	}
+
+	if data[length] != '\r' || data[length+1] != '\n' {
+		c.reply("CLIENT_ERROR bad data chunk")
+		return nil
+	}
+
+	if ferr != nil || eerr != nil || terr != nil {
+		c.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	atomic.AddInt64(&s.stats.sets, 1)
+
+	key := []byte(args[1])
+	value := s.encode(uint32(flags), data[:length])
+	ttl, expires := expiry(exptime)
+
+	switch args[0] {
+	case "set":
+		err = s.put(key, value, ttl, expires, 0, false)
+	case "add":
+		err = s.put(key, value, ttl, expires, 0, true)
+	case "replace":
+		err = s.replace(key, value, ttl, expires)
+	case "cas":
+		err = s.cas(key, value, ttl, expires, token)
+	}
+
+	switch err {
+	case nil:
+		c.reply("STORED")
+	case lunar.ErrVersionMismatch:
+		if args[0] == "cas" {
+			c.reply("EXISTS")
+		} else {
+			c.reply("NOT_STORED")
+		}
+	case lunar.ErrNotFound:
+		if args[0] == "cas" {
+			c.reply("NOT_FOUND")
+		} else {
+			c.reply("NOT_STORED")
+		}
+	default:
+		c.reply("SERVER_ERROR " + err.Error())
+	}
+
+	return nil
+}
+
+// replace stores a value only if the key exists
+func (s *Server) replace(key, value []byte, ttl time.Duration, expires bool) error {
+	for {
+		version, err := s.db.Version(key)
+		if err != nil {
+			return err
+		}
+
+		err = s.put(key, value, ttl, expires, version, true)
+		if err != lunar.ErrVersionMismatch {
+			return err
+		}
+	}
+}
+
+// cas stores a value only if the key's version matches the cas token
+func (s *Server) cas(key, value []byte, ttl time.Duration, expires bool, token uint64) error {
+	_, err := s.db.Version(key)
+	if err != nil {
+		return err
+	}
+
+	return s.put(key, value, ttl, expires, token, true)
+}
+
+func (s *Server) delete(c *conn, args []string) {
+	if len(args) > 2 && args[len(args)-1] == "noreply" {
+		c.noreply = true
+		args = args[:len(args)-1]
+	}
+
+	// a zero delay is allowed for compatibility with older clients
+	if len(args) == 3 && args[2] == "0" {
+		args = args[:2]
+	}
+
+	if len(args) != 2 || !validKey(args[1]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	err := s.db.Delete([]byte(args[1]))
+
+	switch err {
+	case nil:
+		c.reply("DELETED")
+	case lunar.ErrNotFound:
+		c.reply("NOT_FOUND")
+	default:
+		c.reply("SERVER_ERROR " + err.Error())
+	}
+}
+
+// incr handles the incr and decr commands. Values are unsigned 64 bit
+// integers, which wrap when incremented and do not decrement below zero
+func (s *Server) incr(c *conn, args []string) {
+	if len(args) == 4 && args[3] == "noreply" {
+		c.noreply = true
+		args = args[:3]
+	}
+
+	if len(args) != 3 || !validKey(args[1]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	delta, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+
+	key := []byte(args[1])
+
+	for {
+		value, version, err := s.db.GetVersion(key)
+		if err == lunar.ErrNotFound {
+			c.reply("NOT_FOUND")
+			return
+		}
+
+		if err != nil {
+			c.reply("SERVER_ERROR " + err.Error())
+			return
+		}
+
+		flags, data := s.decode(value)
+
+		n, err := strconv.ParseUint(string(data), 10, 64)
+		if err != nil {
+			c.reply("CLIENT_ERROR cannot increment or decrement non-numeric value")
+			return
+		}
+
+		switch {
+		case args[0] == "incr":
+			n = n + delta
+		case delta > n:
+			n = 0
+		default:
+			n = n - delta
+		}
+
+		// the key keeps its flags and expiry time
+		at, err := s.db.Expires(key)
+		if err != nil && err != lunar.ErrNotFound {
+			c.reply("SERVER_ERROR " + err.Error())
+			return
+		}
+
+		result := strconv.FormatUint(n, 10)
+
+		err = s.put(key, s.encode(flags, []byte(result)), time.Until(at), !at.IsZero(), version, true)
+		if err == lunar.ErrVersionMismatch {
+			continue
+		}
+
+		if err == lunar.ErrNotFound {
+			c.reply("NOT_FOUND")
+			return
+		}
+
+		if err != nil {
+			c.reply("SERVER_ERROR " + err.Error())
+			return
+		}
+
+		c.reply(result)
+
+		return
+	}
+}
+
+// touch updates the expiry time of a key
+func (s *Server) touch(c *conn, args []string) {
+	if len(args) == 4 && args[3] == "noreply" {
+		c.noreply = true
+		args = args[:3]
+	}
+
+	if len(args) != 3 || !validKey(args[1]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	exptime, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+
+	atomic.AddInt64(&s.stats.touches, 1)
+
+	key := []byte(args[1])
+	ttl, expires := expiry(exptime)
+
+	for {
+		value, version, err := s.db.GetVersion(key)
+		if err == nil {
+			err = s.put(key, value, ttl, expires, version, true)
+		}
+
+		switch err {
+		case nil:
+			c.reply("TOUCHED")
+		case lunar.ErrVersionMismatch:
+			continue
+		case lunar.ErrNotFound:
+			c.reply("NOT_FOUND")
+		default:
+			c.reply("SERVER_ERROR " + err.Error())
+		}
+
+		return
+	}
+}
+
+// flushAll deletes every key, optionally after a delay in seconds
+func (s *Server) flushAll(c *conn, args []string) {
+	if len(args) > 1 && args[len(args)-1] == "noreply" {
+		c.noreply = true
+		args = args[:len(args)-1]
+	}
+
+	if len(args) > 2 {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	if len(args) == 2 {
+		delay, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || delay < 0 {
+			c.reply("CLIENT_ERROR bad command line format")
+			return
+		}
+
+		if delay > 0 {
+			time.AfterFunc(time.Duration(delay)*time.Second, func() {
+				s.flush()
+			})
+
+			c.reply("OK")
+			return
+		}
+	}
+
+	err := s.flush()
+	if err != nil {
+		c.reply("SERVER_ERROR " + err.Error())
+		return
+	}
+
+	c.reply("OK")
+}
+
+// flush deletes every key in batches
+func (s *Server) flush() error {
+	var keys [][]byte
+
+	err := s.db.Scan(nil, func(key, value []byte) error {
+		keys = append(keys, append([]byte(nil), key...))
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var b lunar.Batch
+
+	for i := range keys {
+		b.Delete(keys[i])
+
+		if b.Len() < flushBatchSize && i < len(keys)-1 {
+			continue
+		}
+
+		err = s.db.Apply(&b)
+		if err != nil {
+			return err
+		}
+
+		b.Reset()
+	}
+
+	return nil
+}
+
+func (s *Server) stat(c *conn, args []string) {
+	if len(args) > 1 {
+		c.reply("ERROR")
+		return
+	}
+
+	dbs := s.db.Stats()
+	now := time.Now()
+
+	c.reply("STAT pid " + strconv.Itoa(os.Getpid()))
+	c.reply("STAT uptime " + strconv.FormatInt(int64(now.Sub(s.stats.started)/time.Second), 10))
+	c.reply("STAT time " + strconv.FormatInt(now.Unix(), 10))
+	c.reply("STAT version " + Version)
+	c.reply("STAT curr_connections " + strconv.FormatInt(atomic.LoadInt64(&s.stats.connections), 10))
+	c.reply("STAT cmd_get " + strconv.FormatInt(atomic.LoadInt64(&s.stats.gets), 10))
+	c.reply("STAT cmd_set " + strconv.FormatInt(atomic.LoadInt64(&s.stats.sets), 10))
+	c.reply("STAT cmd_touch " + strconv.FormatInt(atomic.LoadInt64(&s.stats.touches), 10))
+	c.reply("STAT get_hits " + strconv.FormatInt(atomic.LoadInt64(&s.stats.hits), 10))
+	c.reply("STAT get_misses " + strconv.FormatInt(atomic.LoadInt64(&s.stats.misses), 10))
+	c.reply("STAT curr_items " + strconv.Itoa(dbs.Keys))
+	c.reply("STAT bytes " + strconv.FormatInt(dbs.Live, 10))
+	c.reply("END")
+}
+
+// put writes a value, comparing the key's current version if cas is true
+func (s *Server) put(key, value []byte, ttl time.Duration, expires bool, version uint64, cas bool) error {
+	var err error
+
+	switch {
+	case !cas && !expires:
+		err = s.db.Set(key, value)
+	case !cas:
+		err = s.db.SetTTL(key, value, ttl)
+	case !expires:
+		_, err = s.db.CompareAndSwap(key, value, version)
+	default:
+		_, err = s.db.CompareAndSwapTTL(key, value, ttl, version)
+	}
+
+	return err
+}
+
+// encode prepends a value's flags
+func (s *Server) encode(flags uint32, data []byte) []byte {
+	if s.RawValues {
+		return data
+	}
+
+	value := make([]byte, flagsSize+len(data))
+	binary.BigEndian.PutUint32(value, flags)
+	copy(value[flagsSize:], data)
+
+	return value
+}
+
+// decode splits a stored value into its flags and data
+func (s *Server) decode(value []byte) (uint32, []byte) {
+	if s.RawValues || len(value) < flagsSize {
+		return 0, value
+	}
+
+	return binary.BigEndian.Uint32(value), value[flagsSize:]
+}
+
+// expiry converts a memcached expiry time to a ttl, returning
+// false if the value does not expire. A negative expiry time
+// results in a ttl that has already expired
+func expiry(exptime int64) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return -time.Second, true
+	case exptime <= relativeExpiry:
+		return time.Duration(exptime) * time.Second, true
+	}
+
+	return time.Until(time.Unix(exptime, 0)), true
+}
+
+// validKey checks a key is not too long and does not contain control characters
+func validKey(key string) bool {
+	if len(key) < 1 || len(key) > MaxKeySize {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < 33 || key[i] == 127 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,183 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+)
+
+var (
+	// ErrServerClosed the server has been closed
+	ErrServerClosed = errors.New("memcache: server closed")
+)
+
+// Server serves a database over the memcached text protocol
+type Server struct {
+	stats stats // accessed atomically, so must be 64 bit aligned
+	// RawValues stores values without their flags, so they can be
+	// read and written by other front ends. Flags are always zero
+	RawValues bool
+	db        *lunar.DB
+	cmu       sync.Mutex // held when adding or removing listeners and connections
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// New creates a new server for a database
+func New(db *lunar.DB) *Server {
+	return &Server{
+		db:        db,
+		stats:     stats{started: time.Now()},
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on a tcp address and serves connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections from a listener, serving each
+// connection in a new goroutine. Serve always returns an error,
+// which will be ErrServerClosed after Close has been called
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		l.Close()
+		return ErrServerClosed
+	}
+
+	defer s.untrack(l, nil)
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			return err
+		}
+
+		if !s.track(nil, c) {
+			c.Close()
+			return ErrServerClosed
+		}
+
+		go s.serve(c)
+	}
+}
+
+// Close closes all listeners and connections
+func (s *Server) Close() error {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	s.closed = true
+
+	var err error
+
+	for l := range s.listeners {
+		lerr := l.Close()
+		if lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+
+	for c := range s.conns {
+		c.Close()
+	}
+
+	return err
+}
+
+// serve reads and executes commands from a connection until it is closed
+func (s *Server) serve(c net.Conn) {
+	defer s.untrack(nil, c)
+	defer c.Close()
+
+	atomic.AddInt64(&s.stats.connections, 1)
+	defer atomic.AddInt64(&s.stats.connections, -1)
+
+	cn := conn{
+		br: bufio.NewReader(c),
+		bw: bufio.NewWriter(c),
+	}
+
+	for !cn.quit {
+		line, err := cn.line()
+		if err != nil {
+			if err == errLineTooLong {
+				cn.reply("CLIENT_ERROR line too long")
+				cn.bw.Flush()
+			}
+
+			return
+		}
+
+		args := strings.Fields(line)
+
+		if len(args) > 0 {
+			err = s.execute(&cn, args)
+			if err != nil {
+				return
+			}
+		}
+
+		// flush once all pipelined commands have been executed
+		if cn.br.Buffered() > 0 && !cn.quit {
+			continue
+		}
+
+		err = cn.bw.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) track(l net.Listener, c net.Conn) bool {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	}
+
+	if c != nil {
+		s.conns[c] = struct{}{}
+	}
+
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, c net.Conn) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	delete(s.listeners, l)
+	delete(s.conns, c)
+}
+
+func (s *Server) isClosed() bool {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	return s.closed
+}
@@ -0,0 +1,178 @@
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/purehyperbole/lunar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testServer(t *testing.T) (string, func()) {
+	db, err := lunar.Open("test.db")
+	require.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	s := New(db)
+
+	go s.Serve(l)
+
+	return l.Addr().String(), func() {
+		s.Close()
+		db.Close()
+		os.Remove("test.db")
+		os.Remove("test.db.hint")
+	}
+}
+
+func TestServer(t *testing.T) {
+	addr, cleanup := testServer(t)
+	defer cleanup()
+
+	c := memcache.New(addr)
+
+	_, err := c.Get("test-key")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	err = c.Set(&memcache.Item{Key: "test-key", Value: []byte("test"), Flags: 42})
+	require.Nil(t, err)
+
+	item, err := c.Get("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), item.Value)
+	assert.Equal(t, uint32(42), item.Flags)
+
+	err = c.Add(&memcache.Item{Key: "test-key", Value: []byte("test-2")})
+	assert.Equal(t, memcache.ErrNotStored, err)
+
+	err = c.Replace(&memcache.Item{Key: "test-key-2", Value: []byte("test-2")})
+	assert.Equal(t, memcache.ErrNotStored, err)
+
+	err = c.Add(&memcache.Item{Key: "test-key-2", Value: []byte("test-2")})
+	require.Nil(t, err)
+
+	err = c.Replace(&memcache.Item{Key: "test-key-2", Value: []byte("test-3")})
+	require.Nil(t, err)
+
+	items, err := c.GetMulti([]string{"test-key", "test-key-2", "test-key-3"})
+	require.Nil(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, []byte("test-3"), items["test-key-2"].Value)
+
+	// compare and swap using the token returned by gets
+	item, err = c.Get("test-key")
+	require.Nil(t, err)
+
+	stale := *item
+
+	item.Value = []byte("test-cas")
+
+	err = c.CompareAndSwap(item)
+	require.Nil(t, err)
+
+	err = c.CompareAndSwap(&stale)
+	assert.Equal(t, memcache.ErrCASConflict, err)
+
+	item, err = c.Get("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-cas"), item.Value)
+
+	// counters
+	err = c.Set(&memcache.Item{Key: "test-counter", Value: []byte("10")})
+	require.Nil(t, err)
+
+	n, err := c.Increment("test-counter", 5)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(15), n)
+
+	n, err = c.Decrement("test-counter", 20)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0), n)
+
+	_, err = c.Increment("test-key", 1)
+	assert.NotNil(t, err)
+
+	_, err = c.Increment("test-missing", 1)
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	err = c.Delete("test-key")
+	require.Nil(t, err)
+
+	err = c.Delete("test-key")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	// expiry
+	err = c.Set(&memcache.Item{Key: "test-expiry", Value: []byte("test"), Expiration: -1})
+	require.Nil(t, err)
+
+	_, err = c.Get("test-expiry")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	err = c.Set(&memcache.Item{Key: "test-expiry", Value: []byte("test")})
+	require.Nil(t, err)
+
+	err = c.Touch("test-expiry", 1)
+	require.Nil(t, err)
+
+	time.Sleep(time.Second)
+
+	_, err = c.Get("test-expiry")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	err = c.FlushAll()
+	require.Nil(t, err)
+
+	_, err = c.Get("test-key-2")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+}
+
+func TestServerText(t *testing.T) {
+	addr, cleanup := testServer(t)
+	defer cleanup()
+
+	c, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+
+	defer c.Close()
+
+	br := bufio.NewReader(c)
+
+	request := func(req string, lines int) string {
+		_, err := c.Write([]byte(req))
+		require.Nil(t, err)
+
+		var resp []string
+
+		for i := 0; i < lines; i++ {
+			line, err := br.ReadString('\n')
+			require.Nil(t, err)
+			resp = append(resp, line)
+		}
+
+		return strings.Join(resp, "")
+	}
+
+	assert.Equal(t, "STORED\r\n", request("set test-key 0 0 4\r\ntest\r\n", 1))
+	assert.Equal(t, "VALUE test-key 0 4\r\ntest\r\nEND\r\n", request("get test-key\r\n", 3))
+	assert.Contains(t, request("gets test-key\r\n", 3), "VALUE test-key 0 4 ")
+	assert.Equal(t, "NOT_FOUND\r\n", request("cas test-key-2 0 0 4 1\r\ntest\r\n", 1))
+	assert.Equal(t, "EXISTS\r\n", request("cas test-key 0 0 4 1\r\ntest\r\n", 1))
+
+	// replies are not sent for noreply commands
+	assert.Equal(t, "VERSION lunar\r\n", request("set test-key 0 0 1 noreply\r\nx\r\nversion\r\n", 1))
+
+	assert.Equal(t, "CLIENT_ERROR bad data chunk\r\n", request("set test-key 0 0 1\r\nxx\r\n", 1))
+	assert.Equal(t, "ERROR\r\n", request("unknown\r\n", 1))
+
+	stats := request("stats\r\n", 13)
+	assert.Contains(t, stats, "STAT curr_items 1\r\n")
+	assert.True(t, strings.HasSuffix(stats, "END\r\n"))
+}
@@ -0,0 +1,436 @@
+package resp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/purehyperbole/lunar"
+)
+
+const (
+	// defaultScanCount the number of keys visited by a scan if no count is given
+	defaultScanCount = 10
+)
+
+var (
+	errStop = errors.New("stop iteration")
+)
+
+// conn the state of a client connection
+type conn struct {
+	r    *reader
+	w    *writer
+	quit bool
+}
+
+// command a command that can be executed by a client.
+// arity is the number of arguments including the command name,
+// or the negated minimum number of arguments if it is variadic
+type command struct {
+	arity int
+	run   func(s *Server, c *conn, args [][]byte)
+}
+
+var commands = map[string]*command{
+	"PING":    {-1, ping},
+	"QUIT":    {1, quit},
+	"COMMAND": {-1, commandInfo},
+	"GET":     {2, get},
+	"SET":     {-3, set},
+	"DEL":     {-2, del},
+	"EXISTS":  {-2, exists},
+	"INCR":    {2, incr},
+	"MGET":    {-2, mget},
+	"MSET":    {-3, mset},
+	"TTL":     {2, ttl},
+	"SCAN":    {-2, scan},
+}
+
+// execute runs a command, writing its reply to the connection
+func (s *Server) execute(c *conn, args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.error("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		c.w.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+
+	cmd.run(s, c, args)
+}
+
+func ping(s *Server, c *conn, args [][]byte) {
+	switch len(args) {
+	case 1:
+		c.w.status("PONG")
+	case 2:
+		c.w.bulk(args[1])
+	default:
+		c.w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func quit(s *Server, c *conn, args [][]byte) {
+	c.w.status("OK")
+	c.quit = true
+}
+
+// commandInfo replies with no command details, which is enough for clients that request them
+func commandInfo(s *Server, c *conn, args [][]byte) {
+	c.w.array(0)
+}
+
+func get(s *Server, c *conn, args [][]byte) {
+	value, err := s.db.Get(args[1])
+	if err == lunar.ErrNotFound {
+		c.w.bulk(nil)
+		return
+	}
+
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	c.w.bulk(value)
+}
+
+func set(s *Server, c *conn, args [][]byte) {
+	if emptyKey(c, args[1]) {
+		return
+	}
+
+	var ttl time.Duration
+	var nx, xx bool
+
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+
+		switch {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case (opt == "EX" || opt == "PX") && ttl == 0 && i+1 < len(args):
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				c.w.error("ERR value is not an integer or out of range")
+				return
+			}
+
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+
+			if n < 1 || n > math.MaxInt64/int64(unit) {
+				c.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nx || xx {
+		_, err := s.db.Expires(args[1])
+		if err != nil && err != lunar.ErrNotFound {
+			c.w.error("ERR " + err.Error())
+			return
+		}
+
+		if nx && err == nil || xx && err == lunar.ErrNotFound {
+			c.w.bulk(nil)
+			return
+		}
+	}
+
+	var err error
+
+	if ttl > 0 {
+		err = s.db.SetTTL(args[1], args[2], ttl)
+	} else {
+		err = s.db.Set(args[1], args[2])
+	}
+
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	c.w.status("OK")
+}
+
+func del(s *Server, c *conn, args [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+
+	for _, key := range args[1:] {
+		err := s.db.Delete(key)
+		if err == lunar.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			c.w.error("ERR " + err.Error())
+			return
+		}
+
+		deleted++
+	}
+
+	c.w.integer(deleted)
+}
+
+func exists(s *Server, c *conn, args [][]byte) {
+	var count int64
+
+	for _, key := range args[1:] {
+		_, err := s.db.Expires(key)
+		if err == nil {
+			count++
+		}
+	}
+
+	c.w.integer(count)
+}
+
+func incr(s *Server, c *conn, args [][]byte) {
+	if emptyKey(c, args[1]) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+
+	value, err := s.db.Get(args[1])
+	if err != nil && err != lunar.ErrNotFound {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	if err == nil {
+		n, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			c.w.error("ERR value is not an integer or out of range")
+			return
+		}
+	}
+
+	if n == math.MaxInt64 {
+		c.w.error("ERR increment or decrement would overflow")
+		return
+	}
+
+	n++
+
+	// the key keeps its expiry time
+	expires, err := s.db.Expires(args[1])
+	if err != nil && err != lunar.ErrNotFound {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	value = []byte(strconv.FormatInt(n, 10))
+
+	if expires.IsZero() {
+		err = s.db.Set(args[1], value)
+	} else {
+		err = s.db.SetTTL(args[1], value, time.Until(expires))
+	}
+
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	c.w.integer(n)
+}
+
+func mget(s *Server, c *conn, args [][]byte) {
+	values := make([][]byte, len(args)-1)
+
+	for i, key := range args[1:] {
+		value, err := s.db.Get(key)
+		if err != nil && err != lunar.ErrNotFound {
+			c.w.error("ERR " + err.Error())
+			return
+		}
+
+		values[i] = value
+	}
+
+	c.w.array(len(values))
+
+	for _, value := range values {
+		c.w.bulk(value)
+	}
+}
+
+func mset(s *Server, c *conn, args [][]byte) {
+	if len(args)%2 != 1 {
+		c.w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	var b lunar.Batch
+
+	for i := 1; i < len(args); i = i + 2 {
+		if emptyKey(c, args[i]) {
+			return
+		}
+
+		b.Set(args[i], args[i+1])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Apply(&b)
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	c.w.status("OK")
+}
+
+func ttl(s *Server, c *conn, args [][]byte) {
+	expires, err := s.db.Expires(args[1])
+	if err == lunar.ErrNotFound {
+		c.w.integer(-2)
+		return
+	}
+
+	if err != nil {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	if expires.IsZero() {
+		c.w.integer(-1)
+		return
+	}
+
+	c.w.integer(int64((time.Until(expires) + time.Second/2) / time.Second))
+}
+
+// scan iterates over keys in key order. The cursor is the last key
+// returned by the previous call, base64 encoded, or zero to start from
+// the first key, so keys deleted between calls do not cause keys to be skipped
+func scan(s *Server, c *conn, args [][]byte) {
+	var after []byte
+
+	if string(args[1]) != "0" {
+		var err error
+
+		after, err = base64.RawURLEncoding.DecodeString(string(args[1]))
+		if err != nil || len(after) < 1 {
+			c.w.error("ERR invalid cursor")
+			return
+		}
+	}
+
+	var pattern []byte
+
+	count := uint64(defaultScanCount)
+
+	for i := 2; i < len(args); i = i + 2 {
+		if i+1 >= len(args) {
+			c.w.error("ERR syntax error")
+			return
+		}
+
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			var err error
+
+			count, err = strconv.ParseUint(string(args[i+1]), 10, 64)
+			if err != nil || count < 1 {
+				c.w.error("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+
+	var keys [][]byte
+	var visited uint64
+	var last []byte
+
+	err := s.db.Scan(nil, func(key, value []byte) error {
+		if after != nil && bytes.Compare(key, after) <= 0 {
+			return nil
+		}
+
+		if visited == count {
+			return errStop
+		}
+
+		visited++
+
+		last = append(last[:0], key...)
+
+		if pattern == nil || match(pattern, key) {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+
+		return nil
+	})
+
+	if err != nil && err != errStop {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	// the cursor is zero once all keys have been visited
+	next := "0"
+
+	if err == errStop {
+		next = base64.RawURLEncoding.EncodeToString(last)
+	}
+
+	c.w.array(2)
+	c.w.bulk([]byte(next))
+	c.w.array(len(keys))
+
+	for _, key := range keys {
+		c.w.bulk(key)
+	}
+}
+
+// emptyKey replies with an error if a key is empty, as it cannot be stored
+func emptyKey(c *conn, key []byte) bool {
+	if len(key) > 0 {
+		return false
+	}
+
+	c.w.error("ERR " + lunar.ErrEmptyKey.Error())
+
+	return true
+}
@@ -0,0 +1,100 @@
+package resp
+
+// match reports whether a key matches a redis glob style pattern.
+// '*' matches any sequence of bytes, '?' matches a single byte,
+// '[...]' matches a set or range of bytes, which is negated if it
+// starts with '^', and '\' escapes the following byte
+func match(pattern, key []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(key); i++ {
+				if match(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(key) < 1 {
+				return false
+			}
+		case '[':
+			if len(key) < 1 {
+				return false
+			}
+
+			var ok bool
+
+			ok, pattern = matchSet(pattern[1:], key[0])
+			if !ok {
+				return false
+			}
+
+			key = key[1:]
+
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+		default:
+			if len(key) < 1 || pattern[0] != key[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		key = key[1:]
+	}
+
+	return len(key) == 0
+}
+
+// matchSet matches a byte against the set at the start of a pattern,
+// returning the remainder of the pattern after the closing ']'
+func matchSet(pattern []byte, b byte) (bool, []byte) {
+	var negate, matched bool
+
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == b
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+
+			matched = matched || b >= lo && b <= hi
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == b
+			pattern = pattern[1:]
+		}
+	}
+
+	// skip the closing ']'
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return matched != negate, pattern
+}
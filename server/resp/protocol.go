@@ -0,0 +1,199 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxArguments the maximum number of arguments a command can have
+	MaxArguments = 1 << 20
+	// MaxBulkSize the maximum size of an argument
+	MaxBulkSize = 512 << 20
+	// maxInlineSize the maximum size of an inline command
+	maxInlineSize = 64 << 10
+)
+
+// protocolError the client sent a request that could not be parsed
+type protocolError string
+
+func (e protocolError) Error() string {
+	return string(e)
+}
+
+// reader reads commands sent by a client
+type reader struct {
+	br *bufio.Reader
+}
+
+func newReader(br *bufio.Reader) *reader {
+	return &reader{br: br}
+}
+
+// buffered returns the number of bytes that have been received but not read
+func (r *reader) buffered() int {
+	return r.br.Buffered()
+}
+
+// command reads the arguments of the next command, which is either
+// an array of bulk strings or an inline command separated by spaces
+func (r *reader) command() ([][]byte, error) {
+	prefix, err := r.br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix[0] != '*' {
+		line, err := r.line()
+		if err != nil {
+			return nil, err
+		}
+
+		return bytes.Fields(line), nil
+	}
+
+	line, err := r.line()
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count > MaxArguments {
+		return nil, protocolError("invalid multibulk length")
+	}
+
+	if count < 1 {
+		return nil, nil
+	}
+
+	args := make([][]byte, count)
+
+	for i := range args {
+		args[i], err = r.bulk()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return args, nil
+}
+
+// bulk reads a bulk string
+func (r *reader) bulk() ([]byte, error) {
+	line, err := r.line()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 1 || line[0] != '$' {
+		return nil, protocolError("expected '$'")
+	}
+
+	size, err := strconv.Atoi(string(line[1:]))
+	if err != nil || size < 0 || size > MaxBulkSize {
+		return nil, protocolError("invalid bulk length")
+	}
+
+	data := make([]byte, size+2)
+
+	_, err = io.ReadFull(r.br, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return nil, protocolError("invalid bulk terminator")
+	}
+
+	return data[:size], nil
+}
+
+// line reads a line terminated by \r\n or \n
+func (r *reader) line() ([]byte, error) {
+	var line []byte
+
+	for {
+		data, err := r.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			line = append(line, data...)
+
+			if len(line) > maxInlineSize {
+				return nil, protocolError("too big inline request")
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, data...)
+
+		break
+	}
+
+	line = line[:len(line)-1]
+
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	return line, nil
+}
+
+// writer writes replies to a client
+type writer struct {
+	bw *bufio.Writer
+}
+
+func newWriter(bw *bufio.Writer) *writer {
+	return &writer{bw: bw}
+}
+
+func (w *writer) status(s string) {
+	w.bw.WriteByte('+')
+	w.bw.WriteString(s)
+	w.bw.WriteString("\r\n")
+}
+
+// error writes an error, replacing any line breaks in the message
+func (w *writer) error(s string) {
+	w.bw.WriteByte('-')
+	w.bw.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(s))
+	w.bw.WriteString("\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.bw.WriteByte(':')
+	w.bw.WriteString(strconv.FormatInt(n, 10))
+	w.bw.WriteString("\r\n")
+}
+
+// bulk writes a bulk string, or a null bulk string if data is nil
+func (w *writer) bulk(data []byte) {
+	if data == nil {
+		w.bw.WriteString("$-1\r\n")
+		return
+	}
+
+	w.bw.WriteByte('$')
+	w.bw.WriteString(strconv.Itoa(len(data)))
+	w.bw.WriteString("\r\n")
+	w.bw.Write(data)
+	w.bw.WriteString("\r\n")
+}
+
+// array writes the header of an array with n elements
+func (w *writer) array(n int) {
+	w.bw.WriteByte('*')
+	w.bw.WriteString(strconv.Itoa(n))
+	w.bw.WriteString("\r\n")
+}
+
+func (w *writer) flush() error {
+	return w.bw.Flush()
+}
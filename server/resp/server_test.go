@@ -0,0 +1,228 @@
+package resp
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/purehyperbole/lunar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testServer(t *testing.T) (redis.Conn, func()) {
+	db, err := lunar.Open("test.db")
+	require.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	s := New(db)
+
+	go s.Serve(l)
+
+	c, err := redis.Dial("tcp", l.Addr().String())
+	require.Nil(t, err)
+
+	return c, func() {
+		c.Close()
+		s.Close()
+		db.Close()
+		os.Remove("test.db")
+		os.Remove("test.db.hint")
+	}
+}
+
+func TestServer(t *testing.T) {
+	c, cleanup := testServer(t)
+	defer cleanup()
+
+	pong, err := redis.String(c.Do("PING"))
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", pong)
+
+	_, err = redis.String(c.Do("GET", "test-key"))
+	assert.Equal(t, redis.ErrNil, err)
+
+	ok, err := redis.String(c.Do("SET", "test-key", "test"))
+	require.Nil(t, err)
+	assert.Equal(t, "OK", ok)
+
+	value, err := redis.String(c.Do("GET", "test-key"))
+	require.Nil(t, err)
+	assert.Equal(t, "test", value)
+
+	// conditional sets
+	_, err = redis.String(c.Do("SET", "test-key", "test-2", "NX"))
+	assert.Equal(t, redis.ErrNil, err)
+
+	_, err = redis.String(c.Do("SET", "test-key-2", "test-2", "XX"))
+	assert.Equal(t, redis.ErrNil, err)
+
+	_, err = redis.String(c.Do("SET", "test-key", "test-2", "XX"))
+	require.Nil(t, err)
+
+	_, err = redis.String(c.Do("SET", "test-key-2", "test-2", "NX"))
+	require.Nil(t, err)
+
+	_, err = c.Do("SET", "test-key", "test", "NX", "XX")
+	assert.NotNil(t, err)
+
+	_, err = c.Do("SET", "", "test")
+	assert.NotNil(t, err)
+
+	_, err = c.Do("MSET", "test-key-5", "test-5", "", "test")
+	assert.NotNil(t, err)
+
+	values, err := redis.Strings(c.Do("MGET", "test-key", "test-key-2", "test-key-3"))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"test-2", "test-2", ""}, values)
+
+	_, err = c.Do("MSET", "test-key-3", "test-3", "test-key-4", "test-4")
+	require.Nil(t, err)
+
+	n, err := redis.Int(c.Do("EXISTS", "test-key-3", "test-key-4", "test-key-5"))
+	require.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = redis.Int(c.Do("DEL", "test-key-3", "test-key-5"))
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// counters
+	n, err = redis.Int(c.Do("INCR", "test-counter"))
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = redis.Int(c.Do("INCR", "test-counter"))
+	require.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	_, err = c.Do("INCR", "test-key")
+	assert.NotNil(t, err)
+
+	// expiry
+	n, err = redis.Int(c.Do("TTL", "test-key"))
+	require.Nil(t, err)
+	assert.Equal(t, -1, n)
+
+	n, err = redis.Int(c.Do("TTL", "test-key-5"))
+	require.Nil(t, err)
+	assert.Equal(t, -2, n)
+
+	_, err = c.Do("SET", "test-expiry", "test", "EX", "100")
+	require.Nil(t, err)
+
+	n, err = redis.Int(c.Do("TTL", "test-expiry"))
+	require.Nil(t, err)
+	assert.Equal(t, 100, n)
+
+	_, err = c.Do("SET", "test-expiry", "test", "PX", "50")
+	require.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 50)
+
+	_, err = redis.String(c.Do("GET", "test-expiry"))
+	assert.Equal(t, redis.ErrNil, err)
+
+	_, err = c.Do("SET", "test-expiry", "test", "EX", "0")
+	assert.NotNil(t, err)
+
+	_, err = c.Do("UNKNOWN")
+	assert.NotNil(t, err)
+
+	_, err = c.Do("GET")
+	assert.NotNil(t, err)
+}
+
+func TestServerScan(t *testing.T) {
+	c, cleanup := testServer(t)
+	defer cleanup()
+
+	for _, key := range []string{"a:1", "a:2", "b:1", "a:3", "b:2"} {
+		_, err := c.Do("SET", key, "test")
+		require.Nil(t, err)
+	}
+
+	var keys []string
+
+	cursor := "0"
+
+	for {
+		reply, err := redis.Values(c.Do("SCAN", cursor, "MATCH", "a:*", "COUNT", "2"))
+		require.Nil(t, err)
+		require.Len(t, reply, 2)
+
+		cursor, err = redis.String(reply[0], nil)
+		require.Nil(t, err)
+
+		page, err := redis.Strings(reply[1], nil)
+		require.Nil(t, err)
+
+		keys = append(keys, page...)
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	assert.Equal(t, []string{"a:1", "a:2", "a:3"}, keys)
+}
+
+func TestServerScanDelete(t *testing.T) {
+	c, cleanup := testServer(t)
+	defer cleanup()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		_, err := c.Do("SET", key, "test")
+		require.Nil(t, err)
+	}
+
+	reply, err := redis.Values(c.Do("SCAN", "0", "COUNT", "2"))
+	require.Nil(t, err)
+
+	page, err := redis.Strings(reply[1], nil)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, page)
+
+	// keys deleted before the cursor do not cause keys to be skipped
+	_, err = c.Do("DEL", "a", "b")
+	require.Nil(t, err)
+
+	reply, err = redis.Values(c.Do("SCAN", reply[0], "COUNT", "2"))
+	require.Nil(t, err)
+
+	page, err = redis.Strings(reply[1], nil)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"c", "d"}, page)
+
+	_, err = c.Do("SCAN", "not a cursor")
+	assert.NotNil(t, err)
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"a*", "abc", true},
+		{"a*", "bc", false},
+		{"*c", "abc", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a[bc]d", "acd", true},
+		{"a[^bc]d", "acd", false},
+		{"a[a-z]d", "aqd", true},
+		{"a\\*", "a*", true},
+		{"a\\*", "ab", false},
+		{"user:*:name", "user:1:name", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.match, match([]byte(c.pattern), []byte(c.key)), c.pattern+" "+c.key)
+	}
+}
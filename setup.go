@@ -2,7 +2,10 @@ package lunar
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"unsafe"
 
 	"github.com/purehyperbole/lunar/header"
 	"github.com/purehyperbole/lunar/table"
@@ -10,10 +13,25 @@ import (
 )
 
 func (db *DB) setup(datapath string) error {
-	var err error
-	var rt *table.Table
-
 	db.index = rad.New()
+	db.counts = counts{}
+	db.path = datapath
+
+	changed := make(chan struct{})
+	db.changed = unsafe.Pointer(&changed)
+
+	db.store(&segments{tables: make(map[uint32]*table.Table)})
+
+	if db.segmented() {
+		return db.setupSegments(datapath)
+	}
+
+	var rt, wt *table.Table
+
+	err := checkFormat(datapath)
+	if err != nil {
+		return err
+	}
 
 	if db.compaction && exists(datapath) {
 		backup := datapath + ".backup"
@@ -22,40 +40,128 @@ func (db *DB) setup(datapath string) error {
 			return errors.New("could not backup data file")
 		}
 
+		// the hint file will not be valid for the compacted data file
+		err = db.removeHint(0)
+		if err != nil {
+			return err
+		}
+
 		err = os.Rename(datapath, backup)
 		if err != nil {
 			return err
 		}
 
-		rt, err = table.New(backup)
-	} else {
-		rt, err = table.New(datapath)
+		rt, err = db.openTable(backup)
+		if err != nil {
+			return err
+		}
 	}
 
+	// remove any hint file left behind by a previous data file
+	if !exists(datapath) {
+		err = db.removeHint(0)
+		if err != nil {
+			return err
+		}
+	}
+
+	wt, err = db.openTable(datapath)
+	if err != nil {
+		return err
+	}
+
+	if rt == nil {
+		rt = wt
+	}
+
+	db.store(db.current().with(0, wt))
+
+	err = db.reload(0, rt, wt, rt != wt)
+	if err != nil {
+		return err
+	}
+
+	if rt != wt {
+		return rt.Close()
+	}
+
+	return nil
+}
+
+func (db *DB) setupSegments(dir string) error {
+	err := os.MkdirAll(dir, 0755)
 	if err != nil {
 		return err
 	}
 
-	db.data, err = table.New(datapath)
+	ids, err := segmentIDs(dir)
 	if err != nil {
 		return err
 	}
 
-	if exists(datapath) {
-		return db.reload(rt, db.data)
+	for _, id := range ids {
+		err = checkFormat(segmentPath(dir, id))
+		if err != nil {
+			return err
+		}
+
+		t, err := db.openTable(segmentPath(dir, id))
+		if err != nil {
+			return err
+		}
+
+		t.SetLimit(db.segmentSize)
+
+		db.store(db.current().with(id, t))
+
+		err = db.reload(id, t, t, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	// deleted records may have been removed with the segments that
+	// were compacted, so readers of changes can not resume from them
+	if len(ids) > 0 && ids[0] > 1 {
+		db.dropped = db.position(ids[0], 0)
+	}
+
+	if len(ids) < 1 {
+		db.rotation.Lock()
+		err = db.create(1)
+		db.rotation.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if db.compaction {
+		return db.Compact()
 	}
 
 	return nil
 }
 
-func (db *DB) reload(rt, wt *table.Table) error {
-	var pos int64
+// reload indexes all records in a table. if compacting,
+// records are copied from the read table to the write table.
+// a record at the end of the table that fails its checksum was
+// not completely written, and is discarded with any incomplete
+// batch, but any other record that fails its checksum is corrupt
+func (db *DB) reload(id uint32, rt, wt *table.Table, compact bool) error {
+	var pos, start, end int64
+	var pending []int64 // offsets of records from an incomplete batch
+
+	// skip records covered by the hint file
+	if !compact {
+		pos = db.loadHint(id, rt)
+	}
 
 	dsz := rt.Size()
 
 	for {
 		if pos+header.HeaderSize > dsz {
-			return nil
+			break
 		}
 
 		// read header
@@ -66,55 +172,210 @@ func (db *DB) reload(rt, wt *table.Table) error {
 
 		h := header.Deserialize(data)
 
-		if h.KeySize() < 1 {
-			if !db.compaction {
-				wt.SetPosition(pos)
-			}
+		if empty(data) {
 			break
 		}
 
-		// skip old records
-		if db.compaction && h.Xmax() > 0 {
-			continue
+		if !sane(h, pos, dsz) {
+			return fmt.Errorf("%w: invalid record header at offset %d of %s", ErrCorrupt, pos, db.dataPath(id))
 		}
 
-		// get key from data
-		key := make([]byte, h.KeySize())
-
-		kd, err := rt.Read(h.KeySize(), pos+header.HeaderSize)
+		data, err = rt.Read(h.TotalSize(), pos)
 		if err != nil {
 			return err
 		}
 
-		copy(key, kd)
+		if !header.Valid(data) {
+			if !tail(rt, pos+h.TotalSize(), dsz) {
+				return fmt.Errorf("%w: checksum mismatch at offset %d of %s", ErrCorrupt, pos, db.dataPath(id))
+			}
+
+			db.logger.Warn("discarded incomplete record", "path", db.dataPath(id), "offset", pos)
+			end = pos + h.TotalSize()
+
+			break
+		}
+
+		// records written by a batch are only indexed
+		// once every record in the batch has been read
+		if len(pending) > 0 || h.Xmin() > 0 {
+			if len(pending) == 0 {
+				start = pos
+			}
 
-		np := pos
+			pending = append(pending, pos)
+			pos = pos + h.TotalSize()
 
-		if db.compaction {
-			data, err := rt.Read(h.TotalSize(), pos)
-			if err != nil {
-				return err
+			if h.Xmin() > 0 {
+				continue
 			}
 
-			np, err = wt.Write(data)
-			if err != nil {
-				return err
+			for _, offset := range pending {
+				err = db.load(id, rt, wt, offset, compact)
+				if err != nil {
+					return err
+				}
 			}
+
+			pending = pending[:0]
+
+			continue
 		}
 
-		db.index.Insert(key, &entry{
-			size:   h.TotalSize(),
-			offset: np,
-		})
+		err = db.load(id, rt, wt, pos, compact)
+		if err != nil {
+			return err
+		}
 
 		pos = pos + h.TotalSize()
 	}
 
-	db.data = wt
+	if end < pos {
+		end = pos
+	}
+
+	// discard the records of a batch that was not completely written
+	if len(pending) > 0 {
+		db.logger.Warn("discarded incomplete batch", "path", db.dataPath(id), "offset", start, "records", len(pending))
+		pos = start
+	}
+
+	if compact {
+		return nil
+	}
+
+	wt.SetPosition(pos)
+
+	// clear discarded records, so they are not read
+	// again if they are not completely overwritten
+	if end > pos {
+		return wt.WriteAt(make([]byte, end-pos), pos)
+	}
+
+	return nil
+}
+
+// tail returns true if there are no records after an offset
+func tail(t *table.Table, offset, size int64) bool {
+	if offset+header.HeaderSize > size {
+		return true
+	}
+
+	hd, err := t.Read(header.HeaderSize, offset)
+
+	return err == nil && empty(hd)
+}
+
+// load indexes the record at a given offset
+func (db *DB) load(id uint32, rt, wt *table.Table, offset int64, compact bool) error {
+	data, err := rt.Read(header.HeaderSize, offset)
+	if err != nil {
+		return err
+	}
+
+	h := header.Deserialize(data)
+
+	// get key from data
+	key := make([]byte, h.KeySize())
+
+	kd, err := rt.Read(h.KeySize(), offset+header.HeaderSize)
+	if err != nil {
+		return err
+	}
+
+	copy(key, kd)
+
+	if compact {
+		data, err := rt.Read(h.TotalSize(), offset)
+		if err != nil {
+			return err
+		}
+
+		// merge operands are linked to the key's record in the new data file
+		offset, err = wt.Write(db.link(key, data))
+		if err != nil {
+			return err
+		}
+	}
+
+	db.setEntry(key, &entry{
+		segment:   id,
+		size:      h.TotalSize(),
+		offset:    offset,
+		tombstone: h.Xmax() > 0,
+		expires:   h.Expires(),
+	})
 
 	return nil
 }
 
+// checkFormat returns ErrIncompatible if the first record of
+// a data file was written with a different record format
+func checkFormat(path string) error {
+	version, err := format(path)
+	if err != nil {
+		return err
+	}
+
+	switch version {
+	case header.FormatVersion:
+		return nil
+	case 0:
+		return fmt.Errorf("%w: %s was written before records were versioned and must be converted with Upgrade", ErrIncompatible, path)
+	default:
+		return fmt.Errorf("%w: %s was written with record format version %d, but only version %d is supported", ErrIncompatible, path, version, header.FormatVersion)
+	}
+}
+
+// format returns the record format version of the first record of a data
+// file, or the current version if the data file does not contain any records
+func format(path string) (uint8, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return header.FormatVersion, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	hd := make([]byte, header.HeaderSize)
+
+	_, err = f.ReadAt(hd, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	if empty(hd) {
+		return header.FormatVersion, nil
+	}
+
+	return header.Deserialize(hd).Version(), nil
+}
+
+// sane checks that a header describes a record of the
+// current format that fits within the data file
+func sane(h *header.Header, offset, size int64) bool {
+	if h.Version() != header.FormatVersion || h.KeySize() < 1 || h.DataSize() < 0 || h.KeySize() > size || h.DataSize() > size {
+		return false
+	}
+
+	return h.TotalSize() > 0 && offset+h.TotalSize() <= size
+}
+
+// empty returns true if data is entirely zeroed
+func empty(data []byte) bool {
+	for i := range data {
+		if data[i] != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
 func exists(path string) bool {
 	_, err := os.Stat(path)
 	if err != nil {
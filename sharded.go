@@ -0,0 +1,292 @@
+package lunar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	shardedMagic      = "LUNARSH1"
+	shardedManifest   = "manifest"
+	shardScanBuffer   = 64
+	shardManifestSize = len(shardedMagic) + 4
+)
+
+var (
+	// ErrShardCount the number of shards does not match the manifest
+	ErrShardCount = errors.New("shard count does not match manifest")
+	// ErrInvalidManifest the manifest is corrupt
+	ErrInvalidManifest = errors.New("invalid manifest")
+	errScanStopped     = errors.New("scan stopped")
+)
+
+// Sharded a database that spreads keys across a fixed number of
+// databases by the hash of the key, so writes to different shards
+// do not contend with each other
+type Sharded struct {
+	shards []*DB
+}
+
+// OpenSharded opens a sharded database in a directory, creating it if
+// it does not exist. The number of shards is recorded in a manifest
+// when the database is created and cannot be changed. If shards is
+// zero, the number of shards is read from the manifest. The options
+// are used when opening each shard
+func OpenSharded(dir string, shards int, opts ...func(*DB) error) (*Sharded, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := manifest(dir, shards)
+	if err != nil {
+		return nil, err
+	}
+
+	s := Sharded{
+		shards: make([]*DB, n),
+	}
+
+	for i := range s.shards {
+		s.shards[i], err = Open(filepath.Join(dir, fmt.Sprintf("shard-%03d", i)), opts...)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+// Close closes all of the shards
+func (s *Sharded) Close() error {
+	var err error
+
+	for _, db := range s.shards {
+		if db == nil {
+			continue
+		}
+
+		cerr := db.Close()
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Shards returns the number of shards
+func (s *Sharded) Shards() int {
+	return len(s.shards)
+}
+
+// Shard returns the database a key is stored in
+func (s *Sharded) Shard(key []byte) *DB {
+	h := fnv.New64a()
+	h.Write(key)
+
+	return s.shards[h.Sum64()%uint64(len(s.shards))]
+}
+
+// Get get a value by key
+func (s *Sharded) Get(key []byte) ([]byte, error) {
+	return s.Shard(key).Get(key)
+}
+
+// Set set a value by key
+func (s *Sharded) Set(key, value []byte) error {
+	return s.Shard(key).Set(key, value)
+}
+
+// Delete deletes a value by key
+func (s *Sharded) Delete(key []byte) error {
+	return s.Shard(key).Delete(key)
+}
+
+// SetTTL set a value by key, which will expire after the given duration
+func (s *Sharded) SetTTL(key, value []byte, ttl time.Duration) error {
+	return s.Shard(key).SetTTL(key, value, ttl)
+}
+
+// Expires returns the time a key expires, or the zero time if it does not expire
+func (s *Sharded) Expires(key []byte) (time.Time, error) {
+	return s.Shard(key).Expires(key)
+}
+
+// Gets get a value by string key
+func (s *Sharded) Gets(key string) ([]byte, error) {
+	return s.Get([]byte(key))
+}
+
+// Sets set a value by string key
+func (s *Sharded) Sets(key string, value []byte) error {
+	return s.Set([]byte(key), value)
+}
+
+// Deletes deletes a value by string key
+func (s *Sharded) Deletes(key string) error {
+	return s.Delete([]byte(key))
+}
+
+// Compact compacts all of the shards
+func (s *Sharded) Compact() error {
+	for _, db := range s.shards {
+		err := db.Compact()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the combined statistics of all of the shards
+func (s *Sharded) Stats() Stats {
+	var stats Stats
+
+	for _, db := range s.shards {
+		ss := db.Stats()
+
+		stats.Segments = stats.Segments + ss.Segments
+		stats.Keys = stats.Keys + ss.Keys
+		stats.Deleted = stats.Deleted + ss.Deleted
+		stats.Size = stats.Size + ss.Size
+		stats.Used = stats.Used + ss.Used
+		stats.Live = stats.Live + ss.Live
+	}
+
+	return stats
+}
+
+type shardItem struct {
+	key   []byte
+	value []byte
+	err   error
+}
+
+// Scan calls fn for every key with the given prefix and its value, in key
+// order across all of the shards. If fn returns an error, iteration stops
+// and the error is returned
+func (s *Sharded) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	scans := make([]chan shardItem, len(s.shards))
+
+	for i, db := range s.shards {
+		scans[i] = make(chan shardItem, shardScanBuffer)
+
+		go func(db *DB, items chan shardItem) {
+			defer close(items)
+
+			err := db.Scan(prefix, func(key, value []byte) error {
+				select {
+				case items <- shardItem{key: key, value: value}:
+					return nil
+				case <-done:
+					return errScanStopped
+				}
+			})
+
+			if err != nil && err != errScanStopped {
+				select {
+				case items <- shardItem{err: err}:
+				case <-done:
+				}
+			}
+		}(db, scans[i])
+	}
+
+	// the next item from each shard, nil once the shard has been exhausted
+	heads := make([]*shardItem, len(scans))
+
+	next := func(i int) error {
+		item, ok := <-scans[i]
+		if !ok {
+			heads[i] = nil
+			return nil
+		}
+
+		heads[i] = &item
+
+		return item.err
+	}
+
+	for i := range scans {
+		err := next(i)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		min := -1
+
+		for i, h := range heads {
+			if h != nil && (min < 0 || bytes.Compare(h.key, heads[min].key) < 0) {
+				min = i
+			}
+		}
+
+		if min < 0 {
+			return nil
+		}
+
+		err := fn(heads[min].key, heads[min].value)
+		if err != nil {
+			return err
+		}
+
+		err = next(min)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// manifest reads the number of shards from the manifest in a
+// directory, creating the manifest if it does not exist
+func manifest(dir string, shards int) (int, error) {
+	path := filepath.Join(dir, shardedManifest)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if shards < 1 {
+			return 0, errors.New("shard count must be greater than zero")
+		}
+
+		data = make([]byte, shardManifestSize)
+		copy(data, shardedMagic)
+		binary.LittleEndian.PutUint32(data[len(shardedMagic):], uint32(shards))
+
+		return shards, ioutil.WriteFile(path, data, 0644)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(data) != shardManifestSize || string(data[:len(shardedMagic)]) != shardedMagic {
+		return 0, ErrInvalidManifest
+	}
+
+	n := int(binary.LittleEndian.Uint32(data[len(shardedMagic):]))
+
+	if n < 1 {
+		return 0, ErrInvalidManifest
+	}
+
+	if shards > 0 && shards != n {
+		return 0, ErrShardCount
+	}
+
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package lunar
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharded(t *testing.T) {
+	defer os.RemoveAll("test-sharded")
+
+	s, err := OpenSharded("test-sharded", 4)
+	require.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.Nil(t, s.Sets(fmt.Sprintf("test-key-%03d", i), []byte(fmt.Sprintf("test-%d", i))))
+	}
+
+	require.Nil(t, s.Deletes("test-key-050"))
+
+	value, err := s.Gets("test-key-010")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-10"), value)
+
+	_, err = s.Gets("test-key-050")
+	assert.Equal(t, ErrNotFound, err)
+
+	// keys are spread across all of the shards
+	for i := 0; i < s.Shards(); i++ {
+		assert.NotZero(t, s.shards[i].Stats().Keys)
+	}
+
+	assert.Equal(t, 99, s.Stats().Keys)
+
+	// scans are ordered across shards
+	var keys []string
+
+	err = s.Scan([]byte("test-key-0"), func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+
+	require.Nil(t, err)
+	require.Len(t, keys, 99)
+
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, keys[i-1] < keys[i])
+	}
+
+	// stopping a scan early
+	stop := errors.New("stop")
+	count := 0
+
+	err = s.Scan(nil, func(key, value []byte) error {
+		count++
+		if count == 10 {
+			return stop
+		}
+		return nil
+	})
+
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 10, count)
+
+	require.Nil(t, s.Close())
+
+	// the shard count is read from the manifest
+	_, err = OpenSharded("test-sharded", 8)
+	assert.Equal(t, ErrShardCount, err)
+
+	s, err = OpenSharded("test-sharded", 0)
+	require.Nil(t, err)
+
+	defer s.Close()
+
+	assert.Equal(t, 4, s.Shards())
+
+	value, err = s.Gets("test-key-099")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-99"), value)
+}
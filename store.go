@@ -0,0 +1,109 @@
+package lunar
+
+import "time"
+
+// Keyspace a set of keys that values can be stored in, such as a DB, Bucket or Sharded database
+type Keyspace interface {
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	SetTTL(key, value []byte, ttl time.Duration) error
+	Delete(key []byte) error
+	Scan(prefix []byte, fn func(key, value []byte) error) error
+}
+
+// Store a typed view of a keyspace, which encodes keys and values with codecs
+type Store[K, V any] struct {
+	keyspace Keyspace
+	keys     Codec[K]
+	values   Codec[V]
+}
+
+// NewStore creates a store that encodes keys and values with the given codecs
+func NewStore[K, V any](keyspace Keyspace, keys Codec[K], values Codec[V]) *Store[K, V] {
+	return &Store[K, V]{
+		keyspace: keyspace,
+		keys:     keys,
+		values:   values,
+	}
+}
+
+// Get get a value by key
+func (s *Store[K, V]) Get(key K) (V, error) {
+	var v V
+
+	k, err := s.keys.Encode(key)
+	if err != nil {
+		return v, err
+	}
+
+	data, err := s.keyspace.Get(k)
+	if err != nil {
+		return v, err
+	}
+
+	return s.values.Decode(data)
+}
+
+// Set set value by key
+func (s *Store[K, V]) Set(key K, value V) error {
+	k, data, err := s.encode(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.keyspace.Set(k, data)
+}
+
+// SetTTL set value by key, which will expire after the given duration
+func (s *Store[K, V]) SetTTL(key K, value V, ttl time.Duration) error {
+	k, data, err := s.encode(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.keyspace.SetTTL(k, data, ttl)
+}
+
+// Delete delete a value by key
+func (s *Store[K, V]) Delete(key K) error {
+	k, err := s.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+
+	return s.keyspace.Delete(k)
+}
+
+// Scan calls fn for every key with the given encoded prefix and its value,
+// in the order of their encoded keys. A nil prefix includes all keys. If a key
+// or value cannot be decoded, or fn returns an error, iteration stops and the
+// error is returned
+func (s *Store[K, V]) Scan(prefix []byte, fn func(key K, value V) error) error {
+	return s.keyspace.Scan(prefix, func(k, data []byte) error {
+		key, err := s.keys.Decode(k)
+		if err != nil {
+			return err
+		}
+
+		value, err := s.values.Decode(data)
+		if err != nil {
+			return err
+		}
+
+		return fn(key, value)
+	})
+}
+
+func (s *Store[K, V]) encode(key K, value V) ([]byte, []byte, error) {
+	k, err := s.keys.Encode(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := s.values.Encode(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return k, data, nil
+}
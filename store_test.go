@@ -0,0 +1,111 @@
+package lunar
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testUser struct {
+	Name string
+	Age  int
+}
+
+// testMessage implements the methods of generated protobuf and msgpack types
+type testMessage struct {
+	ID uint64
+}
+
+func (m *testMessage) Marshal() ([]byte, error) {
+	data := make([]byte, 1+binary.MaxVarintLen64)
+	data[0] = 0x08
+	return data[:1+binary.PutUvarint(data[1:], m.ID)], nil
+}
+
+func (m *testMessage) Unmarshal(data []byte) error {
+	if len(data) < 2 || data[0] != 0x08 {
+		return errors.New("invalid message")
+	}
+
+	m.ID, _ = binary.Uvarint(data[1:])
+
+	return nil
+}
+
+func (m *testMessage) MarshalMsg(b []byte) ([]byte, error) {
+	data := make([]byte, 9)
+	data[0] = 0xcf
+	binary.BigEndian.PutUint64(data[1:], m.ID)
+	return append(b, data...), nil
+}
+
+func (m *testMessage) UnmarshalMsg(data []byte) ([]byte, error) {
+	if len(data) < 9 || data[0] != 0xcf {
+		return data, errors.New("invalid message")
+	}
+
+	m.ID = binary.BigEndian.Uint64(data[1:])
+
+	return data[9:], nil
+}
+
+func TestStore(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	users := NewStore[string, testUser](db, StringCodec{}, JSONCodec[testUser]{})
+
+	require.Nil(t, users.Set("alice", testUser{Name: "alice", Age: 30}))
+
+	u, err := users.Get("alice")
+	require.Nil(t, err)
+	assert.Equal(t, testUser{Name: "alice", Age: 30}, u)
+
+	require.Nil(t, users.Delete("alice"))
+
+	_, err = users.Get("alice")
+	assert.Equal(t, ErrNotFound, err)
+
+	// integer keys are scanned in numeric order
+	ids := NewStore[int64, testUser](db.Bucket("ids"), Int64Codec{}, GobCodec[testUser]{})
+
+	for _, id := range []int64{10, -5, 0, 3} {
+		require.Nil(t, ids.Set(id, testUser{Age: int(id)}))
+	}
+
+	var keys []int64
+
+	err = ids.Scan(nil, func(key int64, value testUser) error {
+		assert.Equal(t, int(key), value.Age)
+		keys = append(keys, key)
+		return nil
+	})
+
+	require.Nil(t, err)
+	assert.Equal(t, []int64{-5, 0, 3, 10}, keys)
+
+	proto := NewStore[uint64, testMessage](db.Bucket("proto"), Uint64Codec{}, NewProtoCodec[testMessage]())
+
+	require.Nil(t, proto.Set(1, testMessage{ID: 300}))
+
+	m, err := proto.Get(1)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(300), m.ID)
+
+	msgpack := NewStore[[]byte, testMessage](db.Bucket("msgpack"), BytesCodec{}, NewMsgpackCodec[testMessage]())
+
+	require.Nil(t, msgpack.Set([]byte("test"), testMessage{ID: 42}))
+
+	m, err = msgpack.Get([]byte("test"))
+	require.Nil(t, err)
+	assert.Equal(t, uint64(42), m.ID)
+
+	// values encoded with a different codec cannot be decoded
+	_, err = NewStore[[]byte, testMessage](db.Bucket("msgpack"), BytesCodec{}, NewProtoCodec[testMessage]()).Get([]byte("test"))
+	assert.NotNil(t, err)
+}
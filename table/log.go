@@ -0,0 +1,24 @@
+package table
+
+// Logger logs leveled events with key value pairs. It is satisfied by *slog.Logger
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger a logger that discards all events, used by default
+type NopLogger struct{}
+
+// Debug discards the event
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+
+// Info discards the event
+func (NopLogger) Info(msg string, args ...interface{}) {}
+
+// Warn discards the event
+func (NopLogger) Warn(msg string, args ...interface{}) {}
+
+// Error discards the event
+func (NopLogger) Error(msg string, args ...interface{}) {}
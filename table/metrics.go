@@ -0,0 +1,22 @@
+package table
+
+import "time"
+
+// Metrics receives measurements of a table's operations
+type Metrics interface {
+	Write(bytes int)                          // bytes were written to the table
+	Remap(size int64, duration time.Duration) // the table was grown and remapped to the given size
+	Sync(duration time.Duration)              // the table was flushed to disk
+}
+
+// NopMetrics discards all measurements, used by default
+type NopMetrics struct{}
+
+// Write discards the measurement
+func (NopMetrics) Write(bytes int) {}
+
+// Remap discards the measurement
+func (NopMetrics) Remap(size int64, duration time.Duration) {}
+
+// Sync discards the measurement
+func (NopMetrics) Sync(duration time.Duration) {}
@@ -2,7 +2,6 @@ package table
 
 import (
 	"errors"
-	"fmt"
 	"os"
 	"reflect"
 	"sync/atomic"
@@ -56,7 +55,6 @@ func (m *mmap) mmap() error {
 }
 
 func (m *mmap) munmap() error {
-	fmt.Println("munmap", m.size)
 	return syscall.Munmap(m.mapping)
 }
 
@@ -68,13 +66,14 @@ func (m *mmap) mremap(newSize int64) error {
 		return syscall.Errno(err)
 	}
 
-	nsh := &reflect.SliceHeader{
-		Data: r1,
-		Len:  int(newSize),
-		Cap:  int(newSize),
-	}
+	var mapping []byte
+
+	nsh := (*reflect.SliceHeader)(unsafe.Pointer(&mapping))
+	nsh.Data = r1
+	nsh.Len = int(newSize)
+	nsh.Cap = int(newSize)
 
-	m.mapping = *(*[]byte)(unsafe.Pointer(nsh))
+	m.mapping = mapping
 
 	return nil
 }
@@ -98,7 +97,6 @@ func (m *mmap) write(data []byte, offset int64) error {
 	defer atomic.AddInt32(&m.active, -1)
 
 	if atomic.LoadInt32(&m.closed) == 1 {
-		fmt.Println("mapping closed")
 		return ErrMappingClosed
 	}
 
@@ -5,6 +5,7 @@ import (
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -24,14 +25,20 @@ var (
 	ErrBoundsViolation = errors.New("specified offset and size exceeds size of mapping")
 	// ErrDataSizeTooLarge the provided value data exceeds the maximum size limit
 	ErrDataSizeTooLarge = errors.New("data exceeds maximum limit")
+	// ErrTableFull the write would exceed the size limit of the table
+	ErrTableFull = errors.New("table size limit reached")
 )
 
 // Table mmaped file
 type Table struct {
 	fd       *os.File
 	position int64
+	limit    int64
 	mapping  unsafe.Pointer
+	pins     int32 // number of readers preventing old mappings from being unmapped
 	mu       sync.Mutex
+	logger   Logger
+	metrics  Metrics
 }
 
 // New loads a new table
@@ -61,6 +68,8 @@ func New(path string) (*Table, error) {
 	t := Table{
 		fd:      fd,
 		mapping: unsafe.Pointer(mapping),
+		logger:  NopLogger{},
+		metrics: NopMetrics{},
 	}
 
 	return &t, nil
@@ -72,11 +81,25 @@ func (t *Table) Read(size, offset int64) ([]byte, error) {
 	return mapping.read(size, offset)
 }
 
+// Pin prevents mappings replaced by a resize from being unmapped, so data
+// returned by Read remains valid after Read returns, until Unpin is called
+func (t *Table) Pin() {
+	atomic.AddInt32(&t.pins, 1)
+}
+
+// Unpin allows mappings replaced by a resize to be unmapped again
+func (t *Table) Unpin() {
+	atomic.AddInt32(&t.pins, -1)
+}
+
 // Write writes to table at a given offset
 func (t *Table) Write(data []byte) (int64, error) {
 	ds := int64(len(data))
 
-	offset := atomic.AddInt64(&t.position, ds) - ds
+	offset, err := t.reserve(ds)
+	if err != nil {
+		return 0, err
+	}
 
 	if t.Size() < offset+ds {
 		err := t.resize(ds, offset)
@@ -85,11 +108,15 @@ func (t *Table) Write(data []byte) (int64, error) {
 		}
 	}
 
-	err := (*mmap)(atomic.LoadPointer(&t.mapping)).write(data, offset)
+	err = (*mmap)(atomic.LoadPointer(&t.mapping)).write(data, offset)
 	for err == ErrMappingClosed {
 		err = (*mmap)(atomic.LoadPointer(&t.mapping)).write(data, offset)
 	}
 
+	if err == nil {
+		t.metrics.Write(len(data))
+	}
+
 	return offset, err
 }
 
@@ -109,6 +136,10 @@ func (t *Table) WriteAt(data []byte, offset int64) error {
 		err = (*mmap)(atomic.LoadPointer(&t.mapping)).write(data, offset)
 	}
 
+	if err == nil {
+		t.metrics.Write(len(data))
+	}
+
 	return err
 }
 
@@ -122,11 +153,35 @@ func (t *Table) SetPosition(pos int64) {
 	atomic.StoreInt64(&t.position, pos)
 }
 
+// Limit returns the maximum size the table can grow to, zero if unlimited
+func (t *Table) Limit() int64 {
+	return atomic.LoadInt64(&t.limit)
+}
+
+// SetLimit sets the maximum size the table can grow to
+// writes that would exceed the limit will fail with ErrTableFull,
+// unless the table is empty
+func (t *Table) SetLimit(limit int64) {
+	atomic.StoreInt64(&t.limit, limit)
+}
+
 // Size the size of the table
 func (t *Table) Size() int64 {
 	return (*mmap)(atomic.LoadPointer(&t.mapping)).size
 }
 
+// SetLogger sets the logger that the table's events are logged to.
+// It must be set before the table is written to
+func (t *Table) SetLogger(logger Logger) {
+	t.logger = logger
+}
+
+// SetMetrics sets the metrics that the table's operations are measured by.
+// It must be set before the table is written to
+func (t *Table) SetMetrics(metrics Metrics) {
+	t.metrics = metrics
+}
+
 // Close close table file descriptor and unmap
 func (t *Table) Close() error {
 	mapping := (*mmap)(atomic.LoadPointer(&t.mapping))
@@ -136,7 +191,7 @@ func (t *Table) Close() error {
 		return err
 	}
 
-	err = t.sync()
+	err = t.Sync()
 	if err != nil {
 		return err
 	}
@@ -144,8 +199,38 @@ func (t *Table) Close() error {
 	return t.fd.Close()
 }
 
-func (t *Table) sync() error {
-	return t.fd.Sync()
+// Sync flushes all writes to the table to disk
+func (t *Table) Sync() error {
+	start := time.Now()
+
+	err := t.fd.Sync()
+	if err != nil {
+		return err
+	}
+
+	t.metrics.Sync(time.Since(start))
+
+	return nil
+}
+
+func (t *Table) reserve(size int64) (int64, error) {
+	limit := atomic.LoadInt64(&t.limit)
+
+	if limit < 1 {
+		return atomic.AddInt64(&t.position, size) - size, nil
+	}
+
+	for {
+		offset := atomic.LoadInt64(&t.position)
+
+		if offset > 0 && offset+size > limit {
+			return 0, ErrTableFull
+		}
+
+		if atomic.CompareAndSwapInt64(&t.position, offset, offset+size) {
+			return offset, nil
+		}
+	}
 }
 
 func (t *Table) resize(size, offset int64) error {
@@ -156,10 +241,24 @@ func (t *Table) resize(size, offset int64) error {
 		return nil
 	}
 
+	start := time.Now()
+
 	newSize := t.growadvise(size)
 
+	// concurrent writes may have reserved space beyond the advised size
+	if newSize < size+offset {
+		newSize = size + offset
+	}
+
+	// don't grow past the limit unless the write requires it
+	limit := t.Limit()
+	if limit > 0 && newSize > limit && limit >= size+offset {
+		newSize = limit
+	}
+
 	err := t.fd.Truncate(newSize)
 	if err != nil {
+		t.logger.Error("failed to grow table", "path", t.fd.Name(), "size", newSize, "error", err)
 		return err
 	}
 
@@ -167,18 +266,40 @@ func (t *Table) resize(size, offset int64) error {
 
 	newMapping, err := newmmap(t.fd)
 	if err != nil {
+		t.logger.Error("failed to remap table", "path", t.fd.Name(), "size", newSize, "error", err)
 		return err
 	}
 
 	atomic.StorePointer(&t.mapping, unsafe.Pointer(newMapping))
 
+	duration := time.Since(start)
+
+	t.logger.Debug("remapped table", "path", t.fd.Name(), "size", newSize, "duration", duration)
+	t.metrics.Remap(newSize, duration)
+
 	if oldMapping != nil {
-		go oldMapping.close()
+		go t.unmap(oldMapping)
 	}
 
 	return nil
 }
 
+// unmap closes a mapping once it is no longer being read from or written to,
+// and the table is not pinned
+func (t *Table) unmap(m *mmap) {
+	for atomic.LoadInt32(&t.pins) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	err := m.close()
+	if err != nil {
+		t.logger.Error("failed to unmap table", "path", t.fd.Name(), "size", m.size, "error", err)
+		return
+	}
+
+	t.logger.Debug("unmapped table", "path", t.fd.Name(), "size", m.size)
+}
+
 func (t *Table) growadvise(size int64) int64 {
 	tsz := t.Size()
 
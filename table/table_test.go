@@ -4,6 +4,7 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -62,6 +63,27 @@ func TestRead(t *testing.T) {
 	os.Remove(db.fd.Name())
 }
 
+func TestWriteLimit(t *testing.T) {
+	data := make([]byte, 1000)
+
+	db, err := New("test.db")
+	require.Nil(t, err)
+
+	defer os.Remove(db.fd.Name())
+
+	db.SetLimit(2500)
+
+	_, err = db.Write(data)
+	require.Nil(t, err)
+
+	_, err = db.Write(data)
+	require.Nil(t, err)
+
+	_, err = db.Write(data)
+	assert.Equal(t, ErrTableFull, err)
+	assert.Equal(t, int64(2000), db.Position())
+}
+
 func TestConcurrentWrite(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -88,3 +110,54 @@ func TestConcurrentWrite(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestPin(t *testing.T) {
+	data := []byte("test8901")
+
+	db, err := New("test.db")
+	require.Nil(t, err)
+
+	defer os.Remove(db.fd.Name())
+
+	_, err = db.Write(data)
+	require.Nil(t, err)
+
+	db.Pin()
+
+	pinned, err := db.Read(int64(len(data)), 0)
+	require.Nil(t, err)
+
+	// grow the table, replacing the mapping that was read from
+	for db.Size() < MinStep*4 {
+		_, err = db.Write(make([]byte, 1024))
+		require.Nil(t, err)
+	}
+
+	time.Sleep(time.Millisecond * 10)
+
+	assert.Equal(t, data, pinned)
+
+	db.Unpin()
+}
+
+func TestWriteLargerThanTable(t *testing.T) {
+	db, err := New("test.db")
+	require.Nil(t, err)
+
+	defer os.Remove(db.fd.Name())
+	defer db.Close()
+
+	_, err = db.Write([]byte("test8901"))
+	require.Nil(t, err)
+
+	data := make([]byte, db.Size()*3)
+	data[len(data)-1] = 1
+
+	offset, err := db.Write(data)
+	require.Nil(t, err)
+	assert.Equal(t, int64(8), offset)
+
+	read, err := db.Read(int64(len(data)), offset)
+	require.Nil(t, err)
+	assert.Equal(t, data, read)
+}
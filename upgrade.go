@@ -0,0 +1,119 @@
+package lunar
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+)
+
+const (
+	// legacyHeaderSize the size of the header of records written before records were versioned
+	legacyHeaderSize = 48
+	legacyExt        = ".legacy"
+)
+
+// Upgrade converts a data file written before records were versioned to the
+// current record format, so it can be opened. Those data files only contain
+// the keys and values that were set, which are rewritten in the order they
+// were written. The original data file is kept with a .legacy suffix.
+// Data files that already use the current format are left unchanged
+func Upgrade(path string) error {
+	if !exists(path) {
+		return os.ErrNotExist
+	}
+
+	version, err := format(path)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 {
+		return checkFormat(path)
+	}
+
+	legacy := path + legacyExt
+	upgraded := path + ".upgrade"
+
+	if exists(legacy) {
+		return errors.New("could not keep legacy data file")
+	}
+
+	err = os.Remove(upgraded)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	rt, err := table.New(path)
+	if err != nil {
+		return err
+	}
+
+	defer rt.Close()
+
+	wt, err := table.New(upgraded)
+	if err != nil {
+		return err
+	}
+
+	err = upgrade(path, rt, wt)
+	if err != nil {
+		wt.Close()
+		os.Remove(upgraded)
+		return err
+	}
+
+	err = wt.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(path, legacy)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(upgraded, path)
+}
+
+// upgrade rewrites every legacy record in the read table to the write table
+func upgrade(path string, rt, wt *table.Table) error {
+	var pos int64
+
+	for pos+legacyHeaderSize <= rt.Size() {
+		hd, err := rt.Read(legacyHeaderSize, pos)
+		if err != nil {
+			return err
+		}
+
+		size := *(*int64)(unsafe.Pointer(&hd[32]))
+		ksize := *(*int64)(unsafe.Pointer(&hd[40]))
+
+		if ksize < 1 {
+			return nil
+		}
+
+		if size < 0 || pos+legacyHeaderSize+ksize+size > rt.Size() {
+			return fmt.Errorf("%w: invalid legacy record header at offset %d of %s", ErrCorrupt, pos, path)
+		}
+
+		data, err := rt.Read(legacyHeaderSize+ksize+size, pos)
+		if err != nil {
+			return err
+		}
+
+		var h header.Header
+
+		_, err = wt.Write(record(&h, data[legacyHeaderSize:legacyHeaderSize+ksize], data[legacyHeaderSize+ksize:]))
+		if err != nil {
+			return err
+		}
+
+		pos = pos + legacyHeaderSize + ksize + size
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package lunar
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyRecord serializes a record in the format used before records were versioned
+func legacyRecord(key, value string) []byte {
+	data := make([]byte, legacyHeaderSize+len(key)+len(value))
+	binary.LittleEndian.PutUint64(data[32:], uint64(len(value)))
+	binary.LittleEndian.PutUint64(data[40:], uint64(len(key)))
+	copy(data[legacyHeaderSize:], key)
+	copy(data[legacyHeaderSize+len(key):], value)
+	return data
+}
+
+func TestUpgrade(t *testing.T) {
+	defer os.Remove("test.db.legacy")
+
+	var data []byte
+
+	data = append(data, legacyRecord("test-key-1", "test-1")...)
+	data = append(data, legacyRecord("test-key-2", "test-2")...)
+	data = append(data, legacyRecord("test-key-1", "test-1234")...)
+	data = append(data, make([]byte, 1024)...)
+
+	require.Nil(t, os.WriteFile("test.db", data, 0644))
+
+	db, err := Open("test.db")
+	assert.True(t, errors.Is(err, ErrIncompatible))
+	assert.Contains(t, err.Error(), "Upgrade")
+
+	require.Nil(t, Upgrade("test.db"))
+
+	// upgrading a data file in the current format does nothing
+	require.Nil(t, Upgrade("test.db"))
+
+	db, err = Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	value, err := db.Gets("test-key-1")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-1234"), value)
+
+	value, err = db.Gets("test-key-2")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-2"), value)
+
+	legacy, err := os.ReadFile("test.db.legacy")
+	require.Nil(t, err)
+	assert.Equal(t, data, legacy)
+}
@@ -0,0 +1,489 @@
+package lunar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+)
+
+const (
+	valueExt = ".vlog"
+	// pointerSize the size of a pointer to a value stored outside of the data files
+	pointerSize = 24
+)
+
+var (
+	// ErrValueTooLarge the value is too large to be stored in a data file
+	ErrValueTooLarge = errors.New("value is too large to be stored in a data file")
+)
+
+// values tracks the value files that store values outside of the data files
+type values struct {
+	mu      sync.Mutex
+	next    uint32              // id of the next value file
+	pending map[uint32]struct{} // value files that are being written, which are not referenced yet
+	log     *os.File            // the value log that separated values are appended to
+	logID   uint32              // id of the value log's file
+	logSize int64               // size of the value log
+}
+
+// pointer the location of a value stored in a value file
+type pointer struct {
+	file   uint32
+	offset int64
+	size   int64
+	crc    uint32
+}
+
+// SetReader set a value by key, reading size bytes of the value from r.
+// The value is streamed to its own value file instead of the data file,
+// so it can be larger than the data file's maximum record size without
+// being held in memory. Value files that are no longer referenced are
+// removed by compaction. Values larger than the data file's maximum
+// record size are not included in backups or sent to followers, which
+// keep any earlier value of the key, and a warning is logged
+func (db *DB) SetReader(key []byte, r io.Reader, size int64) error {
+	var h header.Header
+
+	return db.putReader(key, r, size, &h, db.put)
+}
+
+// SetReaderTTL set a value by key, reading size bytes of the value
+// from r, which will expire after the given duration
+func (db *DB) SetReaderTTL(key []byte, r io.Reader, size int64, ttl time.Duration) error {
+	var h header.Header
+
+	h.SetExpires(time.Now().Add(ttl).UnixNano())
+
+	return db.putReader(key, r, size, &h, db.put)
+}
+
+// CompareAndSwapReader set a value by key, reading size bytes of the value
+// from r, if the key's current version matches the given version, returning
+// the new version. The value is read before the version is compared
+func (db *DB) CompareAndSwapReader(key []byte, r io.Reader, size int64, version uint64) (uint64, error) {
+	var h header.Header
+
+	return db.swapReader(key, r, size, &h, version)
+}
+
+// CompareAndSwapReaderTTL set a value by key, reading size bytes of the value
+// from r, if the key's current version matches the given version, which will
+// expire after the given duration
+func (db *DB) CompareAndSwapReaderTTL(key []byte, r io.Reader, size int64, ttl time.Duration, version uint64) (uint64, error) {
+	var h header.Header
+
+	h.SetExpires(time.Now().Add(ttl).UnixNano())
+
+	return db.swapReader(key, r, size, &h, version)
+}
+
+// GetReader get a reader for a value by key. Values stored with SetReader
+// are read from their value file, which remains readable until the reader
+// is closed, even if the key is updated or compacted. The reader also
+// implements io.Seeker, although a value's checksum is only verified if
+// it is read from the start
+func (db *DB) GetReader(key []byte) (io.ReadCloser, error) {
+	r, _, err := db.GetReaderVersion(key)
+	return r, err
+}
+
+// GetReaderVersion get a reader for a value and its version by key
+func (db *DB) GetReaderVersion(key []byte) (io.ReadCloser, uint64, error) {
+	start := time.Now()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	r, version, err := db.reader(key)
+
+	db.metrics.Get(time.Since(start))
+
+	return r, version, err
+}
+
+// reader returns a reader for a value and its version by key. db.mu must be held
+func (db *DB) reader(key []byte) (io.ReadCloser, uint64, error) {
+	h, data, version, err := db.read(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if h.Merge() {
+		value, err := db.merged(key, h, data)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return bytesReader{bytes.NewReader(value)}, version, nil
+	}
+
+	if !h.Pointer() {
+		value := make([]byte, h.DataSize())
+		copy(value, data[h.DataOffset():])
+
+		return bytesReader{bytes.NewReader(value)}, version, nil
+	}
+
+	p, err := decodePointer(data[h.DataOffset():])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := os.Open(db.valuePath(p.file))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &valueReader{
+		file:   f,
+		reader: io.NewSectionReader(f, p.offset, p.size),
+		hash:   crc32.NewIEEE(),
+		crc:    p.crc,
+	}, version, nil
+}
+
+// putReader streams a value to a new value file and writes a record that
+// points to it, using the given header and write function
+func (db *DB) putReader(key []byte, r io.Reader, size int64, h *header.Header, write func(key, data []byte) error) error {
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	db.mu.RLock()
+	err = db.writable()
+	db.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	id, err := db.reserveValue()
+	if err != nil {
+		return err
+	}
+
+	defer db.releaseValue(id)
+
+	p, err := db.writeValue(id, r, size)
+	if err != nil {
+		os.Remove(db.valuePath(id))
+		return err
+	}
+
+	h.SetFlags(header.FlagPointer)
+
+	// only the write of the pointer is measured, not streaming the value
+	err = write(key, record(h, key, p.encode()))
+	if err != nil {
+		os.Remove(db.valuePath(id))
+	}
+
+	return err
+}
+
+// swapReader streams a value to a new value file and writes
+// a record that points to it if the key's version matches
+func (db *DB) swapReader(key []byte, r io.Reader, size int64, h *header.Header, version uint64) (uint64, error) {
+	var updated uint64
+
+	err := db.putReader(key, r, size, h, func(key, data []byte) error {
+		var err error
+
+		updated, err = db.swap(key, data, version)
+
+		return err
+	})
+
+	return updated, err
+}
+
+// readValue reads the value a pointer refers to. db.mu must be held
+func (db *DB) readValue(data []byte) ([]byte, error) {
+	p, err := decodePointer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(db.valuePath(p.file))
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	value := make([]byte, p.size)
+
+	_, err = f.ReadAt(value, p.offset)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	if crc32.ChecksumIEEE(value) != p.crc {
+		return nil, ErrCorrupt
+	}
+
+	return value, nil
+}
+
+// writeValue writes size bytes from a reader to a new value file
+func (db *DB) writeValue(id uint32, r io.Reader, size int64) (*pointer, error) {
+	err := os.MkdirAll(db.valuesPath(), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(db.valuePath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	h := crc32.NewIEEE()
+
+	_, err = io.CopyN(io.MultiWriter(f, h), r, size)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pointer{
+		file: id,
+		size: size,
+		crc:  h.Sum32(),
+	}, nil
+}
+
+// reserveValue returns the id of a new value file, which will
+// not be removed by compaction until it has been released
+func (db *DB) reserveValue() (uint32, error) {
+	db.values.mu.Lock()
+	defer db.values.mu.Unlock()
+
+	return db.nextValue()
+}
+
+// nextValue returns the id of a new value file and marks it as
+// pending. db.values.mu must be held
+func (db *DB) nextValue() (uint32, error) {
+	if db.values.pending == nil {
+		ids, err := valueIDs(db.valuesPath())
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+
+		if len(ids) > 0 {
+			db.values.next = ids[len(ids)-1] + 1
+		}
+
+		db.values.pending = make(map[uint32]struct{})
+	}
+
+	id := db.values.next
+
+	db.values.next++
+	db.values.pending[id] = struct{}{}
+
+	return id, nil
+}
+
+// releaseValue allows a value file to be removed by compaction once it is no longer referenced
+func (db *DB) releaseValue(id uint32) {
+	db.values.mu.Lock()
+	defer db.values.mu.Unlock()
+
+	delete(db.values.pending, id)
+}
+
+// removeValues removes all value files that are not referenced by the current version of a key
+func (db *DB) removeValues() error {
+	ids, err := valueIDs(db.valuesPath())
+	if os.IsNotExist(err) || len(ids) < 1 {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// wait for backups that may read the values of previous versions
+	db.snapshot.Lock()
+	defer db.snapshot.Unlock()
+
+	// prevent new references being written while the referenced files are found
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	referenced, err := db.references()
+	if err != nil {
+		return err
+	}
+
+	db.values.mu.Lock()
+	defer db.values.mu.Unlock()
+
+	for _, id := range ids {
+		_, ok := referenced[id]
+		if ok {
+			continue
+		}
+
+		_, ok = db.values.pending[id]
+		if ok {
+			continue
+		}
+
+		err = os.Remove(db.valuePath(id))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// valuesPath returns the path of the directory value files are stored in
+func (db *DB) valuesPath() string {
+	if !db.segmented() {
+		return db.path + ".values"
+	}
+
+	return filepath.Join(db.path, "values")
+}
+
+// valuePath returns the path of a value file
+func (db *DB) valuePath(id uint32) string {
+	return filepath.Join(db.valuesPath(), fmt.Sprintf("%08d%s", id, valueExt))
+}
+
+// valueIDs lists the ids of all value files in a directory in ascending order
+func valueIDs(dir string) ([]uint32, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint32
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), valueExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), valueExt), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, uint32(id))
+	}
+
+	return ids, nil
+}
+
+// encode serializes a pointer
+func (p *pointer) encode() []byte {
+	data := make([]byte, pointerSize)
+
+	binary.LittleEndian.PutUint32(data[0:], p.file)
+	binary.LittleEndian.PutUint64(data[4:], uint64(p.offset))
+	binary.LittleEndian.PutUint64(data[12:], uint64(p.size))
+	binary.LittleEndian.PutUint32(data[20:], p.crc)
+
+	return data
+}
+
+// decodePointer deserializes a pointer
+func decodePointer(data []byte) (*pointer, error) {
+	if len(data) != pointerSize {
+		return nil, ErrCorrupt
+	}
+
+	return &pointer{
+		file:   binary.LittleEndian.Uint32(data[0:]),
+		offset: int64(binary.LittleEndian.Uint64(data[4:])),
+		size:   int64(binary.LittleEndian.Uint64(data[12:])),
+		crc:    binary.LittleEndian.Uint32(data[20:]),
+	}, nil
+}
+
+// valueReader reads a value from a value file, verifying its checksum once it has been read
+type valueReader struct {
+	file   *os.File
+	reader *io.SectionReader
+	hash   hash.Hash32 // nil if the value is not being read from the start
+	crc    uint32
+}
+
+func (r *valueReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+
+	if r.hash == nil {
+		return n, err
+	}
+
+	r.hash.Write(p[:n])
+
+	if err == io.EOF && r.hash.Sum32() != r.crc {
+		return n, ErrCorrupt
+	}
+
+	return n, err
+}
+
+func (r *valueReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.reader.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+
+	// the checksum can only be verified if the whole value is read
+	r.hash = nil
+
+	if pos == 0 {
+		r.hash = crc32.NewIEEE()
+	}
+
+	return pos, nil
+}
+
+func (r *valueReader) Close() error {
+	return r.file.Close()
+}
+
+// bytesReader reads a value held in memory
+type bytesReader struct {
+	*bytes.Reader
+}
+
+func (r bytesReader) Close() error {
+	return nil
+}
@@ -0,0 +1,200 @@
+package lunar
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetReader(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	value := make([]byte, 1<<20)
+	rand.Read(value)
+
+	require.Nil(t, db.SetReader([]byte("test-key"), bytes.NewReader(value), int64(len(value))))
+
+	r, err := db.GetReader([]byte("test-key"))
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	require.Nil(t, r.Close())
+
+	assert.Equal(t, value, data)
+
+	// values are read transparently by get
+	data, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, value, data)
+
+	// values stored in the data file can also be read
+	require.Nil(t, db.Sets("test-small", []byte("test")))
+
+	r, err = db.GetReader([]byte("test-small"))
+	require.Nil(t, err)
+
+	data, err = ioutil.ReadAll(r)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), data)
+
+	// short readers are rejected
+	err = db.SetReader([]byte("test-short"), strings.NewReader("test"), 8)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = db.Gets("test-short")
+	assert.Equal(t, ErrNotFound, err)
+
+	// overwritten values are removed by compaction, but open readers can still read them
+	r, err = db.GetReader([]byte("test-key"))
+	require.Nil(t, err)
+
+	require.Nil(t, db.SetReader([]byte("test-key"), strings.NewReader("test-2"), 6))
+	require.Nil(t, db.Compact())
+
+	ids, err := valueIDs(db.valuesPath())
+	require.Nil(t, err)
+	assert.Len(t, ids, 1)
+
+	data, err = ioutil.ReadAll(r)
+	require.Nil(t, err)
+	require.Nil(t, r.Close())
+	assert.Equal(t, value, data)
+
+	data, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-2"), data)
+
+	require.Nil(t, db.Deletes("test-key"))
+	require.Nil(t, db.Compact())
+
+	ids, err = valueIDs(db.valuesPath())
+	require.Nil(t, err)
+	assert.Len(t, ids, 0)
+
+	// values persist after reopening
+	require.Nil(t, db.SetReader([]byte("test-key"), strings.NewReader("test-3"), 6))
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	data, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-3"), data)
+}
+
+func TestCompareAndSwapReader(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	version, err := db.CompareAndSwapReader([]byte("test-key"), strings.NewReader("test-1234"), 9, 0)
+	require.Nil(t, err)
+
+	_, err = db.CompareAndSwapReader([]byte("test-key"), strings.NewReader("test-5678"), 9, 0)
+	assert.Equal(t, ErrVersionMismatch, err)
+
+	// the value file of a failed swap is removed
+	ids, err := valueIDs(db.valuesPath())
+	require.Nil(t, err)
+	assert.Len(t, ids, 1)
+
+	r, current, err := db.GetReaderVersion([]byte("test-key"))
+	require.Nil(t, err)
+	assert.Equal(t, version, current)
+
+	// readers can seek within the value
+	_, err = r.(io.Seeker).Seek(5, io.SeekStart)
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("1234"), data)
+
+	_, err = r.(io.Seeker).Seek(0, io.SeekStart)
+	require.Nil(t, err)
+
+	data, err = ioutil.ReadAll(r)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-1234"), data)
+	require.Nil(t, r.Close())
+
+	_, err = db.CompareAndSwapReaderTTL([]byte("test-key"), strings.NewReader("test-5678"), 9, time.Minute, version)
+	require.Nil(t, err)
+
+	expires, err := db.Expires([]byte("test-key"))
+	require.Nil(t, err)
+	assert.False(t, expires.IsZero())
+
+	require.Nil(t, db.SetReaderTTL([]byte("test-key"), strings.NewReader("test"), 4, time.Minute))
+
+	data, err = db.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), data)
+}
+
+func TestBackupChangesOversized(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	// a pointer to a value that is too large to be stored in a data file
+	var h header.Header
+	h.SetFlags(header.FlagPointer)
+
+	key := []byte("test-large")
+	p := pointer{file: 1, size: table.MaxStep}
+
+	_, _, err = db.insert(key, record(&h, key, p.encode()))
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key", []byte("test")))
+
+	var buf bytes.Buffer
+
+	_, err = db.Backup(&buf, 0)
+	require.Nil(t, err)
+
+	rdb, err := Open("test-restore.db")
+	require.Nil(t, err)
+
+	defer os.Remove("test-restore.db")
+	defer os.Remove("test-restore.db.hint")
+	defer rdb.Close()
+
+	require.Nil(t, rdb.restore(&buf))
+
+	_, err = rdb.Get(key)
+	assert.Equal(t, ErrNotFound, err)
+
+	value, err := rdb.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), value)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := db.Changes(0)
+	require.Nil(t, err)
+
+	e, err := c.Next(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-key"), e.Key)
+}
@@ -0,0 +1,290 @@
+package lunar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+)
+
+// Problem describes an issue found when verifying a database
+type Problem struct {
+	Segment uint32
+	Offset  int64
+	Key     []byte
+	Reason  string
+}
+
+func (p Problem) String() string {
+	if p.Key != nil {
+		return fmt.Sprintf("segment %d offset %d key %q: %s", p.Segment, p.Offset, p.Key, p.Reason)
+	}
+
+	return fmt.Sprintf("segment %d offset %d: %s", p.Segment, p.Offset, p.Reason)
+}
+
+// VerifyReport describes the outcome of verifying a database
+type VerifyReport struct {
+	Segments int       // number of data files checked
+	Records  int       // number of valid records
+	Keys     int       // number of index entries checked
+	Problems []Problem // issues found
+}
+
+// OK returns true if no problems were found
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify checks every record written to the database, and that
+// every index entry points at a valid record with a matching key.
+// Writes made while verifying are not checked.
+// Compaction will be blocked until verification is complete
+func (db *DB) Verify(ctx context.Context) (*VerifyReport, error) {
+	db.snapshot.RLock()
+	defer db.snapshot.RUnlock()
+
+	v := newVerifier()
+	s, ends, release := db.freeze()
+	defer release()
+
+	for _, id := range s.ids() {
+		err := v.walk(ctx, id, s.tables[id], ends[id])
+		if err != nil {
+			return v.report, err
+		}
+	}
+
+	var err error
+
+	db.index.Iterate(nil, func(key []byte, value interface{}) {
+		e, ok := value.(*entry)
+		if !ok || err != nil {
+			return
+		}
+
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+
+		// skip entries written to segments created after verification started
+		if s.tables[e.segment] == nil && db.table(e.segment) != nil {
+			return
+		}
+
+		v.entry(key, e, s.tables[e.segment])
+	})
+
+	return v.report, err
+}
+
+// Check verifies the data files of a database that is not open.
+// The same options used to open the database should be provided
+func Check(path string, opts ...func(*DB) error) (*VerifyReport, error) {
+	db := DB{logger: table.NopLogger{}, metrics: nopMetrics{}}
+
+	for _, opt := range opts {
+		err := opt(&db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db.path = path
+
+	if !exists(path) {
+		return nil, os.ErrNotExist
+	}
+
+	ids := []uint32{0}
+
+	if db.segmented() {
+		var err error
+
+		ids, err = segmentIDs(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range ids {
+		err := checkFormat(db.dataPath(id))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	v := newVerifier()
+
+	// the index is rebuilt from the records found in the data files
+	index := make(map[string]*entry)
+	tables := make(map[uint32]*table.Table)
+
+	defer func() {
+		for _, t := range tables {
+			t.Close()
+		}
+	}()
+
+	for _, id := range ids {
+		t, err := db.openTable(db.dataPath(id))
+		if err != nil {
+			return v.report, err
+		}
+
+		tables[id] = t
+
+		v.visit = func(key []byte, e *entry) {
+			index[string(key)] = e
+		}
+
+		err = v.walk(context.Background(), id, t, t.Size())
+		if err != nil {
+			return v.report, err
+		}
+	}
+
+	for key, e := range index {
+		v.entry([]byte(key), e, tables[e.segment])
+	}
+
+	return v.report, nil
+}
+
+type verifier struct {
+	report  *VerifyReport
+	records map[uint32]map[int64]int64 // size of every record by offset, for each segment
+	ends    map[uint32]int64           // end of the last record walked, for each segment
+	visit   func(key []byte, e *entry)
+}
+
+func newVerifier() *verifier {
+	return &verifier{
+		report:  &VerifyReport{},
+		records: make(map[uint32]map[int64]int64),
+		ends:    make(map[uint32]int64),
+	}
+}
+
+func (v *verifier) problem(id uint32, offset int64, key []byte, reason string) {
+	v.report.Problems = append(v.report.Problems, Problem{
+		Segment: id,
+		Offset:  offset,
+		Key:     key,
+		Reason:  reason,
+	})
+}
+
+// walk checks every record in a table up to the end offset
+func (v *verifier) walk(ctx context.Context, id uint32, t *table.Table, end int64) error {
+	var pos int64
+
+	records := make(map[int64]int64)
+	v.records[id] = records
+	v.report.Segments++
+
+	defer func() {
+		v.ends[id] = pos
+	}()
+
+	for pos+header.HeaderSize <= end {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		hd, err := t.Read(header.HeaderSize, pos)
+		if err != nil {
+			return err
+		}
+
+		if empty(hd) {
+			return nil
+		}
+
+		h := header.Deserialize(hd)
+
+		if !sane(h, pos, end) {
+			// the location of the next record cannot be determined
+			v.problem(id, pos, nil, "invalid record header")
+			return nil
+		}
+
+		data, err := t.Read(h.TotalSize(), pos)
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, h.KeySize())
+		copy(key, data[header.HeaderSize:h.DataOffset()])
+
+		if !header.Valid(data) {
+			v.problem(id, pos, key, "checksum mismatch")
+		} else {
+			v.report.Records++
+		}
+
+		records[pos] = h.TotalSize()
+
+		if v.visit != nil {
+			v.visit(key, &entry{segment: id, offset: pos, size: h.TotalSize()})
+		}
+
+		pos = pos + h.TotalSize()
+	}
+
+	if pos < end {
+		data, err := t.Read(end-pos, pos)
+		if err != nil {
+			return err
+		}
+
+		if !empty(data) {
+			v.problem(id, pos, nil, "record exceeds end of data")
+		}
+	}
+
+	return nil
+}
+
+// entry checks that an index entry points at a record with a matching key
+func (v *verifier) entry(key []byte, e *entry, t *table.Table) {
+	records, ok := v.records[e.segment]
+	if !ok {
+		v.report.Keys++
+		v.problem(e.segment, e.offset, key, "segment does not exist")
+		return
+	}
+
+	// the entry was written after verification started
+	if e.offset >= v.ends[e.segment] {
+		return
+	}
+
+	v.report.Keys++
+
+	size, ok := records[e.offset]
+	if !ok {
+		v.problem(e.segment, e.offset, key, "index entry does not point to the start of a record")
+		return
+	}
+
+	if size != e.size {
+		v.problem(e.segment, e.offset, key, "index entry size does not match record")
+		return
+	}
+
+	data, err := t.Read(header.HeaderSize+int64(len(key)), e.offset)
+	if err != nil {
+		v.problem(e.segment, e.offset, key, err.Error())
+		return
+	}
+
+	if !bytes.Equal(data[header.HeaderSize:], key) {
+		v.problem(e.segment, e.offset, key, "record key does not match index")
+	}
+}
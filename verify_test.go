@@ -0,0 +1,117 @@
+package lunar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i%5), []byte(fmt.Sprintf("test-%d", i)))
+		require.Nil(t, err)
+	}
+
+	report, err := db.Verify(context.Background())
+	require.Nil(t, err)
+
+	assert.True(t, report.OK())
+	assert.Equal(t, 1, report.Segments)
+	assert.Equal(t, 10, report.Records)
+	assert.Equal(t, 5, report.Keys)
+
+	// corrupt a value and point an index entry to the middle of a record
+	e := db.lookup([]byte("test-key-1"))
+	require.Nil(t, db.active().WriteAt([]byte("x"), e.offset+e.size-1))
+
+	db.index.MustInsert([]byte("test-key-2"), &entry{offset: e.offset + 1, size: e.size})
+
+	report, err = db.Verify(context.Background())
+	require.Nil(t, err)
+
+	assert.False(t, report.OK())
+	assert.Equal(t, 9, report.Records)
+	require.Len(t, report.Problems, 2)
+	assert.Equal(t, "checksum mismatch", report.Problems[0].Reason)
+	assert.Equal(t, []byte("test-key-1"), report.Problems[0].Key)
+	assert.Equal(t, []byte("test-key-2"), report.Problems[1].Key)
+
+	// cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = db.Verify(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestVerifyConcurrentGrowth(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	done := grow(t, db)
+
+	for verified := 0; ; verified++ {
+		select {
+		case <-done:
+			if verified > 0 {
+				return
+			}
+		default:
+		}
+
+		// records that are still being written are not reported as corrupt
+		report, err := db.Verify(context.Background())
+		require.Nil(t, err)
+		assert.True(t, report.OK(), report.Problems)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	defer os.RemoveAll("test-segments")
+
+	db, err := Open("test-segments", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	for i := 0; i < 20; i++ {
+		err = db.Sets(fmt.Sprintf("test-key-%d", i), make([]byte, 200))
+		require.Nil(t, err)
+	}
+
+	e := db.lookup([]byte("test-key-10"))
+
+	require.Nil(t, db.Close())
+
+	report, err := Check("test-segments", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	assert.True(t, report.OK())
+	assert.Equal(t, 20, report.Records)
+	assert.Equal(t, 20, report.Keys)
+
+	fd, err := os.OpenFile(segmentPath("test-segments", e.segment), os.O_RDWR, 0644)
+	require.Nil(t, err)
+
+	_, err = fd.WriteAt([]byte{0xFF, 0xFF}, e.offset+40)
+	require.Nil(t, err)
+
+	require.Nil(t, fd.Close())
+
+	report, err = Check("test-segments", SegmentSize(1<<10))
+	require.Nil(t, err)
+
+	require.Len(t, report.Problems, 1)
+	assert.Equal(t, e.segment, report.Problems[0].Segment)
+	assert.Equal(t, e.offset, report.Problems[0].Offset)
+	assert.Equal(t, "invalid record header", report.Problems[0].Reason)
+}
@@ -0,0 +1,212 @@
+package lunar
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+)
+
+var (
+	// ErrVersionMismatch the current version of the key does not match the expected version
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+// Version returns the version of a key's current value. The version
+// changes every time the key is written, or moved by compaction
+func (db *DB) Version(key []byte) (uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.version(key)
+}
+
+// GetVersion get a value and its version by key
+func (db *DB) GetVersion(key []byte) ([]byte, uint64, error) {
+	start := time.Now()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, version, err := db.get(key)
+
+	db.metrics.Get(time.Since(start))
+
+	return value, version, err
+}
+
+// CompareAndSwap set value by key if the key's current version matches the
+// given version, returning the new version. A version of zero requires
+// that the key does not exist. If the version does not match,
+// ErrVersionMismatch is returned
+func (db *DB) CompareAndSwap(key, value []byte, version uint64) (uint64, error) {
+	var h header.Header
+
+	return db.swap(key, record(&h, key, value), version)
+}
+
+// CompareAndSwapTTL set value by key if the key's current version
+// matches the given version, which will expire after the given duration
+func (db *DB) CompareAndSwapTTL(key, value []byte, ttl time.Duration, version uint64) (uint64, error) {
+	var h header.Header
+
+	h.SetExpires(time.Now().Add(ttl).UnixNano())
+
+	return db.swap(key, record(&h, key, value), version)
+}
+
+// CompareAndDelete delete a value by key if the key's current version matches the given version
+func (db *DB) CompareAndDelete(key []byte, version uint64) error {
+	var h header.Header
+
+	h.SetXmax(1)
+
+	_, err := db.swap(key, record(&h, key, nil), version)
+
+	return err
+}
+
+// swap writes a record if the key's current version matches.
+// writes are blocked until the record has been indexed
+func (db *DB) swap(key, data []byte, version uint64) (uint64, error) {
+	err := checkKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+
+	updated, err := db.exchange(key, data, version)
+
+	db.metrics.Set(time.Since(start))
+
+	return updated, err
+}
+
+// exchange writes a record if the key's current version matches
+func (db *DB) exchange(key, data []byte, version uint64) (uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	err := db.writable()
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := db.version(key)
+	if err != nil && err != ErrNotFound {
+		return 0, err
+	}
+
+	if current != version {
+		return 0, ErrVersionMismatch
+	}
+
+	// a deleted key has no version
+	if header.Deserialize(data).Xmax() > 0 && current == 0 {
+		return 0, ErrNotFound
+	}
+
+	data, err = db.separate(data)
+	if err != nil {
+		return 0, err
+	}
+
+	seg, off, err := db.insert(key, data)
+	if err != nil {
+		return 0, err
+	}
+
+	return versionOf(db.position(seg, off), header.Deserialize(data).Checksum()), nil
+}
+
+// read reads the record of a key's current value and its version. db.mu
+// must be held. the record is only valid until db.mu is released
+func (db *DB) read(key []byte) (*header.Header, []byte, uint64, error) {
+	e := db.lookup(key)
+	if e == nil || !e.live() {
+		return nil, nil, 0, ErrNotFound
+	}
+
+	t := db.table(e.segment)
+	if t == nil {
+		return nil, nil, 0, ErrNotFound
+	}
+
+	data, err := t.Read(e.size, e.offset)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	h := header.Deserialize(data[:header.HeaderSize])
+
+	return h, data, versionOf(db.position(e.segment, e.offset), h.Checksum()), nil
+}
+
+// get reads a key's current value and its version. db.mu must be held
+func (db *DB) get(key []byte) ([]byte, uint64, error) {
+	h, data, version, err := db.read(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if h.Pointer() {
+		value, err := db.readValue(data[h.DataOffset():])
+		return value, version, err
+	}
+
+	if h.Merge() {
+		value, err := db.merged(key, h, data)
+		return value, version, err
+	}
+
+	// copy the value out of the mapping, as the
+	// segment may be removed by compaction
+	value := make([]byte, h.DataSize())
+	copy(value, data[h.DataOffset():])
+
+	return value, version, nil
+}
+
+// version returns the version of a key's current value,
+// or zero if it does not exist. db.mu must be held
+func (db *DB) version(key []byte) (uint64, error) {
+	e := db.lookup(key)
+	if e == nil || !e.live() {
+		return 0, ErrNotFound
+	}
+
+	t := db.table(e.segment)
+	if t == nil {
+		return 0, ErrNotFound
+	}
+
+	data, err := t.Read(header.HeaderSize, e.offset)
+	if err != nil {
+		return 0, err
+	}
+
+	return versionOf(db.position(e.segment, e.offset), header.Deserialize(data).Checksum()), nil
+}
+
+// versionOf derives a version from the position and checksum of a record,
+// so a version is not reused if compaction moves a different value to the
+// position of a previous version
+func versionOf(position uint64, crc uint32) uint64 {
+	var data [12]byte
+
+	binary.LittleEndian.PutUint64(data[0:], position)
+	binary.LittleEndian.PutUint32(data[8:], crc)
+
+	h := fnv.New64a()
+	h.Write(data[:])
+
+	// zero is reserved for keys that do not exist
+	if v := h.Sum64(); v != 0 {
+		return v
+	}
+
+	return 1
+}
@@ -0,0 +1,71 @@
+package lunar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	db, err := Open("test.db")
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	_, err = db.Version([]byte("test-key"))
+	assert.Equal(t, ErrNotFound, err)
+
+	// a version of zero creates the key
+	v1, err := db.CompareAndSwap([]byte("test-key"), []byte("test-1"), 0)
+	require.Nil(t, err)
+	assert.NotZero(t, v1)
+
+	_, err = db.CompareAndSwap([]byte("test-key"), []byte("test-1"), 0)
+	assert.Equal(t, ErrVersionMismatch, err)
+
+	value, version, err := db.GetVersion([]byte("test-key"))
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-1"), value)
+	assert.Equal(t, v1, version)
+
+	v2, err := db.CompareAndSwap([]byte("test-key"), []byte("test-2"), v1)
+	require.Nil(t, err)
+	assert.NotEqual(t, v1, v2)
+
+	_, err = db.CompareAndSwap([]byte("test-key"), []byte("test-3"), v1)
+	assert.Equal(t, ErrVersionMismatch, err)
+
+	// a plain write changes the version
+	err = db.Sets("test-key", []byte("test-3"))
+	require.Nil(t, err)
+
+	v3, err := db.Version([]byte("test-key"))
+	require.Nil(t, err)
+	assert.NotEqual(t, v2, v3)
+
+	err = db.CompareAndDelete([]byte("test-key"), v2)
+	assert.Equal(t, ErrVersionMismatch, err)
+
+	err = db.CompareAndDelete([]byte("test-key"), v3)
+	require.Nil(t, err)
+
+	_, err = db.Gets("test-key")
+	assert.Equal(t, ErrNotFound, err)
+
+	err = db.CompareAndDelete([]byte("test-key"), 0)
+	assert.Equal(t, ErrNotFound, err)
+
+	// versions are unchanged when reopened
+	v4, err := db.CompareAndSwap([]byte("test-key"), []byte("test-4"), 0)
+	require.Nil(t, err)
+
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db")
+	require.Nil(t, err)
+
+	version, err = db.Version([]byte("test-key"))
+	require.Nil(t, err)
+	assert.Equal(t, v4, version)
+}
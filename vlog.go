@@ -0,0 +1,342 @@
+package lunar
+
+import (
+	"hash/crc32"
+	"os"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/purehyperbole/lunar/table"
+)
+
+const (
+	// valueLogSize the size a value log file can grow to before a new one is created
+	valueLogSize = 1 << 28
+)
+
+// references the current values stored in a value file
+type references struct {
+	size int64    // total size of the values
+	keys [][]byte // keys whose current value is stored in the file
+}
+
+// CompactValues reclaims space used by the value log. The live values of
+// every value file that is at least half garbage are moved to the active
+// value log, and value files that are no longer referenced are removed.
+// It runs independently of Compact, which only moves the pointers to values.
+// Previous versions of keys whose values have been removed are left out of
+// backups and changes
+func (db *DB) CompactValues() error {
+	db.mu.RLock()
+	refs, err := db.references()
+	db.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	for id, r := range refs {
+		if db.pendingValue(id) {
+			continue
+		}
+
+		fi, err := os.Stat(db.valuePath(id))
+		if err != nil {
+			return err
+		}
+
+		if r.size*2 > fi.Size() {
+			continue
+		}
+
+		for _, key := range r.keys {
+			err = db.relocateValue(key, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = db.removeValues()
+	if err != nil {
+		return err
+	}
+
+	db.logger.Info("compacted value log", "path", db.valuesPath())
+
+	return nil
+}
+
+// relocateValue moves a key's value to the active
+// value log if it is stored in the given value file
+func (db *DB) relocateValue(key []byte, id uint32) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	h, data, _, err := db.read(key)
+	if err == ErrNotFound || err == nil && !h.Pointer() {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	p, err := decodePointer(data[h.DataOffset():])
+	if err != nil || p.file != id {
+		return err
+	}
+
+	value, err := db.readValue(data[h.DataOffset():])
+	if err != nil {
+		return err
+	}
+
+	np, err := db.appendValue(value)
+	if err != nil {
+		return err
+	}
+
+	h.SetXmin(0)
+
+	_, _, err = db.insert(key, record(h, key, np.encode()))
+
+	return err
+}
+
+// references returns the size and keys of the current values stored in
+// each value file, including values that merge operands are applied to.
+// db.mu must be held
+func (db *DB) references() (map[uint32]*references, error) {
+	var err error
+
+	refs := make(map[uint32]*references)
+
+	db.index.Iterate(nil, func(key []byte, v interface{}) {
+		if err != nil {
+			return
+		}
+
+		e, ok := v.(*entry)
+		if !ok || !e.live() {
+			return
+		}
+
+		var data []byte
+
+		data, err = db.table(e.segment).Read(e.size, e.offset)
+		if err != nil {
+			return
+		}
+
+		h := header.Deserialize(data)
+
+		// the value that merge operands are applied to is also referenced
+		for h.Merge() && h.HasPrevious() {
+			h, data, err = db.previous(h)
+			if err != nil {
+				return
+			}
+		}
+
+		if !h.Pointer() {
+			return
+		}
+
+		var p *pointer
+
+		p, err = decodePointer(data[h.DataOffset():])
+		if err != nil {
+			return
+		}
+
+		r := refs[p.file]
+		if r == nil {
+			r = &references{}
+			refs[p.file] = r
+		}
+
+		r.size = r.size + p.size
+		r.keys = append(r.keys, key)
+	})
+
+	return refs, err
+}
+
+// separate moves the value of a record that is larger than the value threshold
+// to the value log, returning a record that points to it. db.mu must be held
+func (db *DB) separate(data []byte) ([]byte, error) {
+	if db.valueThreshold < 1 {
+		return data, nil
+	}
+
+	h := header.Deserialize(data)
+
+	if h.Pointer() || h.Merge() || h.Xmax() > 0 || h.DataSize() <= db.valueThreshold {
+		return data, nil
+	}
+
+	p, err := db.appendValue(data[h.DataOffset():])
+	if err != nil {
+		return nil, err
+	}
+
+	h.SetFlags(header.FlagPointer)
+
+	return record(h, data[header.HeaderSize:h.DataOffset()], p.encode()), nil
+}
+
+// inline returns a copy of a pointer record with the value it points to
+// stored in place of the pointer, so the record can be read without the
+// value files. The record is returned unchanged if it is not a pointer.
+// ErrValueTooLarge is returned if the value cannot be stored in a data file
+func (db *DB) inline(data []byte) ([]byte, error) {
+	h := header.Deserialize(data)
+	if !h.Pointer() {
+		return data, nil
+	}
+
+	p, err := decodePointer(data[h.DataOffset():])
+	if err != nil {
+		return nil, err
+	}
+
+	if p.size > table.MaxStep-h.DataOffset() {
+		return nil, ErrValueTooLarge
+	}
+
+	value, err := db.readValue(data[h.DataOffset():])
+	if err != nil {
+		return nil, err
+	}
+
+	h.SetFlags(0)
+
+	return record(h, data[header.HeaderSize:h.DataOffset()], value), nil
+}
+
+// superseded returns true if a pointer record could not be inlined because
+// its value file has been removed. CompactValues only removes value files
+// that no current record refers to, so the record has been replaced by a
+// newer version of its key and can be left out of backups and changes
+func (db *DB) superseded(err error, key []byte, id uint32, offset int64) bool {
+	if !os.IsNotExist(err) {
+		return false
+	}
+
+	e := db.lookup(key)
+
+	return e == nil || e.segment != id || e.offset != offset
+}
+
+// oversized returns true if a pointer record could not be inlined because
+// its value is too large to be stored in a data file, logging a warning.
+// The record is left out of backups and changes
+func (db *DB) oversized(err error, key []byte) bool {
+	if err != ErrValueTooLarge {
+		return false
+	}
+
+	db.logger.Warn("value is too large to be included in backups or changes", "path", db.path, "key", key)
+
+	return true
+}
+
+// appendValue appends a value to the value log,
+// creating a new value log file if it is full
+func (db *DB) appendValue(value []byte) (*pointer, error) {
+	db.values.mu.Lock()
+	defer db.values.mu.Unlock()
+
+	size := int64(len(value))
+
+	if db.values.log == nil || db.values.logSize > 0 && db.values.logSize+size > valueLogSize {
+		err := db.rotateValues()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := db.values.log.WriteAt(value, db.values.logSize)
+	if err != nil {
+		return nil, err
+	}
+
+	p := pointer{
+		file:   db.values.logID,
+		offset: db.values.logSize,
+		size:   size,
+		crc:    crc32.ChecksumIEEE(value),
+	}
+
+	db.values.logSize = db.values.logSize + size
+
+	return &p, nil
+}
+
+// rotateValues closes the value log and creates a new value log file.
+// the active value log file is kept pending, so it is not removed while
+// it is being written to. db.values.mu must be held
+func (db *DB) rotateValues() error {
+	err := db.closeLog()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(db.valuesPath(), 0755)
+	if err != nil {
+		return err
+	}
+
+	id, err := db.nextValue()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(db.valuePath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		delete(db.values.pending, id)
+		return err
+	}
+
+	db.values.log = f
+	db.values.logID = id
+	db.values.logSize = 0
+
+	return nil
+}
+
+// closeValues closes the value log
+func (db *DB) closeValues() error {
+	db.values.mu.Lock()
+	defer db.values.mu.Unlock()
+
+	return db.closeLog()
+}
+
+// closeLog closes the value log file. db.values.mu must be held
+func (db *DB) closeLog() error {
+	if db.values.log == nil {
+		return nil
+	}
+
+	err := db.values.log.Close()
+	if err != nil {
+		return err
+	}
+
+	delete(db.values.pending, db.values.logID)
+
+	db.values.log = nil
+
+	return nil
+}
+
+// pendingValue returns true if a value file is being written to
+func (db *DB) pendingValue(id uint32) bool {
+	db.values.mu.Lock()
+	defer db.values.mu.Unlock()
+
+	_, ok := db.values.pending[id]
+
+	return ok
+}
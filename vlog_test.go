@@ -0,0 +1,198 @@
+package lunar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/purehyperbole/lunar/header"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueThreshold(t *testing.T) {
+	db, err := Open("test.db", ValueThreshold(64))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	large := bytes.Repeat([]byte("x"), 128)
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, db.Sets(fmt.Sprintf("test-key-%d", i), large))
+	}
+
+	require.Nil(t, db.Sets("test-small", []byte("test")))
+
+	var b Batch
+	b.Set([]byte("test-batch"), large)
+	require.Nil(t, db.Apply(&b))
+
+	// only the pointers to large values are stored in the data file
+	assert.Less(t, db.Stats().Live, int64(len(large)*11))
+
+	for i := 0; i < 10; i++ {
+		value, err := db.Gets(fmt.Sprintf("test-key-%d", i))
+		require.Nil(t, err)
+		assert.Equal(t, large, value)
+	}
+
+	value, err := db.Gets("test-batch")
+	require.Nil(t, err)
+	assert.Equal(t, large, value)
+
+	value, err = db.Gets("test-small")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), value)
+
+	// backups contain the values
+	var buf bytes.Buffer
+
+	_, err = db.Backup(&buf, 0)
+	require.Nil(t, err)
+
+	defer os.Remove("test-restore.db")
+	defer os.Remove("test-restore.db.hint")
+
+	require.Nil(t, Restore(&buf, "test-restore.db"))
+
+	rdb, err := Open("test-restore.db")
+	require.Nil(t, err)
+
+	value, err = rdb.Gets("test-key-0")
+	require.Nil(t, err)
+	assert.Equal(t, large, value)
+	require.Nil(t, rdb.Close())
+
+	// values are still readable after reopening
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db", ValueThreshold(64))
+	require.Nil(t, err)
+
+	value, err = db.Gets("test-key-9")
+	require.Nil(t, err)
+	assert.Equal(t, large, value)
+
+	// the value log is rewritten once most of its values are garbage
+	for i := 0; i < 8; i++ {
+		require.Nil(t, db.Deletes(fmt.Sprintf("test-key-%d", i)))
+	}
+
+	ids, err := valueIDs(db.valuesPath())
+	require.Nil(t, err)
+	require.Len(t, ids, 1)
+
+	require.Nil(t, db.CompactValues())
+
+	ids, err = valueIDs(db.valuesPath())
+	require.Nil(t, err)
+	require.Len(t, ids, 1)
+
+	fi, err := os.Stat(db.valuePath(ids[0]))
+	require.Nil(t, err)
+	assert.Equal(t, int64(len(large)*3), fi.Size())
+
+	for _, key := range []string{"test-key-8", "test-key-9", "test-batch"} {
+		value, err = db.Gets(key)
+		require.Nil(t, err)
+		assert.Equal(t, large, value)
+	}
+}
+
+func TestInlineMissingValue(t *testing.T) {
+	db, err := Open("test.db", ValueThreshold(64))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	require.Nil(t, db.Sets("test-key", bytes.Repeat([]byte("x"), 128)))
+	require.Nil(t, db.closeValues())
+	require.Nil(t, os.RemoveAll(db.valuesPath()))
+
+	// the pointer is not sent to a receiver that does not have the value file
+	_, err = db.Backup(&bytes.Buffer{}, 0)
+	assert.NotNil(t, err)
+
+	c, err := db.Changes(0)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = c.Next(ctx)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, context.DeadlineExceeded, err)
+}
+
+func TestCompactValuesSuperseded(t *testing.T) {
+	defer os.Remove("test-restore.db")
+
+	db, err := Open("test.db", ValueThreshold(64))
+	defer cleanup(db)
+
+	require.Nil(t, err)
+
+	old := bytes.Repeat([]byte("x"), 128)
+	large := bytes.Repeat([]byte("y"), 128)
+
+	var b Batch
+	b.Set([]byte("test-small"), []byte("test"))
+	b.Set([]byte("test-key"), old)
+	require.Nil(t, db.Apply(&b))
+
+	require.Nil(t, db.Close())
+
+	db, err = Open("test.db", ValueThreshold(64))
+	require.Nil(t, err)
+
+	// the value file storing the old value is removed
+	require.Nil(t, db.Sets("test-key", large))
+	require.Nil(t, db.CompactValues())
+
+	ids, err := valueIDs(db.valuesPath())
+	require.Nil(t, err)
+	require.Len(t, ids, 1)
+
+	// the superseded pointer is left out of backups
+	var buf bytes.Buffer
+
+	_, err = db.Backup(&buf, 0)
+	require.Nil(t, err)
+
+	require.Nil(t, Restore(&buf, "test-restore.db"))
+
+	rdb, err := Open("test-restore.db")
+	require.Nil(t, err)
+
+	value, err := rdb.Gets("test-key")
+	require.Nil(t, err)
+	assert.Equal(t, large, value)
+
+	value, err = rdb.Gets("test-small")
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test"), value)
+
+	require.Nil(t, rdb.Close())
+
+	// and changes, without leaving the rest of its batch incomplete
+	c, err := db.Changes(0)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	e, err := c.Next(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-small"), e.Key)
+	assert.Equal(t, uint64(0), header.Deserialize(e.record).Xmin())
+	assert.True(t, header.Valid(e.record))
+
+	e, err = c.Next(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("test-key"), e.Key)
+	assert.Equal(t, large, e.Value)
+}